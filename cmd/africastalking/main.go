@@ -4,29 +4,37 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
-	"os/signal"
 	"path"
 	"strconv"
-	"syscall"
 
 	"git.defalsify.org/vise.git/engine"
 	"git.defalsify.org/vise.git/lang"
-	"git.defalsify.org/vise.git/logging"
 	"git.defalsify.org/vise.git/resource"
 
+	"git.grassecon.net/urdt/ussd/common"
 	"git.grassecon.net/urdt/ussd/config"
 	"git.grassecon.net/urdt/ussd/initializers"
 	"git.grassecon.net/urdt/ussd/internal/args"
 	"git.grassecon.net/urdt/ussd/internal/handlers"
+	"git.grassecon.net/urdt/ussd/internal/health"
+	httpserver "git.grassecon.net/urdt/ussd/internal/http"
 	"git.grassecon.net/urdt/ussd/internal/http/at"
+	"git.grassecon.net/urdt/ussd/internal/journal"
+	"git.grassecon.net/urdt/ussd/internal/lifecycle"
+	"git.grassecon.net/urdt/ussd/internal/metrics"
+	"git.grassecon.net/urdt/ussd/internal/notify"
+	"git.grassecon.net/urdt/ussd/internal/ratelimit"
+	"git.grassecon.net/urdt/ussd/internal/routing"
+	"git.grassecon.net/urdt/ussd/internal/sms"
 	"git.grassecon.net/urdt/ussd/internal/storage"
 	"git.grassecon.net/urdt/ussd/remote"
 )
 
 var (
-	logg          = logging.NewVanilla().WithDomain("AfricasTalking").WithContextKey("at-session-id")
+	logg          = common.NewContextLogger("AfricasTalking")
 	scriptDir     = path.Join("services", "registration")
 	build         = "dev"
 	menuSeparator = ": "
@@ -68,6 +76,15 @@ func main() {
 		os.Exit(1)
 	}
 
+	auth, err := httpserver.InboundAuthFromEnv("AT")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gateway auth config error: %v", err)
+		os.Exit(1)
+	}
+	if !auth.Enabled() {
+		logg.Warnf("inbound gateway requests are not authenticated")
+	}
+
 	logg.Infof("start command", "build", build, "conn", connData, "resourcedir", resourceDir, "outputsize", size)
 
 	ctx := context.Background()
@@ -76,7 +93,9 @@ func main() {
 		fmt.Fprintf(os.Stderr, "default language set error: %v", err)
 		os.Exit(1)
 	}
-	ctx = context.WithValue(ctx, "Language", ln)
+	ctx = common.WithRequestContext(ctx, common.RequestContext{
+		Language: ln.Code,
+	})
 
 	pfp := path.Join(scriptDir, "pp.csv")
 
@@ -91,7 +110,10 @@ func main() {
 		cfg.EngineDebug = true
 	}
 
+	lc := lifecycle.NewManager(config.ShutdownTimeout)
+
 	menuStorageService := storage.NewMenuStorageService(connData, resourceDir)
+	lc.OnClose("storage", menuStorageService.Close)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, err.Error())
 		os.Exit(1)
@@ -108,7 +130,6 @@ func main() {
 		fmt.Fprintf(os.Stderr, err.Error())
 		os.Exit(1)
 	}
-	defer userdataStore.Close()
 
 	dbResource, ok := rs.(*resource.DbResource)
 	if !ok {
@@ -122,10 +143,15 @@ func main() {
 	}
 	lhs.SetDataStore(&userdataStore)
 
+	smsQueue, err := sms.QueueFromConfig(ctx, sms.NewStoreRecorder(userdataStore))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
+		fmt.Fprintf(os.Stderr, "sms queue error: %v", err)
 		os.Exit(1)
 	}
+	if smsQueue != nil {
+		lc.OnClose("sms queue", smsQueue.Close)
+		lhs.SetSmsQueue(smsQueue)
+	}
 
 	accountService := remote.AccountService{}
 	hl, err := lhs.GetHandler(&accountService)
@@ -139,34 +165,94 @@ func main() {
 		fmt.Fprintf(os.Stderr, err.Error())
 		os.Exit(1)
 	}
-	defer stateStore.Close()
 
 	rp := &at.ATRequestParser{}
 	bsh := handlers.NewBaseSessionHandler(cfg, rs, stateStore, userdataStore, rp, hl)
-	sh := at.NewATSessionHandler(bsh)
+	rateLimits, err := ratelimit.PoliciesFromConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rate limit config error: %v", err)
+		os.Exit(1)
+	}
+	lim := ratelimit.NewLimiter(stateStore, rateLimits)
+	tracker := handlers.NewSessionTracker(stateStore, userdataStore)
+	journalSink, err := journal.SinkFromConfig(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "journal open error: %v", err)
+		os.Exit(1)
+	}
+	if journalSink != nil {
+		lc.OnClose("journal", journalSink.Close)
+	}
+	jnl := journal.NewJournal(stateStore, journalSink)
+	var mh handlers.RequestHandler = bsh
+	if config.RoutingTable != "" {
+		table, err := routing.LoadTable(config.RoutingTable)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "routing table error: %v", err)
+			os.Exit(1)
+		}
+		router, err := routing.NewRouter(ctx, table, bsh, routing.Base{
+			Conn:           connData,
+			Config:         cfg,
+			UserdataStore:  userdataStore,
+			RequestParser:  rp,
+			AccountService: &accountService,
+			SmsQueue:       smsQueue,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "routing error: %v", err)
+			os.Exit(1)
+		}
+		lc.OnClose("routes", router.Close)
+		mh = router
+	}
+	// routed sessions keep their state in the state store of the route
+	sr, ok := mh.(handlers.StateResolver)
+	if ok {
+		jnl.WithStateResolver(sr)
+		lim.WithStateResolver(sr)
+	}
+	rh := handlers.WithMiddleware(mh, handlers.LogRequest, handlers.RecordMetrics, tracker.Middleware(), jnl.Middleware(), lim.Middleware())
+	sh := at.NewATSessionHandler(rh).WithUserdataStore(userdataStore)
+
+	checker := health.NewChecker(health.DefaultTimeout)
+	err = checker.AddStorageChecks(ctx, menuStorageService)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "health check setup error: %v", err)
+		os.Exit(1)
+	}
+	checker.Add("custodial service", health.UrlCheck(config.CustodialServiceURL))
+	checker.Add("data service", health.UrlCheck(config.DataServiceURL))
 
+	atEndpoint := initializers.GetEnv("AT_ENDPOINT", "/")
 	mux := http.NewServeMux()
-	mux.Handle(initializers.GetEnv("AT_ENDPOINT", "/"), sh)
+	mux.Handle(atEndpoint, auth.Handler(sh))
+	mux.Handle(initializers.GetEnv("AT_END_ENDPOINT", path.Join(atEndpoint, "end")), auth.Handler(at.NewATEndSessionHandler(tracker)))
+	webhook, err := notify.WebhookFromEnv(userdataStore, smsQueue)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "transfer webhook config error: %v", err)
+		os.Exit(1)
+	}
+	if webhook != nil {
+		mux.Handle(initializers.GetEnv("WEBHOOK_ENDPOINT", notify.DefaultEndpoint), webhook)
+	}
+	checker.Register(mux)
+	mux.Handle("/metrics", metrics.Default)
 
 	s := &http.Server{
 		Addr:    fmt.Sprintf("%s:%s", host, strconv.Itoa(int(port))),
 		Handler: mux,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
 	}
-	s.RegisterOnShutdown(sh.Shutdown)
+	lc.OnStop(s.Shutdown)
+	lc.Notify()
 
-	cint := make(chan os.Signal)
-	cterm := make(chan os.Signal)
-	signal.Notify(cint, os.Interrupt, syscall.SIGINT)
-	signal.Notify(cterm, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		select {
-		case _ = <-cint:
-		case _ = <-cterm:
-		}
-		s.Shutdown(ctx)
-	}()
 	err = s.ListenAndServe()
-	if err != nil {
+	if err != http.ErrServerClosed {
 		logg.Infof("Server closed with error", "err", err)
+		lc.Shutdown()
 	}
+	lc.Wait()
 }
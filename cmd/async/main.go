@@ -5,19 +5,24 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"os/signal"
 	"path"
-	"syscall"
+	"strconv"
+	"time"
 
 	"git.defalsify.org/vise.git/engine"
 	"git.defalsify.org/vise.git/lang"
 	"git.defalsify.org/vise.git/logging"
 	"git.defalsify.org/vise.git/resource"
 
+	"git.grassecon.net/urdt/ussd/common"
 	"git.grassecon.net/urdt/ussd/config"
 	"git.grassecon.net/urdt/ussd/initializers"
 	"git.grassecon.net/urdt/ussd/internal/args"
 	"git.grassecon.net/urdt/ussd/internal/handlers"
+	"git.grassecon.net/urdt/ussd/internal/journal"
+	"git.grassecon.net/urdt/ussd/internal/lifecycle"
+	"git.grassecon.net/urdt/ussd/internal/ratelimit"
+	"git.grassecon.net/urdt/ussd/internal/sms"
 	"git.grassecon.net/urdt/ussd/internal/storage"
 	"git.grassecon.net/urdt/ussd/remote"
 )
@@ -88,7 +93,9 @@ func main() {
 		fmt.Fprintf(os.Stderr, "default language set error: %v", err)
 		os.Exit(1)
 	}
-	ctx = context.WithValue(ctx, "Language", ln)
+	ctx = common.WithRequestContext(ctx, common.RequestContext{
+		Language: ln.Code,
+	})
 
 	pfp := path.Join(scriptDir, "pp.csv")
 
@@ -103,7 +110,10 @@ func main() {
 		cfg.EngineDebug = true
 	}
 
+	lc := lifecycle.NewManager(config.ShutdownTimeout)
+
 	menuStorageService := storage.NewMenuStorageService(connData, resourceDir)
+	lc.OnClose("storage", menuStorageService.Close)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, err.Error())
 		os.Exit(1)
@@ -120,7 +130,6 @@ func main() {
 		fmt.Fprintf(os.Stderr, err.Error())
 		os.Exit(1)
 	}
-	defer userdataStore.Close()
 
 	dbResource, ok := rs.(*resource.DbResource)
 	if !ok {
@@ -128,7 +137,21 @@ func main() {
 	}
 
 	lhs, err := handlers.NewLocalHandlerService(ctx, pfp, true, dbResource, cfg, rs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
 	lhs.SetDataStore(&userdataStore)
+
+	smsQueue, err := sms.QueueFromConfig(ctx, sms.NewStoreRecorder(userdataStore))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sms queue error: %v", err)
+		os.Exit(1)
+	}
+	if smsQueue != nil {
+		lc.OnClose("sms queue", smsQueue.Close)
+		lhs.SetSmsQueue(smsQueue)
+	}
 	accountService := remote.AccountService{}
 
 	hl, err := lhs.GetHandler(&accountService)
@@ -142,32 +165,52 @@ func main() {
 		fmt.Fprintf(os.Stderr, err.Error())
 		os.Exit(1)
 	}
-	defer stateStore.Close()
 
 	rp := &asyncRequestParser{
 		sessionId: sessionId,
 	}
-	sh := handlers.NewBaseSessionHandler(cfg, rs, stateStore, userdataStore, rp, hl)
+	bsh := handlers.NewBaseSessionHandler(cfg, rs, stateStore, userdataStore, rp, hl)
+	rateLimits, err := ratelimit.PoliciesFromConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rate limit config error: %v", err)
+		os.Exit(1)
+	}
+	lim := ratelimit.NewLimiter(stateStore, rateLimits)
+	tracker := handlers.NewSessionTracker(stateStore, userdataStore)
+	journalSink, err := journal.SinkFromConfig(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "journal open error: %v", err)
+		os.Exit(1)
+	}
+	if journalSink != nil {
+		lc.OnClose("journal", journalSink.Close)
+	}
+	jnl := journal.NewJournal(stateStore, journalSink)
+	sh := handlers.WithMiddleware(bsh, handlers.LogRequest, tracker.Middleware(), jnl.Middleware(), lim.Middleware())
 	cfg.SessionId = sessionId
 	rqs := handlers.RequestSession{
 		Ctx:    ctx,
 		Writer: os.Stdout,
 		Config: cfg,
+		// every run of the command is a session of its own
+		GatewaySessionId: strconv.FormatInt(time.Now().UnixNano(), 10),
 	}
 
-	cint := make(chan os.Signal)
-	cterm := make(chan os.Signal)
-	signal.Notify(cint, os.Interrupt, syscall.SIGINT)
-	signal.Notify(cterm, os.Interrupt, syscall.SIGTERM)
+	lc.Notify()
 	go func() {
-		select {
-		case _ = <-cint:
-		case _ = <-cterm:
-		}
-		sh.Shutdown()
+		// the loop may be waiting for input, which is never returned to after shutdown
+		lc.Wait()
+		os.Exit(0)
 	}()
 
-	for true {
+	rc := common.RequestContext{
+		SessionId:        sessionId,
+		GatewaySessionId: rqs.GatewaySessionId,
+		Language:         ln.Code,
+	}
+	for lc.Begin() {
+		rc.RequestId = common.NewRequestId()
+		rqs.Ctx = common.WithRequestContext(ctx, rc)
 		rqs, err = sh.Process(rqs)
 		if err != nil {
 			logg.ErrorCtxf(ctx, "error in process: %v", "err", err)
@@ -186,6 +229,7 @@ func main() {
 			fmt.Errorf("error in reset: %v", err)
 			os.Exit(1)
 		}
+		lc.End()
 		fmt.Println("")
 		_, err = fmt.Scanln(&rqs.Input)
 		if err != nil {
@@ -194,4 +238,5 @@ func main() {
 			os.Exit(1)
 		}
 	}
+	lc.Wait()
 }
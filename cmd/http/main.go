@@ -4,23 +4,36 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
-	"os/signal"
 	"path"
 	"strconv"
-	"syscall"
+	"strings"
 
 	"git.defalsify.org/vise.git/engine"
 	"git.defalsify.org/vise.git/lang"
 	"git.defalsify.org/vise.git/logging"
 	"git.defalsify.org/vise.git/resource"
 
+	"git.grassecon.net/urdt/ussd/common"
 	"git.grassecon.net/urdt/ussd/config"
 	"git.grassecon.net/urdt/ussd/initializers"
 	"git.grassecon.net/urdt/ussd/internal/args"
 	"git.grassecon.net/urdt/ussd/internal/handlers"
+	"git.grassecon.net/urdt/ussd/internal/health"
 	httpserver "git.grassecon.net/urdt/ussd/internal/http"
+	"git.grassecon.net/urdt/ussd/internal/http/at"
+	"git.grassecon.net/urdt/ussd/internal/http/beem"
+	"git.grassecon.net/urdt/ussd/internal/http/hubtel"
+	"git.grassecon.net/urdt/ussd/internal/http/simulator"
+	"git.grassecon.net/urdt/ussd/internal/journal"
+	"git.grassecon.net/urdt/ussd/internal/lifecycle"
+	"git.grassecon.net/urdt/ussd/internal/metrics"
+	"git.grassecon.net/urdt/ussd/internal/notify"
+	"git.grassecon.net/urdt/ussd/internal/ratelimit"
+	"git.grassecon.net/urdt/ussd/internal/routing"
+	"git.grassecon.net/urdt/ussd/internal/sms"
 	"git.grassecon.net/urdt/ussd/internal/storage"
 	"git.grassecon.net/urdt/ussd/remote"
 )
@@ -35,6 +48,31 @@ func init() {
 	initializers.LoadEnvVariables()
 }
 
+// gatewayFromName returns the adapter for the request format of the named USSD gateway.
+func gatewayFromName(name string) (httpserver.Gateway, error) {
+	switch name {
+	case "vise":
+		return &httpserver.DefaultRequestParser{}, nil
+	case "json":
+		return &httpserver.JsonRequestParser{}, nil
+	case "africastalking":
+		return &at.ATRequestParser{}, nil
+	case "beem":
+		return &beem.BeemRequestParser{}, nil
+	case "hubtel":
+		return &hubtel.HubtelRequestParser{}, nil
+	}
+	return nil, fmt.Errorf("unknown gateway '%s'", name)
+}
+
+// authEnvPrefix returns the prefix of the environment variables configuring the authentication of the named gateway.
+func authEnvPrefix(name string) string {
+	if name == "africastalking" {
+		return "AT"
+	}
+	return strings.ToUpper(name)
+}
+
 func main() {
 	config.LoadConfig()
 
@@ -47,6 +85,8 @@ func main() {
 	var err error
 	var gettextDir string
 	var langs args.LangVar
+	var gatewayName string
+	var withSimulator bool
 
 	flag.StringVar(&resourceDir, "resourcedir", path.Join("services", "registration"), "resource dir")
 	flag.StringVar(&connStr, "c", "", "connection string")
@@ -56,6 +96,8 @@ func main() {
 	flag.UintVar(&port, "p", initializers.GetEnvUint("PORT", 7123), "http port")
 	flag.StringVar(&gettextDir, "gettext", "", "use gettext translations from given directory")
 	flag.Var(&langs, "language", "add symbol resolution for language")
+	flag.StringVar(&gatewayName, "gateway", initializers.GetEnv("GATEWAY", "vise"), "request format of the USSD gateway (vise, json, africastalking, beem, hubtel)")
+	flag.BoolVar(&withSimulator, "simulator", false, "serve the browser phone simulator at /simulator/")
 	flag.Parse()
 
 	if connStr == "" {
@@ -67,7 +109,22 @@ func main() {
 		os.Exit(1)
 	}
 
-	logg.Infof("start command", "conn", connData, "resourcedir", resourceDir, "outputsize", size)
+	gw, err := gatewayFromName(gatewayName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gateway error: %v", err)
+		os.Exit(1)
+	}
+
+	auth, err := httpserver.InboundAuthFromEnv(authEnvPrefix(gatewayName))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gateway auth config error: %v", err)
+		os.Exit(1)
+	}
+	if !auth.Enabled() {
+		logg.Warnf("inbound gateway requests are not authenticated", "gateway", gatewayName)
+	}
+
+	logg.Infof("start command", "conn", connData, "resourcedir", resourceDir, "outputsize", size, "gateway", gatewayName)
 
 	ctx := context.Background()
 
@@ -76,7 +133,9 @@ func main() {
 		fmt.Fprintf(os.Stderr, "default language set error: %v", err)
 		os.Exit(1)
 	}
-	ctx = context.WithValue(ctx, "Language", ln)
+	ctx = common.WithRequestContext(ctx, common.RequestContext{
+		Language: ln.Code,
+	})
 
 	pfp := path.Join(scriptDir, "pp.csv")
 
@@ -91,7 +150,10 @@ func main() {
 		cfg.EngineDebug = true
 	}
 
+	lc := lifecycle.NewManager(config.ShutdownTimeout)
+
 	menuStorageService := storage.NewMenuStorageService(connData, resourceDir)
+	lc.OnClose("storage", menuStorageService.Close)
 
 	rs, err := menuStorageService.GetResource(ctx)
 	if err != nil {
@@ -104,7 +166,6 @@ func main() {
 		fmt.Fprintf(os.Stderr, err.Error())
 		os.Exit(1)
 	}
-	defer userdataStore.Close()
 
 	dbResource, ok := rs.(*resource.DbResource)
 	if !ok {
@@ -112,12 +173,21 @@ func main() {
 	}
 
 	lhs, err := handlers.NewLocalHandlerService(ctx, pfp, true, dbResource, cfg, rs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
 	lhs.SetDataStore(&userdataStore)
 
+	smsQueue, err := sms.QueueFromConfig(ctx, sms.NewStoreRecorder(userdataStore))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
+		fmt.Fprintf(os.Stderr, "sms queue error: %v", err)
 		os.Exit(1)
 	}
+	if smsQueue != nil {
+		lc.OnClose("sms queue", smsQueue.Close)
+		lhs.SetSmsQueue(smsQueue)
+	}
 
 	accountService := remote.AccountService{}
 	hl, err := lhs.GetHandler(&accountService)
@@ -131,30 +201,92 @@ func main() {
 		fmt.Fprintf(os.Stderr, err.Error())
 		os.Exit(1)
 	}
-	defer stateStore.Close()
 
-	rp := &httpserver.DefaultRequestParser{}
-	bsh := handlers.NewBaseSessionHandler(cfg, rs, stateStore, userdataStore, rp, hl)
-	sh := httpserver.ToSessionHandler(bsh)
+	bsh := handlers.NewBaseSessionHandler(cfg, rs, stateStore, userdataStore, gw, hl)
+	rateLimits, err := ratelimit.PoliciesFromConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rate limit config error: %v", err)
+		os.Exit(1)
+	}
+	lim := ratelimit.NewLimiter(stateStore, rateLimits)
+	tracker := handlers.NewSessionTracker(stateStore, userdataStore)
+	journalSink, err := journal.SinkFromConfig(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "journal open error: %v", err)
+		os.Exit(1)
+	}
+	if journalSink != nil {
+		lc.OnClose("journal", journalSink.Close)
+	}
+	jnl := journal.NewJournal(stateStore, journalSink)
+	var mh handlers.RequestHandler = bsh
+	if config.RoutingTable != "" {
+		table, err := routing.LoadTable(config.RoutingTable)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "routing table error: %v", err)
+			os.Exit(1)
+		}
+		router, err := routing.NewRouter(ctx, table, bsh, routing.Base{
+			Conn:           connData,
+			Config:         cfg,
+			UserdataStore:  userdataStore,
+			RequestParser:  gw,
+			AccountService: &accountService,
+			SmsQueue:       smsQueue,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "routing error: %v", err)
+			os.Exit(1)
+		}
+		lc.OnClose("routes", router.Close)
+		mh = router
+	}
+	// routed sessions keep their state in the state store of the route
+	sr, ok := mh.(handlers.StateResolver)
+	if ok {
+		jnl.WithStateResolver(sr)
+		lim.WithStateResolver(sr)
+	}
+	rh := handlers.WithMiddleware(mh, handlers.LogRequest, handlers.RecordMetrics, tracker.Middleware(), jnl.Middleware(), lim.Middleware())
+	checker := health.NewChecker(health.DefaultTimeout)
+	err = checker.AddStorageChecks(ctx, menuStorageService)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "health check setup error: %v", err)
+		os.Exit(1)
+	}
+	checker.Add("custodial service", health.UrlCheck(config.CustodialServiceURL))
+	checker.Add("data service", health.UrlCheck(config.DataServiceURL))
+
+	mux := http.NewServeMux()
+	mux.Handle("/", auth.Handler(httpserver.NewGatewaySessionHandler(rh, gw).WithSessionTracker(tracker).WithUserdataStore(userdataStore)))
+	if withSimulator {
+		mux.Handle("/simulator/", http.StripPrefix("/simulator", simulator.NewSimulator(rh, lhs.Parser)))
+	}
+	webhook, err := notify.WebhookFromEnv(userdataStore, smsQueue)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "transfer webhook config error: %v", err)
+		os.Exit(1)
+	}
+	if webhook != nil {
+		mux.Handle(initializers.GetEnv("WEBHOOK_ENDPOINT", notify.DefaultEndpoint), webhook)
+	}
+	checker.Register(mux)
+	mux.Handle("/metrics", metrics.Default)
+
 	s := &http.Server{
 		Addr:    fmt.Sprintf("%s:%s", host, strconv.Itoa(int(port))),
-		Handler: sh,
-	}
-	s.RegisterOnShutdown(sh.Shutdown)
-
-	cint := make(chan os.Signal)
-	cterm := make(chan os.Signal)
-	signal.Notify(cint, os.Interrupt, syscall.SIGINT)
-	signal.Notify(cterm, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		select {
-		case _ = <-cint:
-		case _ = <-cterm:
-		}
-		s.Shutdown(ctx)
-	}()
+		Handler: mux,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+	lc.OnStop(s.Shutdown)
+	lc.Notify()
+
 	err = s.ListenAndServe()
-	if err != nil {
+	if err != http.ErrServerClosed {
 		logg.Infof("Server closed with error", "err", err)
+		lc.Shutdown()
 	}
+	lc.Wait()
 }
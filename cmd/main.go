@@ -11,10 +11,13 @@ import (
 	"git.defalsify.org/vise.git/lang"
 	"git.defalsify.org/vise.git/logging"
 	"git.defalsify.org/vise.git/resource"
+	"git.grassecon.net/urdt/ussd/common"
 	"git.grassecon.net/urdt/ussd/config"
 	"git.grassecon.net/urdt/ussd/initializers"
 	"git.grassecon.net/urdt/ussd/internal/args"
 	"git.grassecon.net/urdt/ussd/internal/handlers"
+	"git.grassecon.net/urdt/ussd/internal/recorder"
+	"git.grassecon.net/urdt/ussd/internal/sms"
 	"git.grassecon.net/urdt/ussd/internal/storage"
 	"git.grassecon.net/urdt/ussd/remote"
 )
@@ -41,6 +44,8 @@ func main() {
 	var err error
 	var gettextDir string
 	var langs args.LangVar
+	var recordFile string
+	var placeholders bool
 
 	flag.StringVar(&resourceDir, "resourcedir", scriptDir, "resource dir")
 	flag.StringVar(&sessionId, "session-id", "075xx2123", "session id")
@@ -49,6 +54,8 @@ func main() {
 	flag.UintVar(&size, "s", 160, "max size of output")
 	flag.StringVar(&gettextDir, "gettext", "", "use gettext translations from given directory")
 	flag.Var(&langs, "language", "add symbol resolution for language")
+	flag.StringVar(&recordFile, "record", "", "record the session as a menu traversal test group to the given file")
+	flag.BoolVar(&placeholders, "placeholders", false, "replace balances, amounts, addresses and the session id with placeholders in the recording")
 	flag.Parse()
 
 	if connStr == "" {
@@ -67,13 +74,18 @@ func main() {
 	}
 
 	ctx := context.Background()
-	ctx = context.WithValue(ctx, "SessionId", sessionId)
 
 	ln, err := lang.LanguageFromCode(config.DefaultLanguage)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "default language set error: %v", err)
 		os.Exit(1)
 	}
+	ctx = common.WithRequestContext(ctx, common.RequestContext{
+		SessionId: sessionId,
+		Language:  ln.Code,
+		RequestId: common.NewRequestId(),
+	})
+	// the engine is run directly on this context, and resolves the default language from the untyped key
 	ctx = context.WithValue(ctx, "Language", ln)
 
 	pfp := path.Join(scriptDir, "pp.csv")
@@ -117,13 +129,21 @@ func main() {
 	}
 
 	lhs, err := handlers.NewLocalHandlerService(ctx, pfp, true, dbResource, cfg, rs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
 	lhs.SetDataStore(&userdatastore)
 	lhs.SetPersister(pe)
 
+	smsQueue, err := sms.QueueFromConfig(ctx, sms.NewStoreRecorder(userdatastore))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
+		fmt.Fprintf(os.Stderr, "sms queue error: %v", err)
 		os.Exit(1)
 	}
+	if smsQueue != nil {
+		lhs.SetSmsQueue(smsQueue)
+	}
 
 	accountService := remote.AccountService{}
 	hl, err := lhs.GetHandler(&accountService)
@@ -138,7 +158,26 @@ func main() {
 		en = en.WithDebug(nil)
 	}
 
-	err = engine.Loop(ctx, en, os.Stdin, os.Stdout, nil)
+	var loopEngine engine.Engine = en
+	var rec *recorder.Recorder
+	if recordFile != "" {
+		rec = recorder.NewRecorder(sessionId, recorder.GroupName(recordFile)).WithMenuSeparator(menuSeparator)
+		if placeholders {
+			rec = rec.WithPlaceholders(recorder.DefaultPlaceholders())
+		}
+		loopEngine = rec.WrapEngine(en)
+	}
+
+	err = engine.Loop(ctx, loopEngine, os.Stdin, os.Stdout, nil)
+	if smsQueue != nil {
+		smsQueue.Close()
+	}
+	if rec != nil {
+		rerr := rec.WriteFile(recordFile)
+		if rerr != nil {
+			fmt.Fprintf(os.Stderr, "recording write error: %v\n", rerr)
+		}
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "loop exited with error: %v\n", err)
 		os.Exit(1)
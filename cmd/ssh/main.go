@@ -5,10 +5,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"os/signal"
 	"path"
 	"sync"
-	"syscall"
 
 	"git.defalsify.org/vise.git/db"
 	"git.defalsify.org/vise.git/engine"
@@ -16,6 +14,11 @@ import (
 
 	"git.grassecon.net/urdt/ussd/config"
 	"git.grassecon.net/urdt/ussd/initializers"
+	"git.grassecon.net/urdt/ussd/internal/handlers"
+	"git.grassecon.net/urdt/ussd/internal/journal"
+	"git.grassecon.net/urdt/ussd/internal/lifecycle"
+	"git.grassecon.net/urdt/ussd/internal/ratelimit"
+	"git.grassecon.net/urdt/ussd/internal/sms"
 	"git.grassecon.net/urdt/ussd/internal/ssh"
 	"git.grassecon.net/urdt/ussd/internal/storage"
 )
@@ -44,6 +47,9 @@ func main() {
 	var stateDebug bool
 	var host string
 	var port uint
+	var pinLogin bool
+	var recordDir string
+	var placeholders bool
 	flag.StringVar(&connStr, "c", "", "connection string")
 	flag.StringVar(&authConnStr, "authdb", "", "auth connection string")
 	flag.StringVar(&resourceDir, "resourcedir", path.Join("services", "registration"), "resource dir")
@@ -51,6 +57,9 @@ func main() {
 	flag.UintVar(&size, "s", 160, "max size of output")
 	flag.StringVar(&host, "h", "127.0.0.1", "socket host")
 	flag.UintVar(&port, "p", 7122, "socket port")
+	flag.BoolVar(&pinLogin, "pinlogin", false, "also allow login with phone number and account PIN (not for production)")
+	flag.StringVar(&recordDir, "recorddir", "", "record each connection as a menu traversal test group to the given directory")
+	flag.BoolVar(&placeholders, "placeholders", false, "replace balances, amounts, addresses and the session id with placeholders in the recordings")
 	flag.Parse()
 
 	if connStr == "" {
@@ -84,7 +93,7 @@ func main() {
 	logg.WarnCtxf(ctx, "!!!!! Do not expose to internet and only use with tunnel!")
 	logg.WarnCtxf(ctx, "!!!!! (See ssh -L <...>)")
 
-	logg.Infof("start command", "conn", connData, "authconn", authConnData, "resourcedir", resourceDir, "outputsize", size, "keyfile", sshKeyFile, "host", host, "port", port)
+	logg.Infof("start command", "conn", connData, "authconn", authConnData, "resourcedir", resourceDir, "outputsize", size, "keyfile", sshKeyFile, "host", host, "port", port, "pinlogin", pinLogin)
 
 	pfp := path.Join(scriptDir, "pp.csv")
 
@@ -105,18 +114,40 @@ func main() {
 		fmt.Fprintf(os.Stderr, "keystore file open error: %v", err)
 		os.Exit(1)
 	}
-	defer func() {
-		logg.TraceCtxf(ctx, "shutdown auth key store reached")
-		err = authKeyStore.Close()
-		if err != nil {
-			logg.ErrorCtxf(ctx, "keystore close error", "err", err)
-		}
-	}()
+	lc := lifecycle.NewManager(config.ShutdownTimeout)
+	lc.OnClose("auth key store", authKeyStore.Close)
 
-	cint := make(chan os.Signal)
-	cterm := make(chan os.Signal)
-	signal.Notify(cint, os.Interrupt, syscall.SIGINT)
-	signal.Notify(cterm, os.Interrupt, syscall.SIGTERM)
+	rateLimits, err := ratelimit.PoliciesFromConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rate limit config error: %v", err)
+		os.Exit(1)
+	}
+
+	journalSink, err := journal.SinkFromConfig(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "journal open error: %v", err)
+		os.Exit(1)
+	}
+	if journalSink != nil {
+		lc.OnClose("journal", journalSink.Close)
+	}
+
+	menuStorageService := storage.NewMenuStorageService(connData, resourceDir)
+	lc.OnClose("menu storage", menuStorageService.Close)
+	userdataStore, err := menuStorageService.GetUserdataDb(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "userdata store error: %v", err)
+		os.Exit(1)
+	}
+
+	smsQueue, err := sms.QueueFromConfig(ctx, sms.NewStoreRecorder(userdataStore))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sms queue error: %v", err)
+		os.Exit(1)
+	}
+	if smsQueue != nil {
+		lc.OnClose("sms queue", smsQueue.Close)
+	}
 
 	runner := &ssh.SshRunner{
 		Cfg: cfg,
@@ -127,18 +158,20 @@ func main() {
 		SrvKeyFile:  sshKeyFile,
 		Host:        host,
 		Port:        port,
+		Middleware:  []handlers.Middleware{handlers.LogRequest},
+		RateLimit:   rateLimits,
+		JournalSink: journalSink,
+		SmsQueue:    smsQueue,
+		Storage:     menuStorageService,
+		PinLogin:    pinLogin,
+		RecordDir:   recordDir,
+		RecordPlaceholders: placeholders,
+		Lifecycle:   lc,
 	}
-	go func() {
-		select {
-		case _ = <-cint:
-		case _ = <-cterm:
-		}
-		logg.TraceCtxf(ctx, "shutdown runner reached")
-		err := runner.Stop()
-		if err != nil {
-			logg.ErrorCtxf(ctx, "runner stop error", "err", err)
-		}
-
-	}()
+	lc.OnStop(runner.Stop)
+	lc.OnClose("ssh connections", runner.Close)
+	lc.Notify()
+
 	runner.Run(ctx, authKeyStore)
+	lc.Shutdown()
 }
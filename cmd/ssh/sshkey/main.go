@@ -5,30 +5,108 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"text/tabwriter"
+	"time"
 
 	"git.grassecon.net/urdt/ussd/internal/ssh"
 )
 
+const usage = `usage: %s [--dbdir <dbpath>] <command> [args]
+
+commands:
+  list                                          list the authorized keys
+  add -i <session_id> [-l <label>] <keyfile>    authorize the public key for the session
+  remove <fingerprint>                          revoke the key
+  rename [-i <session_id>] [-l <label>] <fingerprint>
+                                                map the key to another session, or relabel it
+`
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
+
+func list(ctx context.Context, store *ssh.SshKeyStore, args []string) error {
+	entries, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "FINGERPRINT\tTYPE\tSESSION\tLABEL\tCREATED\tLAST USED\n")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", entry.Fingerprint, entry.Type, entry.SessionId, entry.Label, formatTime(entry.Created), formatTime(entry.LastUsed))
+	}
+	return w.Flush()
+}
+
+func add(ctx context.Context, store *ssh.SshKeyStore, args []string) error {
+	var sessionId string
+	var label string
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	fs.StringVar(&sessionId, "i", "", "session id")
+	fs.StringVar(&label, "l", "", "label of the key, defaults to the comment of the key file")
+	fs.Parse(args)
+
+	if sessionId == "" {
+		return fmt.Errorf("empty session id")
+	}
+	sshKeyFile := fs.Arg(0)
+	if sshKeyFile == "" {
+		return fmt.Errorf("missing key file argument")
+	}
+	return store.AddFromFile(ctx, sshKeyFile, sessionId, label)
+}
+
+func remove(ctx context.Context, store *ssh.SshKeyStore, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing fingerprint argument")
+	}
+	return store.Remove(ctx, args[0])
+}
+
+func rename(ctx context.Context, store *ssh.SshKeyStore, args []string) error {
+	var sessionId string
+	var label string
+	fs := flag.NewFlagSet("rename", flag.ExitOnError)
+	fs.StringVar(&sessionId, "i", "", "new session id")
+	fs.StringVar(&label, "l", "", "new label")
+	fs.Parse(args)
+
+	fingerprint := fs.Arg(0)
+	if fingerprint == "" {
+		return fmt.Errorf("missing fingerprint argument")
+	}
+	if sessionId == "" && label == "" {
+		return fmt.Errorf("nothing to rename, need session id or label")
+	}
+	return store.Rename(ctx, fingerprint, sessionId, label)
+}
+
 func main() {
 	var dbDir string
-	var sessionId string
 	flag.StringVar(&dbDir, "dbdir", ".state", "database dir to read from")
-	flag.StringVar(&sessionId, "i", "", "session id")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, usage, os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	if sessionId == "" {
-		fmt.Fprintf(os.Stderr, "empty session id\n")
+	commands := map[string]func(context.Context, *ssh.SshKeyStore, []string) error{
+		"list":   list,
+		"add":    add,
+		"remove": remove,
+		"rename": rename,
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		flag.Usage()
 		os.Exit(1)
 	}
 
 	ctx := context.Background()
 
-	sshKeyFile := flag.Arg(0)
-	if sshKeyFile == "" {
-		fmt.Fprintf(os.Stderr, "missing key file argument\n")
-		os.Exit(1)
-	}
-
 	store, err := ssh.NewSshKeyStore(ctx, dbDir)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
@@ -36,7 +114,7 @@ func main() {
 	}
 	defer store.Close()
 
-	err = store.AddFromFile(ctx, sshKeyFile, sessionId)
+	err = cmd(ctx, store, flag.Args()[1:])
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+
+	"git.defalsify.org/vise.git/engine"
+	"git.defalsify.org/vise.git/lang"
+	"git.defalsify.org/vise.git/logging"
+	"git.defalsify.org/vise.git/resource"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/config"
+	"git.grassecon.net/urdt/ussd/initializers"
+	"git.grassecon.net/urdt/ussd/internal/handlers"
+	"git.grassecon.net/urdt/ussd/internal/health"
+	httpserver "git.grassecon.net/urdt/ussd/internal/http"
+	"git.grassecon.net/urdt/ussd/internal/http/at"
+	"git.grassecon.net/urdt/ussd/internal/journal"
+	"git.grassecon.net/urdt/ussd/internal/lifecycle"
+	"git.grassecon.net/urdt/ussd/internal/metrics"
+	"git.grassecon.net/urdt/ussd/internal/notify"
+	"git.grassecon.net/urdt/ussd/internal/ratelimit"
+	"git.grassecon.net/urdt/ussd/internal/routing"
+	"git.grassecon.net/urdt/ussd/internal/sms"
+	"git.grassecon.net/urdt/ussd/internal/ssh"
+	"git.grassecon.net/urdt/ussd/internal/storage"
+	"git.grassecon.net/urdt/ussd/remote"
+)
+
+var (
+	logg          = logging.NewVanilla().WithDomain("ussd-server")
+	scriptDir     = path.Join("services", "registration")
+	build         = "dev"
+	menuSeparator = ": "
+)
+
+func init() {
+	initializers.LoadEnvVariables()
+}
+
+// frontend is an HTTP frontend served on its own address.
+type frontend struct {
+	name string
+	addr string
+	// path is the path the gateway posts requests to.
+	path string
+	gw   httpserver.Gateway
+	// envPrefix is the prefix of the environment variables configuring the authentication of the gateway.
+	envPrefix string
+}
+
+// serve runs the server until it is shut down, shutting down the whole process if it fails to start.
+func serve(lc *lifecycle.Manager, name string, s *http.Server) {
+	lc.OnStop(s.Shutdown)
+	go func() {
+		logg.Infof("frontend listening", "frontend", name, "addr", s.Addr)
+		err := s.ListenAndServe()
+		if err != http.ErrServerClosed {
+			logg.Errorf("frontend closed with error", "frontend", name, "err", err)
+			lc.Shutdown()
+		}
+	}()
+}
+
+func main() {
+	config.LoadConfig()
+
+	var connStr string
+	var authConnStr string
+	var resourceDir string
+	var size uint
+	var engineDebug bool
+	var host string
+	var httpPort uint
+	var atPort uint
+	var jsonPort uint
+	var sshPort uint
+	var webhookPort uint
+	var sshKeyFile string
+	var sshPinLogin bool
+	var err error
+
+	flag.StringVar(&resourceDir, "resourcedir", path.Join("services", "registration"), "resource dir")
+	flag.StringVar(&connStr, "c", "", "connection string")
+	flag.StringVar(&authConnStr, "authdb", "", "ssh auth connection string")
+	flag.BoolVar(&engineDebug, "d", false, "use engine debug output")
+	flag.UintVar(&size, "s", 160, "max size of output")
+	flag.StringVar(&host, "h", initializers.GetEnv("HOST", "127.0.0.1"), "host of all frontends")
+	flag.UintVar(&httpPort, "http", initializers.GetEnvUint("HTTP_PORT", 0), "port of the raw http frontend, 0 to disable")
+	flag.UintVar(&atPort, "at", initializers.GetEnvUint("AT_PORT", 0), "port of the AfricasTalking frontend, 0 to disable")
+	flag.UintVar(&jsonPort, "json", initializers.GetEnvUint("JSON_PORT", 0), "port of the json api frontend, 0 to disable")
+	flag.UintVar(&sshPort, "ssh", initializers.GetEnvUint("SSH_PORT", 0), "port of the ssh frontend, 0 to disable")
+	flag.UintVar(&webhookPort, "webhook", initializers.GetEnvUint("WEBHOOK_PORT", 0), "port of the transfer notification webhook, 0 to disable")
+	flag.StringVar(&sshKeyFile, "sshkey", initializers.GetEnv("SSH_KEY_FILE", ""), "ssh server private key file")
+	flag.BoolVar(&sshPinLogin, "sshpinlogin", false, "also allow ssh login with phone number and account PIN (not for production)")
+	flag.Parse()
+
+	if httpPort == 0 && atPort == 0 && jsonPort == 0 && sshPort == 0 {
+		fmt.Fprintf(os.Stderr, "no frontend enabled, set at least one of -http, -at, -json or -ssh")
+		os.Exit(1)
+	}
+
+	if connStr == "" {
+		connStr = config.DbConn
+	}
+	connData, err := storage.ToConnData(connStr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connstr err: %v", err)
+		os.Exit(1)
+	}
+
+	logg.Infof("start command", "build", build, "conn", connData, "resourcedir", resourceDir, "outputsize", size, "http", httpPort, "at", atPort, "json", jsonPort, "ssh", sshPort)
+
+	ctx := context.Background()
+	ln, err := lang.LanguageFromCode(config.DefaultLanguage)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "default language set error: %v", err)
+		os.Exit(1)
+	}
+	ctx = common.WithRequestContext(ctx, common.RequestContext{
+		Language: ln.Code,
+	})
+
+	pfp := path.Join(scriptDir, "pp.csv")
+
+	cfg := engine.Config{
+		Root:          "root",
+		OutputSize:    uint32(size),
+		FlagCount:     uint32(128),
+		MenuSeparator: menuSeparator,
+	}
+	if engineDebug {
+		cfg.EngineDebug = true
+	}
+
+	lc := lifecycle.NewManager(config.ShutdownTimeout)
+
+	menuStorageService := storage.NewMenuStorageService(connData, resourceDir)
+	lc.OnClose("storage", menuStorageService.Close)
+
+	rs, err := menuStorageService.GetResource(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	userdataStore, err := menuStorageService.GetUserdataDb(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	dbResource, ok := rs.(*resource.DbResource)
+	if !ok {
+		os.Exit(1)
+	}
+
+	lhs, err := handlers.NewLocalHandlerService(ctx, pfp, true, dbResource, cfg, rs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	lhs.SetDataStore(&userdataStore)
+
+	smsQueue, err := sms.QueueFromConfig(ctx, sms.NewStoreRecorder(userdataStore))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sms queue error: %v", err)
+		os.Exit(1)
+	}
+	if smsQueue != nil {
+		lc.OnClose("sms queue", smsQueue.Close)
+		lhs.SetSmsQueue(smsQueue)
+	}
+
+	stateStore, err := menuStorageService.GetStateStore(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	// The frontends serve requests concurrently, so each request gets menu handlers of its own.
+	// They bring their own request parsers, so the session handler is shared without one.
+	accountService := remote.AccountService{}
+	newHandler := handlers.NewMenuHandlerFactory(menuStorageService, lhs, &accountService, stateStore, userdataStore, nil)
+	_, err = newHandler(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	fsh := handlers.NewFactorySessionHandler(cfg, nil, newHandler)
+	var mh handlers.RequestHandler = fsh
+	if config.RoutingTable != "" {
+		table, err := routing.LoadTable(config.RoutingTable)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "routing table error: %v", err)
+			os.Exit(1)
+		}
+		router, err := routing.NewRouter(ctx, table, fsh, routing.Base{
+			Conn:           connData,
+			Config:         cfg,
+			UserdataStore:  userdataStore,
+			AccountService: &accountService,
+			SmsQueue:       smsQueue,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "routing error: %v", err)
+			os.Exit(1)
+		}
+		lc.OnClose("routes", router.Close)
+		mh = router
+	}
+
+	rateLimits, err := ratelimit.PoliciesFromConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rate limit config error: %v", err)
+		os.Exit(1)
+	}
+	lim := ratelimit.NewLimiter(stateStore, rateLimits)
+	tracker := handlers.NewSessionTracker(stateStore, userdataStore)
+	journalSink, err := journal.SinkFromConfig(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "journal open error: %v", err)
+		os.Exit(1)
+	}
+	if journalSink != nil {
+		lc.OnClose("journal", journalSink.Close)
+	}
+	jnl := journal.NewJournal(stateStore, journalSink)
+	// routed sessions keep their state in the state store of the route
+	sr, ok := mh.(handlers.StateResolver)
+	if ok {
+		jnl.WithStateResolver(sr)
+		lim.WithStateResolver(sr)
+	}
+	rh := handlers.WithMiddleware(mh, handlers.LogRequest, handlers.RecordMetrics, tracker.Middleware(), jnl.Middleware(), lim.Middleware())
+
+	checker := health.NewChecker(health.DefaultTimeout)
+	err = checker.AddStorageChecks(ctx, menuStorageService)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "health check setup error: %v", err)
+		os.Exit(1)
+	}
+	checker.Add("custodial service", health.UrlCheck(config.CustodialServiceURL))
+	checker.Add("data service", health.UrlCheck(config.DataServiceURL))
+
+	frontends := []frontend{
+		{"http", addr(host, httpPort), "/", &httpserver.DefaultRequestParser{}, "VISE"},
+		{"africastalking", addr(host, atPort), initializers.GetEnv("AT_ENDPOINT", "/"), &at.ATRequestParser{}, "AT"},
+		{"json", addr(host, jsonPort), "/", &httpserver.JsonRequestParser{}, "JSON"},
+	}
+	for _, fe := range frontends {
+		if fe.addr == "" {
+			continue
+		}
+		auth, err := httpserver.InboundAuthFromEnv(fe.envPrefix)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gateway auth config error: %v", err)
+			os.Exit(1)
+		}
+		if !auth.Enabled() {
+			logg.Warnf("inbound gateway requests are not authenticated", "frontend", fe.name)
+		}
+		mux := http.NewServeMux()
+		mux.Handle(fe.path, auth.Handler(httpserver.NewGatewaySessionHandler(rh, fe.gw).WithSessionTracker(tracker).WithUserdataStore(userdataStore)))
+		if fe.name == "africastalking" {
+			mux.Handle(initializers.GetEnv("AT_END_ENDPOINT", path.Join(fe.path, "end")), auth.Handler(at.NewATEndSessionHandler(tracker)))
+		}
+		checker.Register(mux)
+		mux.Handle("/metrics", metrics.Default)
+		serve(lc, fe.name, &http.Server{
+			Addr:    fe.addr,
+			Handler: mux,
+			BaseContext: func(net.Listener) context.Context {
+				return ctx
+			},
+		})
+	}
+
+	if webhookPort > 0 {
+		webhook, err := notify.WebhookFromEnv(userdataStore, smsQueue)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "transfer webhook config error: %v", err)
+			os.Exit(1)
+		}
+		if webhook == nil {
+			fmt.Fprintf(os.Stderr, "transfer webhook needs an sms provider and WEBHOOK_HMAC_KEY")
+			os.Exit(1)
+		}
+		mux := http.NewServeMux()
+		mux.Handle(initializers.GetEnv("WEBHOOK_ENDPOINT", notify.DefaultEndpoint), webhook)
+		serve(lc, "webhook", &http.Server{
+			Addr:    addr(host, webhookPort),
+			Handler: mux,
+			BaseContext: func(net.Listener) context.Context {
+				return ctx
+			},
+		})
+	}
+
+	if sshPort > 0 {
+		if authConnStr == "" {
+			authConnStr = connStr
+		}
+		authConnData, err := storage.ToConnData(authConnStr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "auth connstr err: %v", err)
+			os.Exit(1)
+		}
+		_, err = os.Stat(sshKeyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cannot open ssh server private key file: %v\n", err)
+			os.Exit(1)
+		}
+		authKeyStore, err := ssh.NewSshKeyStore(ctx, authConnData.String())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "keystore file open error: %v", err)
+			os.Exit(1)
+		}
+		lc.OnClose("auth key store", authKeyStore.Close)
+		logg.WarnCtxf(ctx, "the ssh frontend is not production ready, do not expose it to the internet")
+
+		runner := &ssh.SshRunner{
+			Cfg:         cfg,
+			Debug:       engineDebug,
+			SrvKeyFile:  sshKeyFile,
+			Host:        host,
+			Port:        sshPort,
+			Lifecycle:   lc,
+			Storage:     menuStorageService,
+			PinLogin:    sshPinLogin,
+			FlagFile:    pfp,
+			SmsQueue:    smsQueue,
+			Middleware:  []handlers.Middleware{handlers.LogRequest, handlers.RecordMetrics},
+			RateLimit:   rateLimits,
+			JournalSink: journalSink,
+		}
+		lc.OnStop(runner.Stop)
+		lc.OnClose("ssh connections", runner.Close)
+		go func() {
+			runner.Run(ctx, authKeyStore)
+			lc.Shutdown()
+		}()
+	}
+
+	lc.Notify()
+	lc.Wait()
+}
+
+// addr returns the listen address of a frontend, or an empty string if the frontend is disabled.
+func addr(host string, port uint) string {
+	if port == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s:%s", host, strconv.Itoa(int(port)))
+}
@@ -0,0 +1,112 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"git.defalsify.org/vise.git/lang"
+)
+
+// RequestContext holds the details of a single menu request.
+//
+// It is stored in the context of the request with WithRequestContext, and read back with GetRequestContext or one of the typed accessors.
+type RequestContext struct {
+	// SessionId identifies the user, usually by phone number.
+	SessionId string
+	// GatewaySessionId is the identifier the gateway assigned to the USSD session.
+	GatewaySessionId string
+	// NetworkCode is the code of the mobile network the request came from.
+	NetworkCode string
+	// ServiceCode is the USSD code that was dialed.
+	ServiceCode string
+	// Language is the ISO 639 code of the language to use when the user has not chosen one.
+	Language string
+	// RequestId uniquely identifies the request.
+	RequestId string
+}
+
+type requestContextKey struct{}
+
+// WithRequestContext returns a copy of ctx holding the request context.
+func WithRequestContext(ctx context.Context, rc RequestContext) context.Context {
+	return context.WithValue(ctx, requestContextKey{}, rc)
+}
+
+// GetRequestContext returns the request context stored in ctx, if any.
+func GetRequestContext(ctx context.Context) (RequestContext, bool) {
+	rc, ok := ctx.Value(requestContextKey{}).(RequestContext)
+	return rc, ok
+}
+
+// Merge returns a copy of the request context with all non-empty fields of o applied.
+func (rc RequestContext) Merge(o RequestContext) RequestContext {
+	if o.SessionId != "" {
+		rc.SessionId = o.SessionId
+	}
+	if o.GatewaySessionId != "" {
+		rc.GatewaySessionId = o.GatewaySessionId
+	}
+	if o.NetworkCode != "" {
+		rc.NetworkCode = o.NetworkCode
+	}
+	if o.ServiceCode != "" {
+		rc.ServiceCode = o.ServiceCode
+	}
+	if o.Language != "" {
+		rc.Language = o.Language
+	}
+	if o.RequestId != "" {
+		rc.RequestId = o.RequestId
+	}
+	return rc
+}
+
+// SessionIdFromContext returns the session id of the request.
+//
+// The session id set by the vise engine is used if the context holds no request context.
+func SessionIdFromContext(ctx context.Context) (string, bool) {
+	rc, ok := GetRequestContext(ctx)
+	if ok && rc.SessionId != "" {
+		return rc.SessionId, true
+	}
+	sessionId, ok := ctx.Value("SessionId").(string)
+	return sessionId, ok
+}
+
+// LanguageCodeFromContext returns the ISO 639 code of the language of the request.
+//
+// The language set by the vise engine, which follows the choice of the user, takes precedence over the one of the request context. An empty string is returned if neither is set.
+func LanguageCodeFromContext(ctx context.Context) string {
+	ln, ok := ctx.Value("Language").(lang.Language)
+	if ok {
+		return ln.Code
+	}
+	rc, ok := GetRequestContext(ctx)
+	if ok {
+		return rc.Language
+	}
+	return ""
+}
+
+// GatewaySessionIdFromContext returns the gateway session id of the request.
+func GatewaySessionIdFromContext(ctx context.Context) string {
+	rc, _ := GetRequestContext(ctx)
+	return rc.GatewaySessionId
+}
+
+// RequestIdFromContext returns the unique id of the request.
+func RequestIdFromContext(ctx context.Context) string {
+	rc, _ := GetRequestContext(ctx)
+	return rc.RequestId
+}
+
+// NewRequestId generates a random request id.
+func NewRequestId() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		logg.Errorf("request id generation failed", "err", err)
+	}
+	return hex.EncodeToString(b)
+}
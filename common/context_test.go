@@ -0,0 +1,78 @@
+package common
+
+import (
+	"context"
+	"testing"
+
+	"git.defalsify.org/vise.git/lang"
+)
+
+func TestRequestContext(t *testing.T) {
+	ctx := context.Background()
+	_, ok := SessionIdFromContext(ctx)
+	if ok {
+		t.Fatalf("expected no session id")
+	}
+	if LanguageCodeFromContext(ctx) != "" {
+		t.Fatalf("expected no language")
+	}
+
+	ctx = WithRequestContext(ctx, RequestContext{
+		Language: "eng",
+	})
+	rc, _ := GetRequestContext(ctx)
+	rc = rc.Merge(RequestContext{
+		SessionId:        "+254712345678",
+		GatewaySessionId: "ATUid_1",
+	})
+	ctx = WithRequestContext(ctx, rc)
+
+	sessionId, ok := SessionIdFromContext(ctx)
+	if !ok || sessionId != "+254712345678" {
+		t.Fatalf("expected session id from request context, got '%s'", sessionId)
+	}
+	if GatewaySessionIdFromContext(ctx) != "ATUid_1" {
+		t.Fatalf("expected gateway session id from request context")
+	}
+	if LanguageCodeFromContext(ctx) != "eng" {
+		t.Fatalf("expected language from request context")
+	}
+
+	// the language chosen by the user, as set by the engine, takes precedence
+	ctx = context.WithValue(ctx, "Language", lang.Language{Code: "swa"})
+	if LanguageCodeFromContext(ctx) != "swa" {
+		t.Fatalf("expected language from engine")
+	}
+}
+
+func TestSessionIdFromEngineContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "SessionId", "+254700000000")
+	sessionId, ok := SessionIdFromContext(ctx)
+	if !ok || sessionId != "+254700000000" {
+		t.Fatalf("expected session id from engine, got '%s'", sessionId)
+	}
+}
+
+func TestContextArgs(t *testing.T) {
+	ctx := context.Background()
+	args := contextArgs(ctx, []any{"err", "fail"})
+	if len(args) != 2 {
+		t.Fatalf("expected no request arguments, got %v", args)
+	}
+
+	ctx = WithRequestContext(ctx, RequestContext{
+		SessionId:        "+254712345678",
+		GatewaySessionId: "ATUid_1",
+		RequestId:        "abcd",
+	})
+	args = contextArgs(ctx, []any{"err", "fail"})
+	expected := []any{"err", "fail", "sessionId", "+254712345678", "gatewaySessionId", "ATUid_1", "requestId", "abcd"}
+	if len(args) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, args)
+	}
+	for i := range expected {
+		if args[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, args)
+		}
+	}
+}
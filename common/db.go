@@ -59,6 +59,12 @@ const (
 	DATA_INCORRECT_PIN_ATTEMPTS
 	//ISO 639 code for the selected language.
 	DATA_SELECTED_LANGUAGE_CODE
+	// Index of the page of the list currently shown to the user.
+	DATA_PAGE_CURSOR
+	// JSON list of the results of the last SMS sent on behalf of the user, such as invites.
+	DATA_SMS_RESULTS
+	// JSON record of a send interrupted after the amount was entered, to be offered for resuming in the next session.
+	DATA_PENDING_SEND
 )
 
 const (
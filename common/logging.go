@@ -0,0 +1,58 @@
+package common
+
+import (
+	"context"
+
+	"git.defalsify.org/vise.git/logging"
+)
+
+// ContextLogger is a logger adding the identifiers of the request to the messages logged with a context.
+//
+// The identifiers are read from the request context, which the logger of vise cannot look up by itself since it is stored under a typed key.
+type ContextLogger struct {
+	logging.Vanilla
+}
+
+// NewContextLogger creates a new ContextLogger for the given log domain.
+func NewContextLogger(domain string) ContextLogger {
+	return ContextLogger{
+		Vanilla: logging.NewVanilla().WithDomain(domain),
+	}
+}
+
+func (l ContextLogger) TraceCtxf(ctx context.Context, msg string, args ...any) {
+	l.Vanilla.TraceCtxf(ctx, msg, contextArgs(ctx, args)...)
+}
+
+func (l ContextLogger) DebugCtxf(ctx context.Context, msg string, args ...any) {
+	l.Vanilla.DebugCtxf(ctx, msg, contextArgs(ctx, args)...)
+}
+
+func (l ContextLogger) InfoCtxf(ctx context.Context, msg string, args ...any) {
+	l.Vanilla.InfoCtxf(ctx, msg, contextArgs(ctx, args)...)
+}
+
+func (l ContextLogger) WarnCtxf(ctx context.Context, msg string, args ...any) {
+	l.Vanilla.WarnCtxf(ctx, msg, contextArgs(ctx, args)...)
+}
+
+func (l ContextLogger) ErrorCtxf(ctx context.Context, msg string, args ...any) {
+	l.Vanilla.ErrorCtxf(ctx, msg, contextArgs(ctx, args)...)
+}
+
+// contextArgs returns the log arguments with the session, gateway session and request ids of the request appended, if set.
+func contextArgs(ctx context.Context, args []any) []any {
+	r := append([]any{}, args...)
+	sessionId, _ := SessionIdFromContext(ctx)
+	if sessionId != "" {
+		r = append(r, "sessionId", sessionId)
+	}
+	rc, _ := GetRequestContext(ctx)
+	if rc.GatewaySessionId != "" {
+		r = append(r, "gatewaySessionId", rc.GatewaySessionId)
+	}
+	if rc.RequestId != "" {
+		r = append(r, "requestId", rc.RequestId)
+	}
+	return r
+}
@@ -0,0 +1,74 @@
+package common
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"git.defalsify.org/vise.git/db"
+)
+
+// Paginate splits list items into pages, one item per line, so that the content of each page is at most size bytes long.
+//
+// Items too long to fit on a page of their own are truncated. If size is zero or less, all items are returned on a single page.
+func Paginate(items []string, size int) []string {
+	var pages []string
+	var lines []string
+	var l int
+
+	if size <= 0 {
+		return []string{strings.Join(items, "\n")}
+	}
+	for _, item := range items {
+		item = truncate(item, size)
+		n := len(item)
+		if len(lines) > 0 {
+			n += 1
+		}
+		if len(lines) > 0 && l+n > size {
+			pages = append(pages, strings.Join(lines, "\n"))
+			lines = nil
+			l = 0
+			n = len(item)
+		}
+		lines = append(lines, item)
+		l += n
+	}
+	if len(lines) > 0 || len(pages) == 0 {
+		pages = append(pages, strings.Join(lines, "\n"))
+	}
+	return pages
+}
+
+// truncate shortens s to at most size bytes, without splitting a multibyte character.
+func truncate(s string, size int) string {
+	if len(s) <= size {
+		return s
+	}
+	s = s[:size]
+	for len(s) > 0 && !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// GetPageCursor returns the index of the list page currently shown to the user.
+func GetPageCursor(ctx context.Context, store DataStore, sessionId string) (int, error) {
+	v, err := store.ReadEntry(ctx, sessionId, DATA_PAGE_CURSOR)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if len(v) == 0 {
+		return 0, nil
+	}
+	return strconv.Atoi(string(v))
+}
+
+// SetPageCursor records the index of the list page currently shown to the user.
+func SetPageCursor(ctx context.Context, store DataStore, sessionId string, cursor int) error {
+	return store.WriteEntry(ctx, sessionId, DATA_PAGE_CURSOR, []byte(strconv.Itoa(cursor)))
+}
@@ -0,0 +1,42 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestPaginate(t *testing.T) {
+	items := []string{"1:SRF", "2:MILO", "3:GEDA", "4:MUMBUA"}
+
+	pages := Paginate(items, 12)
+	assert.Equal(t, []string{"1:SRF\n2:MILO", "3:GEDA", "4:MUMBUA"}, pages)
+
+	pages = Paginate(items, 0)
+	assert.Equal(t, []string{"1:SRF\n2:MILO\n3:GEDA\n4:MUMBUA"}, pages)
+
+	pages = Paginate([]string{"1:AVERYLONGSYMBOL", "2:SRF"}, 8)
+	assert.Equal(t, []string{"1:AVERYL", "2:SRF"}, pages)
+
+	pages = Paginate([]string{"1:Sent 10 ₭"}, 11)
+	assert.Equal(t, []string{"1:Sent 10 "}, pages)
+
+	pages = Paginate(nil, 10)
+	assert.Equal(t, []string{""}, pages)
+}
+
+func TestPageCursor(t *testing.T) {
+	ctx, store := InitializeTestDb(t)
+	sessionId := "session123"
+
+	cursor, err := GetPageCursor(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, cursor)
+
+	err = SetPageCursor(ctx, store, sessionId, 2)
+	assert.NoError(t, err)
+
+	cursor, err = GetPageCursor(ctx, store, sessionId)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, cursor)
+}
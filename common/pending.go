@@ -0,0 +1,88 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+)
+
+// PendingSend is a send the user entered the amount of, but did not confirm with their PIN.
+//
+// Unlike the scratch data, it outlives the USSD session, so that the send can be resumed when the user dials again.
+type PendingSend struct {
+	// Recipient is the recipient as entered by the user.
+	Recipient string `json:"recipient"`
+	// Address is the resolved address of the recipient.
+	Address string `json:"address"`
+	Amount  string `json:"amount"`
+	// ActiveSym is the symbol of the voucher that was active when the amount was entered.
+	ActiveSym string    `json:"active_sym"`
+	Time      time.Time `json:"time"`
+}
+
+// Expired returns true if the send was interrupted longer than expiry ago.
+func (p *PendingSend) Expired(expiry time.Duration) bool {
+	return time.Since(p.Time) > expiry
+}
+
+// SavePendingSend records the send currently held in the scratch data of the user.
+func SavePendingSend(ctx context.Context, store DataStore, sessionId string) error {
+	data, err := ReadTransactionData(ctx, store, sessionId)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(PendingSend{
+		Recipient: data.TemporaryValue,
+		Address:   data.Recipient,
+		Amount:    data.Amount,
+		ActiveSym: data.ActiveSym,
+		Time:      time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	return store.WriteEntry(ctx, sessionId, DATA_PENDING_SEND, b)
+}
+
+// ReadPendingSend returns the pending send of the user, or nil if there is none.
+func ReadPendingSend(ctx context.Context, store DataStore, sessionId string) (*PendingSend, error) {
+	b, err := store.ReadEntry(ctx, sessionId, DATA_PENDING_SEND)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, nil
+	}
+	var p PendingSend
+	err = json.Unmarshal(b, &p)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ClearPendingSend removes the pending send of the user.
+func ClearPendingSend(ctx context.Context, store DataStore, sessionId string) error {
+	return store.WriteEntry(ctx, sessionId, DATA_PENDING_SEND, []byte(""))
+}
+
+// Restore puts the pending send back in the scratch data of the user, as if the recipient and amount were just entered.
+func (p *PendingSend) Restore(ctx context.Context, store DataStore, sessionId string) error {
+	data := map[DataTyp]string{
+		DATA_TEMPORARY_VALUE: p.Recipient,
+		DATA_RECIPIENT:       p.Address,
+		DATA_AMOUNT:          p.Amount,
+	}
+	for typ, v := range data {
+		err := store.WriteEntry(ctx, sessionId, typ, []byte(v))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
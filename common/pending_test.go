@@ -0,0 +1,62 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPendingSend(t *testing.T) {
+	ctx, store := InitializeTestDb(t)
+	sessionId := "session123"
+
+	p, err := ReadPendingSend(ctx, store, sessionId)
+	require.NoError(t, err)
+	assert.Zero(t, p)
+
+	data := map[DataTyp]string{
+		DATA_TEMPORARY_VALUE: "0712345678",
+		DATA_ACTIVE_SYM:      "SRF",
+		DATA_AMOUNT:          "1.50",
+		DATA_PUBLIC_KEY:      "0xd4c288865Ce",
+		DATA_RECIPIENT:       "0x41c188d63Qa",
+		DATA_ACTIVE_DECIMAL:  "6",
+		DATA_ACTIVE_ADDRESS:  "0xd4c288865Ce0985a481Eef3be02443dF5E2e4Ea9",
+	}
+	for key, value := range data {
+		err := store.WriteEntry(ctx, sessionId, key, []byte(value))
+		require.NoError(t, err)
+	}
+
+	err = SavePendingSend(ctx, store, sessionId)
+	require.NoError(t, err)
+
+	p, err = ReadPendingSend(ctx, store, sessionId)
+	require.NoError(t, err)
+	require.NotNil(t, p)
+	assert.Equal(t, "0712345678", p.Recipient)
+	assert.Equal(t, "0x41c188d63Qa", p.Address)
+	assert.Equal(t, "1.50", p.Amount)
+	assert.Equal(t, "SRF", p.ActiveSym)
+	assert.False(t, p.Expired(time.Minute))
+	p.Time = time.Now().Add(-2 * time.Minute)
+	assert.True(t, p.Expired(time.Minute))
+
+	err = ResetScratch(ctx, store, sessionId)
+	require.NoError(t, err)
+	err = p.Restore(ctx, store, sessionId)
+	require.NoError(t, err)
+	for _, key := range []DataTyp{DATA_TEMPORARY_VALUE, DATA_RECIPIENT, DATA_AMOUNT} {
+		v, err := store.ReadEntry(ctx, sessionId, key)
+		require.NoError(t, err)
+		assert.Equal(t, data[key], string(v))
+	}
+
+	err = ClearPendingSend(ctx, store, sessionId)
+	require.NoError(t, err)
+	p, err = ReadPendingSend(ctx, store, sessionId)
+	require.NoError(t, err)
+	assert.Zero(t, p)
+}
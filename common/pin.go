@@ -1,9 +1,13 @@
 package common
 
 import (
+	"context"
 	"regexp"
+	"strconv"
 
 	"golang.org/x/crypto/bcrypt"
+
+	"git.defalsify.org/vise.git/db"
 )
 
 const (
@@ -35,3 +39,50 @@ func VerifyPIN(hashedPIN, pin string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hashedPIN), []byte(pin))
 	return err == nil
 }
+
+// ReadIncorrectPINAttempts returns the number of incorrect PIN entries of the user since the last correct one.
+func ReadIncorrectPINAttempts(ctx context.Context, store DataStore, sessionId string) (uint8, error) {
+	v, err := store.ReadEntry(ctx, sessionId, DATA_INCORRECT_PIN_ATTEMPTS)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	attempts, _ := strconv.ParseUint(string(v), 0, 64)
+	return uint8(attempts), nil
+}
+
+// IsPINBlocked returns true if the user has used up the allowed incorrect PIN attempts.
+func IsPINBlocked(ctx context.Context, store DataStore, sessionId string) (bool, error) {
+	attempts, err := ReadIncorrectPINAttempts(ctx, store, sessionId)
+	if err != nil {
+		return false, err
+	}
+	return attempts >= AllowedPINAttempts, nil
+}
+
+// IncrementIncorrectPINAttempts records an incorrect PIN entry of the user.
+func IncrementIncorrectPINAttempts(ctx context.Context, store DataStore, sessionId string) error {
+	attempts, err := ReadIncorrectPINAttempts(ctx, store, sessionId)
+	if err != nil {
+		return err
+	}
+	return store.WriteEntry(ctx, sessionId, DATA_INCORRECT_PIN_ATTEMPTS, []byte(strconv.Itoa(int(attempts)+1)))
+}
+
+// ResetIncorrectPINAttempts clears the incorrect PIN entries of the user after a correct one.
+//
+// Counts above AllowedPINAttempts are left unchanged.
+//
+// Callers must check IsPINBlocked before verifying the PIN, as a correct PIN would otherwise lift the block.
+func ResetIncorrectPINAttempts(ctx context.Context, store DataStore, sessionId string) error {
+	attempts, err := ReadIncorrectPINAttempts(ctx, store, sessionId)
+	if err != nil {
+		return err
+	}
+	if attempts == 0 || attempts > AllowedPINAttempts {
+		return nil
+	}
+	return store.WriteEntry(ctx, sessionId, DATA_INCORRECT_PIN_ATTEMPTS, []byte("0"))
+}
@@ -163,6 +163,51 @@ func TestVerifyPIN(t *testing.T) {
 	}
 }
 
+func TestIncorrectPINAttempts(t *testing.T) {
+	ctx, store := InitializeTestDb(t)
+	sessionId := "session123"
+
+	for i := uint8(1); i <= AllowedPINAttempts; i++ {
+		blocked, err := IsPINBlocked(ctx, store, sessionId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blocked {
+			t.Fatalf("expected not blocked before attempt %d", i)
+		}
+		err = IncrementIncorrectPINAttempts(ctx, store, sessionId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		attempts, err := ReadIncorrectPINAttempts(ctx, store, sessionId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attempts != i {
+			t.Fatalf("expected %d attempts, got %d", i, attempts)
+		}
+		if i == 1 {
+			// a correct entry clears the count before the limit is reached
+			err = ResetIncorrectPINAttempts(ctx, store, sessionId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = IncrementIncorrectPINAttempts(ctx, store, sessionId)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	blocked, err := IsPINBlocked(ctx, store, sessionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !blocked {
+		t.Fatalf("expected blocked after %d attempts", AllowedPINAttempts)
+	}
+}
+
 // Helper function to hash a PIN for testing purposes
 func hashPINHelper(pin string) string {
 	hashedPIN, err := HashPIN(pin)
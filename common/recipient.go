@@ -49,6 +49,22 @@ func CheckRecipient(recipient string) (string, error) {
 	return "", fmt.Errorf("invalid recipient: must be a phone number, address or alias")
 }
 
+// FormatMsisdn formats a phone number in international format, with or without the leading "+", to "+<country code><number>".
+//
+// It is used for gateways serving other countries than Kenya, which send the number of the subscriber as an MSISDN.
+func FormatMsisdn(msisdn string) (string, error) {
+	msisdn = strings.TrimPrefix(strings.ReplaceAll(msisdn, " ", ""), "+")
+	if len(msisdn) < 8 || len(msisdn) > 15 || strings.HasPrefix(msisdn, "0") {
+		return "", errors.New("invalid msisdn")
+	}
+	for _, c := range msisdn {
+		if c < '0' || c > '9' {
+			return "", errors.New("invalid msisdn")
+		}
+	}
+	return "+" + msisdn, nil
+}
+
 // FormatPhoneNumber formats a Kenyan phone number to "+254xxxxxxxx".
 func FormatPhoneNumber(phone string) (string, error) {
 	if !IsValidPhoneNumber(phone) {
@@ -0,0 +1,23 @@
+package common
+
+import (
+	"context"
+)
+
+var (
+	// Userdata entries holding the values of in-progress sends and PIN changes.
+	scratchTyps = []DataTyp{DATA_TEMPORARY_VALUE, DATA_AMOUNT, DATA_RECIPIENT}
+)
+
+// ResetScratch clears the userdata entries of unfinished menu flows for the given session.
+//
+// It should be called when the USSD session of the user is known to have ended, so that a later session cannot pick up stale values.
+func ResetScratch(ctx context.Context, store DataStore, sessionId string) error {
+	for _, typ := range scratchTyps {
+		err := store.WriteEntry(ctx, sessionId, typ, []byte(""))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,7 +2,9 @@ package config
 
 import (
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"git.grassecon.net/urdt/ussd/initializers"
 )
@@ -40,11 +42,44 @@ var (
 	VoucherTransfersURL string
 	VoucherDataURL      string
 	CheckAliasURL       string
+	CustodialServiceURL string
+	DataServiceURL      string
 	DbConn		string
+	JournalConn	string
 	DefaultLanguage	    string
 	Languages	[]string
 )
 
+var (
+	RateLimitSession string
+	RateLimitSource  string
+	RateLimitSymbols string
+)
+
+var (
+	ShutdownTimeout time.Duration
+)
+
+var (
+	RoutingTable string
+)
+
+var (
+	PendingSendExpiry time.Duration
+)
+
+var (
+	SmsProvider string
+	SmsFile string
+	SmsQueueSize int
+	SmsRetries int
+	SmsRetryDelay time.Duration
+	AtSmsURL string
+	AtSmsUsername string
+	AtSmsApiKey string
+	AtSmsFrom string
+)
+
 func setLanguage() error {
 	defaultLanguage = initializers.GetEnv("DEFAULT_LANGUAGE", defaultLanguage)
 	languages = strings.Split(initializers.GetEnv("LANGUAGES", defaultLanguage), ",")
@@ -84,9 +119,67 @@ func setBase() error {
 
 func setConn() error {
 	DbConn = initializers.GetEnv("DB_CONN", "")
+	JournalConn = initializers.GetEnv("JOURNAL_CONN", "")
+	return nil
+}
+
+// setRateLimit reads the token bucket policies for rate limiting.
+//
+// Policies are given as "<requests>/<period>", e.g. "10/1m". Symbol policies are a comma separated list of "<symbol>=<policy>". An empty value disables the respective limit.
+func setRateLimit() error {
+	RateLimitSession = initializers.GetEnv("RATE_LIMIT_SESSION", "")
+	RateLimitSource = initializers.GetEnv("RATE_LIMIT_SOURCE", "")
+	RateLimitSymbols = initializers.GetEnv("RATE_LIMIT_SYMBOLS", "")
 	return nil
 }
 
+// setShutdown reads the time allowed for requests in flight to finish when the server is stopped.
+func setShutdown() error {
+	var err error
+	ShutdownTimeout, err = time.ParseDuration(initializers.GetEnv("SHUTDOWN_TIMEOUT", "10s"))
+	return err
+}
+
+// setRouting reads the path of the table routing sessions to menu trees by service and network code.
+func setRouting() error {
+	RoutingTable = initializers.GetEnv("ROUTING_TABLE", "")
+	return nil
+}
+
+// setPendingSend reads how long a send interrupted after the amount was entered is offered to be resumed. Zero disables resuming.
+func setPendingSend() error {
+	var err error
+	PendingSendExpiry, err = time.ParseDuration(initializers.GetEnv("PENDING_SEND_EXPIRY", "10m"))
+	return err
+}
+
+// setSms reads the provider sending outbound SMS, such as invites, and the retry policy of the queue.
+//
+// An empty provider disables outbound SMS.
+func setSms() error {
+	var err error
+	SmsProvider = initializers.GetEnv("SMS_PROVIDER", "")
+	SmsFile = initializers.GetEnv("SMS_FILE", "")
+	SmsQueueSize, err = strconv.Atoi(initializers.GetEnv("SMS_QUEUE_SIZE", "100"))
+	if err != nil {
+		return err
+	}
+	SmsRetries, err = strconv.Atoi(initializers.GetEnv("SMS_RETRIES", "3"))
+	if err != nil {
+		return err
+	}
+	SmsRetryDelay, err = time.ParseDuration(initializers.GetEnv("SMS_RETRY_DELAY", "30s"))
+	if err != nil {
+		return err
+	}
+	AtSmsURL = initializers.GetEnv("AT_SMS_URL", "https://api.africastalking.com/version1/messaging")
+	AtSmsUsername = initializers.GetEnv("AT_SMS_USERNAME", "")
+	AtSmsApiKey = initializers.GetEnv("AT_SMS_API_KEY", "")
+	AtSmsFrom = initializers.GetEnv("AT_SMS_FROM", "")
+	_, err = url.Parse(AtSmsURL)
+	return err
+}
+
 // LoadConfig initializes the configuration values after environment variables are loaded.
 func LoadConfig() error {
 	err := setBase()
@@ -101,6 +194,26 @@ func LoadConfig() error {
 	if err != nil {
 		return err
 	}
+	err = setRateLimit()
+	if err != nil {
+		return err
+	}
+	err = setShutdown()
+	if err != nil {
+		return err
+	}
+	err = setRouting()
+	if err != nil {
+		return err
+	}
+	err = setSms()
+	if err != nil {
+		return err
+	}
+	err = setPendingSend()
+	if err != nil {
+		return err
+	}
 	CreateAccountURL, _ = url.JoinPath(custodialURLBase, createAccountPath)
 	TrackStatusURL, _ = url.JoinPath(custodialURLBase, trackStatusPath)
 	BalanceURL, _ = url.JoinPath(custodialURLBase, balancePathPrefix)
@@ -110,6 +223,8 @@ func LoadConfig() error {
 	VoucherTransfersURL, _ = url.JoinPath(dataURLBase, voucherTransfersPathPrefix)
 	VoucherDataURL, _ = url.JoinPath(dataURLBase, voucherDataPathPrefix)
 	CheckAliasURL, _ = url.JoinPath(dataURLBase, AliasPrefix)
+	CustodialServiceURL = custodialURLBase
+	DataServiceURL = dataURLBase
 	DefaultLanguage = defaultLanguage
 	Languages = languages
 
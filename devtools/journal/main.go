@@ -0,0 +1,81 @@
+// print the navigation journal of a session as a transcript
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+
+	"git.defalsify.org/vise.git/logging"
+
+	"git.grassecon.net/urdt/ussd/config"
+	"git.grassecon.net/urdt/ussd/initializers"
+	"git.grassecon.net/urdt/ussd/internal/journal"
+	"git.grassecon.net/urdt/ussd/internal/storage"
+)
+
+var (
+	logg      = logging.NewVanilla()
+	scriptDir = path.Join("services", "registration")
+)
+
+func init() {
+	initializers.LoadEnvVariables()
+}
+
+func main() {
+	config.LoadConfig()
+
+	var connStr string
+	var sessionId string
+	var gatewaySessionId string
+	var flagFile string
+
+	flag.StringVar(&connStr, "c", "", "journal connection string")
+	flag.StringVar(&sessionId, "session-id", "", "session id")
+	flag.StringVar(&gatewaySessionId, "gateway-session-id", "", "only show entries of the given gateway session")
+	flag.StringVar(&flagFile, "flags", path.Join(scriptDir, "pp.csv"), "flag definition file")
+	flag.Parse()
+
+	if connStr == "" {
+		connStr = config.JournalConn
+	}
+	if sessionId == "" {
+		fmt.Fprintf(os.Stderr, "session id missing\n")
+		os.Exit(1)
+	}
+	connData, err := storage.ToConnData(connStr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connstr err: %v", err)
+		os.Exit(1)
+	}
+
+	logg.Infof("start command", "conn", connData, "sessionId", sessionId, "gatewaySessionId", gatewaySessionId)
+
+	ctx := context.Background()
+	sink, err := journal.NewSink(ctx, connData)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "journal open error: %v\n", err)
+		os.Exit(1)
+	}
+	defer sink.Close()
+
+	flagNames, err := journal.LoadFlagNames(flagFile)
+	if err != nil {
+		logg.Warnf("flag names not available", "err", err)
+	}
+
+	entries, err := sink.Read(ctx, sessionId)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "journal read error: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = journal.WriteTranscript(os.Stdout, entries, flagNames, gatewaySessionId)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "transcript write error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path"
 
+	"git.grassecon.net/urdt/ussd/common"
 	"git.grassecon.net/urdt/ussd/config"
 	"git.grassecon.net/urdt/ussd/initializers"
 	"git.grassecon.net/urdt/ussd/internal/storage"
@@ -60,7 +61,9 @@ func main() {
 	logg.Infof("start command", "conn", connData)
 
 	ctx := context.Background()
-	ctx = context.WithValue(ctx, "SessionId", sessionId)
+	ctx = common.WithRequestContext(ctx, common.RequestContext{
+		SessionId: sessionId,
+	})
 	ctx = context.WithValue(ctx, "Database", database)
 
 	resourceDir := scriptDir
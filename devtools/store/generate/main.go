@@ -52,7 +52,9 @@ func main() {
 	logg.Infof("start command", "conn", connData)
 
 	ctx := context.Background()
-	ctx = context.WithValue(ctx, "SessionId", sessionId)
+	ctx = common.WithRequestContext(ctx, common.RequestContext{
+		SessionId: sessionId,
+	})
 	ctx = context.WithValue(ctx, "Database", database)
 
 	resourceDir := scriptDir
@@ -7,17 +7,18 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"git.defalsify.org/vise.git/asm"
 
 	"git.defalsify.org/vise.git/cache"
 	"git.defalsify.org/vise.git/db"
-	"git.defalsify.org/vise.git/lang"
-	"git.defalsify.org/vise.git/logging"
 	"git.defalsify.org/vise.git/persist"
 	"git.defalsify.org/vise.git/resource"
 	"git.defalsify.org/vise.git/state"
 	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/config"
+	"git.grassecon.net/urdt/ussd/internal/sms"
 	"git.grassecon.net/urdt/ussd/internal/utils"
 	"git.grassecon.net/urdt/ussd/models"
 	"git.grassecon.net/urdt/ussd/remote"
@@ -28,7 +29,7 @@ import (
 )
 
 var (
-	logg           = logging.NewVanilla().WithDomain("ussdmenuhandler").WithContextKey("SessionId")
+	logg           = common.NewContextLogger("ussdmenuhandler")
 	scriptDir      = path.Join("services", "registration")
 	translationDir = path.Join(scriptDir, "locale")
 )
@@ -66,6 +67,10 @@ type Handlers struct {
 	accountService       remote.AccountServiceInterface
 	prefixDb             dbstorage.PrefixDb
 	profile              *models.Profile
+	outputSize           uint32
+	rs                   resource.Resource
+	smsQueue             sms.Enqueuer
+	pendingSendExpiry    time.Duration
 	ReplaceSeparatorFunc func(string) string
 }
 
@@ -89,6 +94,7 @@ func NewHandlers(appFlags *asm.FlagParser, userdataStore db.Db, adminstore *util
 		accountService:       accountService,
 		prefixDb:             prefixDb,
 		profile:              &models.Profile{Max: 6},
+		pendingSendExpiry:    config.PendingSendExpiry,
 		ReplaceSeparatorFunc: replaceSeparatorFunc,
 	}
 	return h, nil
@@ -103,6 +109,23 @@ func (h *Handlers) WithPersister(pe *persist.Persister) *Handlers {
 	return h
 }
 
+// WithPaging sets the output size and the menu resource used to fit list contents into pages.
+//
+// Without it, lists are returned whole.
+func (h *Handlers) WithPaging(outputSize uint32, rs resource.Resource) *Handlers {
+	h.outputSize = outputSize
+	h.rs = rs
+	return h
+}
+
+// WithSms sets the queue of outbound SMS, such as invites.
+//
+// Without it, invites fail.
+func (h *Handlers) WithSms(q sms.Enqueuer) *Handlers {
+	h.smsQueue = q
+	return h
+}
+
 // Init initializes the handler for a new session.
 func (h *Handlers) Init(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var r resource.Result
@@ -122,10 +145,7 @@ func (h *Handlers) Init(ctx context.Context, sym string, input []byte) (resource
 		h.st.Code = []byte{}
 	}
 
-	sessionId, ok := ctx.Value("SessionId").(string)
-	if ok {
-		ctx = context.WithValue(ctx, "SessionId", sessionId)
-	}
+	sessionId, _ := common.SessionIdFromContext(ctx)
 
 	flag_admin_privilege, _ := h.flagManager.GetFlag("flag_admin_privilege")
 	isAdmin, _ := h.adminstore.IsAdmin(sessionId)
@@ -216,7 +236,7 @@ func (h *Handlers) createAccountNoExist(ctx context.Context, sessionId string, r
 func (h *Handlers) CreateAccount(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 	var err error
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -240,7 +260,7 @@ func (h *Handlers) CreateAccount(ctx context.Context, sym string, input []byte)
 func (h *Handlers) CheckBlockedNumPinMisMatch(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	res := resource.Result{}
 	flag_pin_mismatch, _ := h.flagManager.GetFlag("flag_pin_mismatch")
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -268,7 +288,7 @@ func (h *Handlers) CheckBlockedNumPinMisMatch(ctx context.Context, sym string, i
 // VerifyNewPin checks if a new PIN meets the required format criteria.
 func (h *Handlers) VerifyNewPin(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	res := resource.Result{}
-	_, ok := ctx.Value("SessionId").(string)
+	_, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -291,7 +311,7 @@ func (h *Handlers) SaveTemporaryPin(ctx context.Context, sym string, input []byt
 	var res resource.Result
 	var err error
 
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -321,7 +341,7 @@ func (h *Handlers) SaveOthersTemporaryPin(ctx context.Context, sym string, input
 	var err error
 
 	store := h.userdataStore
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -346,7 +366,7 @@ func (h *Handlers) SaveOthersTemporaryPin(ctx context.Context, sym string, input
 // ConfirmPinChange validates user's new PIN. If input matches the temporary PIN, saves it as the new account PIN.
 func (h *Handlers) ConfirmPinChange(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -391,7 +411,7 @@ func (h *Handlers) VerifyCreatePin(ctx context.Context, sym string, input []byte
 	flag_pin_mismatch, _ := h.flagManager.GetFlag("flag_pin_mismatch")
 	flag_pin_set, _ := h.flagManager.GetFlag("flag_pin_set")
 
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -426,21 +446,11 @@ func (h *Handlers) VerifyCreatePin(ctx context.Context, sym string, input []byte
 	return res, nil
 }
 
-// retrieves language codes from the context that can be used for handling translations.
-func codeFromCtx(ctx context.Context) string {
-	var code string
-	if ctx.Value("Language") != nil {
-		lang := ctx.Value("Language").(lang.Language)
-		code = lang.Code
-	}
-	return code
-}
-
 // SaveFirstname updates the first name in the gdbm with the provided input.
 func (h *Handlers) SaveFirstname(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 	var err error
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -478,7 +488,7 @@ func (h *Handlers) SaveFirstname(ctx context.Context, sym string, input []byte)
 func (h *Handlers) SaveFamilyname(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 	var err error
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -518,7 +528,7 @@ func (h *Handlers) SaveFamilyname(ctx context.Context, sym string, input []byte)
 func (h *Handlers) SaveYob(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 	var err error
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -557,7 +567,7 @@ func (h *Handlers) SaveYob(ctx context.Context, sym string, input []byte) (resou
 func (h *Handlers) SaveLocation(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 	var err error
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -598,7 +608,7 @@ func (h *Handlers) SaveGender(ctx context.Context, sym string, input []byte) (re
 	symbol, _ := h.st.Where()
 	var res resource.Result
 	var err error
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -637,7 +647,7 @@ func (h *Handlers) SaveGender(ctx context.Context, sym string, input []byte) (re
 func (h *Handlers) SaveOfferings(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 	var err error
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -701,7 +711,7 @@ func (h *Handlers) ResetAccountAuthorized(ctx context.Context, sym string, input
 // CheckIdentifier retrieves the PublicKey from the JSON data file.
 func (h *Handlers) CheckIdentifier(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -718,7 +728,7 @@ func (h *Handlers) CheckIdentifier(ctx context.Context, sym string, input []byte
 func (h *Handlers) Authorize(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 	var err error
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -772,7 +782,7 @@ func (h *Handlers) ResetIncorrectPin(ctx context.Context, sym string, input []by
 	flag_incorrect_pin, _ := h.flagManager.GetFlag("flag_incorrect_pin")
 	flag_account_blocked, _ := h.flagManager.GetFlag("flag_account_blocked")
 
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -819,7 +829,7 @@ func (h *Handlers) CheckAccountStatus(ctx context.Context, sym string, input []b
 	flag_account_pending, _ := h.flagManager.GetFlag("flag_account_pending")
 	flag_api_error, _ := h.flagManager.GetFlag("flag_api_call_error")
 
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -857,7 +867,7 @@ func (h *Handlers) Quit(ctx context.Context, sym string, input []byte) (resource
 
 	flag_account_authorized, _ := h.flagManager.GetFlag("flag_account_authorized")
 
-	code := codeFromCtx(ctx)
+	code := common.LanguageCodeFromContext(ctx)
 	l := gotext.NewLocale(translationDir, code)
 	l.AddDomain("default")
 
@@ -872,7 +882,7 @@ func (h *Handlers) QuitWithHelp(ctx context.Context, sym string, input []byte) (
 
 	flag_account_authorized, _ := h.flagManager.GetFlag("flag_account_authorized")
 
-	code := codeFromCtx(ctx)
+	code := common.LanguageCodeFromContext(ctx)
 	l := gotext.NewLocale(translationDir, code)
 	l.AddDomain("default")
 
@@ -884,7 +894,7 @@ func (h *Handlers) QuitWithHelp(ctx context.Context, sym string, input []byte) (
 // ShowBlockedAccount displays a message after an account has been blocked and how to reach support.
 func (h *Handlers) ShowBlockedAccount(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
-	code := codeFromCtx(ctx)
+	code := common.LanguageCodeFromContext(ctx)
 	l := gotext.NewLocale(translationDir, code)
 	l.AddDomain("default")
 	res.Content = l.Get("Your account has been locked. For help on how to unblock your account, contact support at: 0757628885")
@@ -928,12 +938,12 @@ func (h *Handlers) CheckBalance(ctx context.Context, sym string, input []byte) (
 	var res resource.Result
 	var err error
 
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
 
-	code := codeFromCtx(ctx)
+	code := common.LanguageCodeFromContext(ctx)
 	l := gotext.NewLocale(translationDir, code)
 	l.AddDomain("default")
 
@@ -977,7 +987,7 @@ func (h *Handlers) CheckBalance(ctx context.Context, sym string, input []byte) (
 func (h *Handlers) FetchCommunityBalance(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 	// retrieve the language code from the context
-	code := codeFromCtx(ctx)
+	code := common.LanguageCodeFromContext(ctx)
 	// Initialize the localization system with the appropriate translation directory
 	l := gotext.NewLocale(translationDir, code)
 	l.AddDomain("default")
@@ -994,7 +1004,7 @@ func (h *Handlers) FetchCommunityBalance(ctx context.Context, sym string, input
 func (h *Handlers) ResetOthersPin(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 	store := h.userdataStore
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -1041,7 +1051,7 @@ func (h *Handlers) ValidateBlockedNumber(ctx context.Context, sym string, input
 
 	flag_unregistered_number, _ := h.flagManager.GetFlag("flag_unregistered_number")
 	store := h.userdataStore
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -1073,7 +1083,7 @@ func (h *Handlers) ValidateRecipient(ctx context.Context, sym string, input []by
 	var res resource.Result
 	store := h.userdataStore
 
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -1168,7 +1178,7 @@ func (h *Handlers) TransactionReset(ctx context.Context, sym string, input []byt
 	var res resource.Result
 	var err error
 
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -1186,33 +1196,65 @@ func (h *Handlers) TransactionReset(ctx context.Context, sym string, input []byt
 		return res, nil
 	}
 
+	// a new send replaces the one left unfinished
+	err = common.ClearPendingSend(ctx, store, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to clear pending send", "error", err)
+	}
+
 	res.FlagReset = append(res.FlagReset, flag_invalid_recipient, flag_invalid_recipient_with_invite)
 
 	return res, nil
 }
 
 // InviteValidRecipient sends an invitation to the valid phone number.
+//
+// The invitation is queued for delivery by SMS, and the user is told it has been sent once it is queued. The result of sending is recorded against the user.
 func (h *Handlers) InviteValidRecipient(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 	store := h.userdataStore
 
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
 
-	code := codeFromCtx(ctx)
+	code := common.LanguageCodeFromContext(ctx)
 	l := gotext.NewLocale(translationDir, code)
 	l.AddDomain("default")
 
 	recipient, _ := store.ReadEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE)
 
-	// TODO
-	// send an invitation SMS
-	// if successful
-	// res.Content = l.Get("Your invitation to %s to join Sarafu Network has been sent.",  string(recipient))
-
 	res.Content = l.Get("Your invite request for %s to Sarafu Network failed. Please try again later.", string(recipient))
+	if h.smsQueue == nil {
+		logg.WarnCtxf(ctx, "no sms queue, cannot send invite", "recipient", string(recipient))
+		return res, nil
+	}
+
+	formattedNumber, err := common.FormatPhoneNumber(string(recipient))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "Failed to format the phone number", "recipient", string(recipient), "error", err)
+		return res, nil
+	}
+
+	var text string
+	rc, _ := common.GetRequestContext(ctx)
+	if rc.ServiceCode != "" {
+		text = l.Get("%s has invited you to join Sarafu Network. Dial %s to create your account.", sessionId, rc.ServiceCode)
+	} else {
+		text = l.Get("%s has invited you to join Sarafu Network.", sessionId)
+	}
+	err = h.smsQueue.Enqueue(ctx, sms.Message{
+		Recipient: formattedNumber,
+		Text:      text,
+		SessionId: sessionId,
+	})
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to queue invite", "recipient", formattedNumber, "error", err)
+		return res, nil
+	}
+
+	res.Content = l.Get("Your invitation to %s to join Sarafu Network has been sent.", string(recipient))
 	return res, nil
 }
 
@@ -1221,7 +1263,7 @@ func (h *Handlers) ResetTransactionAmount(ctx context.Context, sym string, input
 	var res resource.Result
 	var err error
 
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -1244,7 +1286,7 @@ func (h *Handlers) MaxAmount(ctx context.Context, sym string, input []byte) (res
 	var res resource.Result
 	var err error
 
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -1266,7 +1308,7 @@ func (h *Handlers) MaxAmount(ctx context.Context, sym string, input []byte) (res
 func (h *Handlers) ValidateAmount(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -1310,6 +1352,14 @@ func (h *Handlers) ValidateAmount(ctx context.Context, sym string, input []byte)
 		return res, err
 	}
 
+	// keep the send, should the session drop before it is confirmed
+	if h.pendingSendExpiry > 0 {
+		err = common.SavePendingSend(ctx, store, sessionId)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to save pending send", "error", err)
+		}
+	}
+
 	res.Content = formattedAmount
 	return res, nil
 }
@@ -1318,7 +1368,7 @@ func (h *Handlers) ValidateAmount(ctx context.Context, sym string, input []byte)
 func (h *Handlers) GetRecipient(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -1334,7 +1384,7 @@ func (h *Handlers) GetRecipient(ctx context.Context, sym string, input []byte) (
 func (h *Handlers) RetrieveBlockedNumber(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -1350,7 +1400,7 @@ func (h *Handlers) RetrieveBlockedNumber(ctx context.Context, sym string, input
 func (h *Handlers) GetSender(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -1364,7 +1414,7 @@ func (h *Handlers) GetSender(ctx context.Context, sym string, input []byte) (res
 func (h *Handlers) GetAmount(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -1388,14 +1438,14 @@ func (h *Handlers) GetAmount(ctx context.Context, sym string, input []byte) (res
 func (h *Handlers) InitiateTransaction(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 	var err error
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
 
 	flag_account_authorized, _ := h.flagManager.GetFlag("flag_account_authorized")
 
-	code := codeFromCtx(ctx)
+	code := common.LanguageCodeFromContext(ctx)
 	l := gotext.NewLocale(translationDir, code)
 	l.AddDomain("default")
 
@@ -1404,6 +1454,11 @@ func (h *Handlers) InitiateTransaction(ctx context.Context, sym string, input []
 		return res, err
 	}
 
+	err = common.ClearPendingSend(ctx, h.userdataStore, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to clear pending send", "error", err)
+	}
+
 	finalAmountStr, err := common.ParseAndScaleAmount(data.Amount, data.ActiveDecimal)
 	if err != nil {
 		return res, err
@@ -1434,6 +1489,117 @@ func (h *Handlers) InitiateTransaction(ctx context.Context, sym string, input []
 	return res, nil
 }
 
+// CheckPendingSend sets flag_pending_send if the user left a send unfinished in an earlier session, and it has not expired.
+//
+// The send is only offered on the first request of a session, which has an empty input, and not when the user goes back to the main menu later in the session.
+//
+// Expired sends, and sends of a voucher that is no longer the active one, are discarded.
+func (h *Handlers) CheckPendingSend(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := common.SessionIdFromContext(ctx)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_pending_send, _ := h.flagManager.GetFlag("flag_pending_send")
+	store := h.userdataStore
+
+	res.FlagReset = append(res.FlagReset, flag_pending_send)
+	if h.pendingSendExpiry == 0 || len(input) > 0 {
+		return res, nil
+	}
+	p, err := common.ReadPendingSend(ctx, store, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read pending send", "error", err)
+		return res, nil
+	}
+	if p == nil {
+		return res, nil
+	}
+	activeSym, _ := store.ReadEntry(ctx, sessionId, common.DATA_ACTIVE_SYM)
+	if p.Expired(h.pendingSendExpiry) || p.ActiveSym != string(activeSym) {
+		logg.DebugCtxf(ctx, "discarding pending send", "time", p.Time, "activeSym", p.ActiveSym)
+		err = common.ClearPendingSend(ctx, store, sessionId)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to clear pending send", "error", err)
+		}
+		return res, nil
+	}
+
+	res.FlagReset = nil
+	res.FlagSet = append(res.FlagSet, flag_pending_send)
+	return res, nil
+}
+
+// GetPendingSend returns the question whether to continue the pending send.
+func (h *Handlers) GetPendingSend(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := common.SessionIdFromContext(ctx)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+
+	code := common.LanguageCodeFromContext(ctx)
+	l := gotext.NewLocale(translationDir, code)
+	l.AddDomain("default")
+
+	p, err := common.ReadPendingSend(ctx, h.userdataStore, sessionId)
+	if err != nil {
+		return res, err
+	}
+	if p == nil {
+		return res, nil
+	}
+	res.Content = l.Get("Continue sending %s %s to %s?", p.Amount, p.ActiveSym, p.Recipient)
+	return res, nil
+}
+
+// ResumePendingSend handles the answer to whether to continue the pending send.
+//
+// On "1" the send is put back in the scratch data, to be confirmed with the PIN. On "2" it is discarded.
+func (h *Handlers) ResumePendingSend(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := common.SessionIdFromContext(ctx)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+	flag_pending_send, _ := h.flagManager.GetFlag("flag_pending_send")
+	store := h.userdataStore
+
+	switch string(input) {
+	case "1":
+		p, err := common.ReadPendingSend(ctx, store, sessionId)
+		if err != nil {
+			return res, err
+		}
+		if p == nil {
+			return res, fmt.Errorf("no pending send to resume")
+		}
+		// the record is kept until the send is initiated, so that it is still offered when going back from the PIN entry
+		err = p.Restore(ctx, store, sessionId)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to restore pending send", "error", err)
+			return res, err
+		}
+	case "2":
+		err := common.ClearPendingSend(ctx, store, sessionId)
+		if err != nil {
+			return res, err
+		}
+		err = common.ResetScratch(ctx, store, sessionId)
+		if err != nil {
+			return res, err
+		}
+	default:
+		return res, nil
+	}
+
+	res.FlagReset = append(res.FlagReset, flag_pending_send)
+	return res, nil
+}
+
 // GetCurrentProfileInfo retrieves specific profile fields based on the current state of the USSD session.
 // Uses flag management system to track profile field status and handle menu navigation.
 func (h *Handlers) GetCurrentProfileInfo(ctx context.Context, sym string, input []byte) (resource.Result, error) {
@@ -1452,15 +1618,11 @@ func (h *Handlers) GetCurrentProfileInfo(ctx context.Context, sym string, input
 
 	res.FlagReset = append(res.FlagReset, flag_back_set)
 
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
-	language, ok := ctx.Value("Language").(lang.Language)
-	if !ok {
-		return res, fmt.Errorf("value for 'Language' is not of type lang.Language")
-	}
-	code := language.Code
+	code := common.LanguageCodeFromContext(ctx)
 	if code == "swa" {
 		defaultValue = "Haipo"
 	} else {
@@ -1563,15 +1725,11 @@ func (h *Handlers) GetCurrentProfileInfo(ctx context.Context, sym string, input
 func (h *Handlers) GetProfileInfo(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 	var defaultValue string
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
-	language, ok := ctx.Value("Language").(lang.Language)
-	if !ok {
-		return res, fmt.Errorf("value for 'Language' is not of type lang.Language")
-	}
-	code := language.Code
+	code := common.LanguageCodeFromContext(ctx)
 	if code == "swa" {
 		defaultValue = "Haipo"
 	} else {
@@ -1606,7 +1764,7 @@ func (h *Handlers) GetProfileInfo(ctx context.Context, sym string, input []byte)
 			return res, fmt.Errorf("invalid year of birth: %v", err)
 		}
 	}
-	switch language.Code {
+	switch code {
 	case "eng":
 		res.Content = fmt.Sprintf(
 			"Name: %s\nGender: %s\nAge: %s\nLocation: %s\nYou provide: %s\n",
@@ -1634,7 +1792,7 @@ func (h *Handlers) SetDefaultVoucher(ctx context.Context, sym string, input []by
 	var err error
 	store := h.userdataStore
 
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -1716,7 +1874,7 @@ func (h *Handlers) SetDefaultVoucher(ctx context.Context, sym string, input []by
 // them to gdbm.
 func (h *Handlers) CheckVouchers(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -1798,7 +1956,11 @@ func (h *Handlers) GetVoucherList(ctx context.Context, sym string, input []byte)
 
 	formattedData := h.ReplaceSeparatorFunc(string(voucherData))
 
-	res.Content = string(formattedData)
+	res, err = h.listPage(ctx, strings.Split(formattedData, "\n"))
+	if err != nil {
+		logg.ErrorCtxf(ctx, "Failed to page the voucher list", "error", err)
+		return res, err
+	}
 
 	return res, nil
 }
@@ -1807,12 +1969,12 @@ func (h *Handlers) GetVoucherList(ctx context.Context, sym string, input []byte)
 // and displays it to the user for them to select it.
 func (h *Handlers) ViewVoucher(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
 
-	code := codeFromCtx(ctx)
+	code := common.LanguageCodeFromContext(ctx)
 	l := gotext.NewLocale(translationDir, code)
 	l.AddDomain("default")
 
@@ -1849,7 +2011,7 @@ func (h *Handlers) ViewVoucher(ctx context.Context, sym string, input []byte) (r
 func (h *Handlers) SetVoucher(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -1875,7 +2037,7 @@ func (h *Handlers) SetVoucher(ctx context.Context, sym string, input []byte) (re
 func (h *Handlers) GetVoucherDetails(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
 	store := h.userdataStore
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -1906,13 +2068,13 @@ func (h *Handlers) GetVoucherDetails(ctx context.Context, sym string, input []by
 // CheckTransactions retrieves the transactions from the API using the "PublicKey" and stores to prefixDb.
 func (h *Handlers) CheckTransactions(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
 
 	flag_no_transfers, _ := h.flagManager.GetFlag("flag_no_transfers")
-	flag_api_error, _ := h.flagManager.GetFlag("flag_api_error")
+	flag_api_error, _ := h.flagManager.GetFlag("flag_api_call_error")
 
 	store := h.userdataStore
 	publicKey, err := store.ReadEntry(ctx, sessionId, common.DATA_PUBLIC_KEY)
@@ -1964,7 +2126,7 @@ func (h *Handlers) CheckTransactions(ctx context.Context, sym string, input []by
 // GetTransactionsList fetches the list of transactions and formats them.
 func (h *Handlers) GetTransactionsList(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -2021,7 +2183,11 @@ func (h *Handlers) GetTransactionsList(ctx context.Context, sym string, input []
 		formattedTransactions = append(formattedTransactions, transactionLine)
 	}
 
-	res.Content = strings.Join(formattedTransactions, "\n")
+	res, err = h.listPage(ctx, formattedTransactions)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "Failed to page the transaction list", "error", err)
+		return res, err
+	}
 
 	return res, nil
 }
@@ -2030,7 +2196,7 @@ func (h *Handlers) GetTransactionsList(ctx context.Context, sym string, input []
 // and displays it to the user.
 func (h *Handlers) ViewTransactionStatement(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -2116,7 +2282,7 @@ func (h *Handlers) insertProfileItems(ctx context.Context, sessionId string, res
 // UpdateAllProfileItems  is used to persist all the  new profile information and setup  the required profile flags.
 func (h *Handlers) UpdateAllProfileItems(ctx context.Context, sym string, input []byte) (resource.Result, error) {
 	var res resource.Result
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return res, fmt.Errorf("missing session")
 	}
@@ -2129,28 +2295,9 @@ func (h *Handlers) UpdateAllProfileItems(ctx context.Context, sym string, input
 
 // incrementIncorrectPINAttempts keeps track of the number of incorrect PIN attempts
 func (h *Handlers) incrementIncorrectPINAttempts(ctx context.Context, sessionId string) error {
-	var pinAttemptsCount uint8
-	store := h.userdataStore
-
-	currentWrongPinAttempts, err := store.ReadEntry(ctx, sessionId, common.DATA_INCORRECT_PIN_ATTEMPTS)
+	err := common.IncrementIncorrectPINAttempts(ctx, h.userdataStore, sessionId)
 	if err != nil {
-		if db.IsNotFound(err) {
-			//First time Wrong PIN attempt: initialize with a count of 1
-			pinAttemptsCount = 1
-			err = store.WriteEntry(ctx, sessionId, common.DATA_INCORRECT_PIN_ATTEMPTS, []byte(strconv.Itoa(int(pinAttemptsCount))))
-			if err != nil {
-				logg.ErrorCtxf(ctx, "failed to write incorrect PIN attempts ", "key", common.DATA_INCORRECT_PIN_ATTEMPTS, "value", currentWrongPinAttempts, "error", err)
-				return err
-			}
-			return nil
-		}
-	}
-	pinAttemptsValue, _ := strconv.ParseUint(string(currentWrongPinAttempts), 0, 64)
-	pinAttemptsCount = uint8(pinAttemptsValue) + 1
-
-	err = store.WriteEntry(ctx, sessionId, common.DATA_INCORRECT_PIN_ATTEMPTS, []byte(strconv.Itoa(int(pinAttemptsCount))))
-	if err != nil {
-		logg.ErrorCtxf(ctx, "failed to write incorrect PIN attempts ", "key", common.DATA_INCORRECT_PIN_ATTEMPTS, "value", pinAttemptsCount, "error", err)
+		logg.ErrorCtxf(ctx, "failed to write incorrect PIN attempts ", "key", common.DATA_INCORRECT_PIN_ATTEMPTS, "error", err)
 		return err
 	}
 	return nil
@@ -2158,29 +2305,18 @@ func (h *Handlers) incrementIncorrectPINAttempts(ctx context.Context, sessionId
 
 // resetIncorrectPINAttempts resets the number of incorrect PIN attempts after a correct PIN entry
 func (h *Handlers) resetIncorrectPINAttempts(ctx context.Context, sessionId string) error {
-	store := h.userdataStore
-	currentWrongPinAttempts, err := store.ReadEntry(ctx, sessionId, common.DATA_INCORRECT_PIN_ATTEMPTS)
+	err := common.ResetIncorrectPINAttempts(ctx, h.userdataStore, sessionId)
 	if err != nil {
-		if db.IsNotFound(err) {
-			return nil
-		}
+		logg.ErrorCtxf(ctx, "failed to reset incorrect PIN attempts ", "key", common.DATA_INCORRECT_PIN_ATTEMPTS, "error", err)
 		return err
 	}
-	currentWrongPinAttemptsCount, _ := strconv.ParseUint(string(currentWrongPinAttempts), 0, 64)
-	if currentWrongPinAttemptsCount <= uint64(common.AllowedPINAttempts) {
-		err = store.WriteEntry(ctx, sessionId, common.DATA_INCORRECT_PIN_ATTEMPTS, []byte(string("0")))
-		if err != nil {
-			logg.ErrorCtxf(ctx, "failed to reset incorrect PIN attempts ", "key", common.DATA_INCORRECT_PIN_ATTEMPTS, "value", common.AllowedPINAttempts, "error", err)
-			return err
-		}
-	}
 	return nil
 }
 
 // persistLanguageCode persists the selected ISO 639 language code
 func (h *Handlers) persistLanguageCode(ctx context.Context, code string) error {
 	store := h.userdataStore
-	sessionId, ok := ctx.Value("SessionId").(string)
+	sessionId, ok := common.SessionIdFromContext(ctx)
 	if !ok {
 		return fmt.Errorf("missing session")
 	}
@@ -2,18 +2,21 @@ package application
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"path"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"git.defalsify.org/vise.git/cache"
 	"git.defalsify.org/vise.git/lang"
 	"git.defalsify.org/vise.git/persist"
 	"git.defalsify.org/vise.git/resource"
 	"git.defalsify.org/vise.git/state"
+	"git.grassecon.net/urdt/ussd/internal/sms"
 	dbstorage "git.grassecon.net/urdt/ussd/internal/storage/db"
 	"git.grassecon.net/urdt/ussd/internal/testutil/mocks"
 	"git.grassecon.net/urdt/ussd/internal/testutil/testservice"
@@ -1366,6 +1369,120 @@ func TestTransactionReset(t *testing.T) {
 	}
 }
 
+// failingPendingStore fails to write the pending send of the user.
+type failingPendingStore struct {
+	common.DataStore
+}
+
+func (s *failingPendingStore) WriteEntry(ctx context.Context, sessionId string, typ common.DataTyp, value []byte) error {
+	if typ == common.DATA_PENDING_SEND {
+		return errors.New("write failed")
+	}
+	return s.DataStore.WriteEntry(ctx, sessionId, typ, value)
+}
+
+func TestTransactionResetPendingSendError(t *testing.T) {
+	sessionId := "session123"
+	ctx, store := InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", sessionId)
+
+	fm, err := NewFlagManager(flagsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flag_invalid_recipient, _ := fm.GetFlag("flag_invalid_recipient")
+	flag_invalid_recipient_with_invite, _ := fm.GetFlag("flag_invalid_recipient_with_invite")
+
+	h := &Handlers{
+		userdataStore: &failingPendingStore{DataStore: store},
+		flagManager:   fm.parser,
+	}
+
+	// the recipient flags are reset even if the pending send is left
+	res, err := h.TransactionReset(ctx, "transaction_reset", []byte(""))
+	assert.NoError(t, err)
+	assert.Equal(t, resource.Result{
+		FlagReset: []uint32{flag_invalid_recipient, flag_invalid_recipient_with_invite},
+	}, res)
+}
+
+// mockSmsQueue records the messages enqueued, or fails with err.
+type mockSmsQueue struct {
+	msgs []sms.Message
+	err  error
+}
+
+func (q *mockSmsQueue) Enqueue(ctx context.Context, msg sms.Message) error {
+	if q.err != nil {
+		return q.err
+	}
+	q.msgs = append(q.msgs, msg)
+	return nil
+}
+
+func TestInviteValidRecipient(t *testing.T) {
+	sessionId := "+254700000000"
+	ctx, store := InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", sessionId)
+	ctx = common.WithRequestContext(ctx, common.RequestContext{
+		ServiceCode: "*384*96#",
+	})
+
+	err := store.WriteEntry(ctx, sessionId, common.DATA_TEMPORARY_VALUE, []byte("0712345678"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		queue           *mockSmsQueue
+		expectedContent string
+		expectedSent    int
+	}{
+		{
+			name:            "Test with queued invite",
+			queue:           &mockSmsQueue{},
+			expectedContent: "Your invitation to 0712345678 to join Sarafu Network has been sent.",
+			expectedSent:    1,
+		},
+		{
+			name:            "Test with full queue",
+			queue:           &mockSmsQueue{err: sms.ErrQueueFull},
+			expectedContent: "Your invite request for 0712345678 to Sarafu Network failed. Please try again later.",
+		},
+		{
+			name:            "Test without queue",
+			expectedContent: "Your invite request for 0712345678 to Sarafu Network failed. Please try again later.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handlers{
+				userdataStore: store,
+			}
+			if tt.queue != nil {
+				h = h.WithSms(tt.queue)
+			}
+
+			res, err := h.InviteValidRecipient(ctx, "invite_valid_recipient", []byte(""))
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedContent, res.Content)
+			if tt.queue == nil {
+				return
+			}
+			assert.Equal(t, tt.expectedSent, len(tt.queue.msgs))
+			if tt.expectedSent > 0 {
+				msg := tt.queue.msgs[0]
+				assert.Equal(t, "+254712345678", msg.Recipient)
+				assert.Equal(t, sessionId, msg.SessionId)
+				assert.Equal(t, "+254700000000 has invited you to join Sarafu Network. Dial *384*96# to create your account.", msg.Text)
+			}
+		})
+	}
+}
+
 func TestResetTransactionAmount(t *testing.T) {
 	sessionId := "session123"
 	ctx, store := InitializeTestStore(t)
@@ -1629,6 +1746,93 @@ func TestValidateAmount(t *testing.T) {
 	}
 }
 
+func TestPendingSendHandlers(t *testing.T) {
+	sessionId := "session123"
+	ctx, store := InitializeTestStore(t)
+	ctx = context.WithValue(ctx, "SessionId", sessionId)
+
+	fm, err := NewFlagManager(flagsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flag_pending_send, _ := fm.GetFlag("flag_pending_send")
+
+	h := &Handlers{
+		userdataStore:     store,
+		flagManager:       fm.parser,
+		pendingSendExpiry: time.Minute,
+	}
+
+	data := map[common.DataTyp]string{
+		common.DATA_TEMPORARY_VALUE: "0712345678",
+		common.DATA_ACTIVE_SYM:      "SRF",
+		common.DATA_AMOUNT:          "1.50",
+		common.DATA_PUBLIC_KEY:      "0xd4c288865Ce",
+		common.DATA_RECIPIENT:       "0x41c188d63Qa",
+		common.DATA_ACTIVE_DECIMAL:  "6",
+		common.DATA_ACTIVE_ADDRESS:  "0xd4c288865Ce0985a481Eef3be02443dF5E2e4Ea9",
+		common.DATA_ACTIVE_BAL:      "5",
+	}
+	for key, value := range data {
+		err := store.WriteEntry(ctx, sessionId, key, []byte(value))
+		require.NoError(t, err)
+	}
+
+	res, err := h.CheckPendingSend(ctx, "check_pending_send", []byte(""))
+	require.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagReset: []uint32{flag_pending_send}}, res)
+
+	// the send is recorded once the amount is validated, and survives the end of the session
+	_, err = h.ValidateAmount(ctx, "validate_amount", []byte("1.50"))
+	require.NoError(t, err)
+	err = common.ResetScratch(ctx, store, sessionId)
+	require.NoError(t, err)
+
+	res, err = h.CheckPendingSend(ctx, "check_pending_send", []byte(""))
+	require.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagSet: []uint32{flag_pending_send}}, res)
+
+	// going back to the main menu later in the session does not offer it again
+	res, err = h.CheckPendingSend(ctx, "check_pending_send", []byte("0"))
+	require.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagReset: []uint32{flag_pending_send}}, res)
+
+	res, err = h.GetPendingSend(ctx, "get_pending_send", []byte(""))
+	require.NoError(t, err)
+	assert.Equal(t, "Continue sending 1.50 SRF to 0712345678?", res.Content)
+
+	res, err = h.ResumePendingSend(ctx, "resume_pending_send", []byte("1"))
+	require.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagReset: []uint32{flag_pending_send}}, res)
+	for _, key := range []common.DataTyp{common.DATA_TEMPORARY_VALUE, common.DATA_RECIPIENT, common.DATA_AMOUNT} {
+		v, err := store.ReadEntry(ctx, sessionId, key)
+		require.NoError(t, err)
+		assert.Equal(t, data[key], string(v))
+	}
+
+	res, err = h.ResumePendingSend(ctx, "resume_pending_send", []byte("2"))
+	require.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagReset: []uint32{flag_pending_send}}, res)
+	p, err := common.ReadPendingSend(ctx, store, sessionId)
+	require.NoError(t, err)
+	assert.Zero(t, p)
+	v, err := store.ReadEntry(ctx, sessionId, common.DATA_AMOUNT)
+	require.NoError(t, err)
+	assert.Equal(t, "", string(v))
+
+	// sends of another voucher than the active one are discarded
+	_, err = h.ValidateAmount(ctx, "validate_amount", []byte("1.50"))
+	require.NoError(t, err)
+	err = store.WriteEntry(ctx, sessionId, common.DATA_ACTIVE_SYM, []byte("MILO"))
+	require.NoError(t, err)
+	res, err = h.CheckPendingSend(ctx, "check_pending_send", []byte(""))
+	require.NoError(t, err)
+	assert.Equal(t, resource.Result{FlagReset: []uint32{flag_pending_send}}, res)
+	p, err = common.ReadPendingSend(ctx, store, sessionId)
+	require.NoError(t, err)
+	assert.Zero(t, p)
+}
+
 func TestValidateRecipient(t *testing.T) {
 	fm, err := NewFlagManager(flagsPath)
 	if err != nil {
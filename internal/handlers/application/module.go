@@ -0,0 +1,124 @@
+package application
+
+import (
+	"git.defalsify.org/vise.git/resource"
+)
+
+var (
+	// Flags of the flag definition file used by the core menu handlers.
+	coreFlags = []string{
+		"flag_account_authorized",
+		"flag_account_blocked",
+		"flag_account_created",
+		"flag_account_pending",
+		"flag_account_success",
+		"flag_admin_privilege",
+		"flag_allow_update",
+		"flag_api_call_error",
+		"flag_back_set",
+		"flag_familyname_set",
+		"flag_firstname_set",
+		"flag_gender_set",
+		"flag_incorrect_date_format",
+		"flag_incorrect_pin",
+		"flag_incorrect_statement",
+		"flag_incorrect_voucher",
+		"flag_invalid_amount",
+		"flag_invalid_recipient",
+		"flag_invalid_recipient_with_invite",
+		"flag_language_set",
+		"flag_location_set",
+		"flag_no_active_voucher",
+		"flag_no_transfers",
+		"flag_offerings_set",
+		"flag_page_changed",
+		"flag_pending_send",
+		"flag_pin_mismatch",
+		"flag_pin_set",
+		"flag_unregistered_number",
+		"flag_valid_pin",
+		"flag_yob_set",
+	}
+)
+
+// Name implements handlers.Module.
+func (h *Handlers) Name() string {
+	return "core"
+}
+
+// Functions implements handlers.Module, returning the handler functions of the core menus.
+func (h *Handlers) Functions() map[string]resource.EntryFunc {
+	return map[string]resource.EntryFunc{
+		"set_language":                h.SetLanguage,
+		"create_account":              h.CreateAccount,
+		"save_temporary_pin":          h.SaveTemporaryPin,
+		"verify_create_pin":           h.VerifyCreatePin,
+		"check_identifier":            h.CheckIdentifier,
+		"check_account_status":        h.CheckAccountStatus,
+		"authorize_account":           h.Authorize,
+		"quit":                        h.Quit,
+		"check_balance":               h.CheckBalance,
+		"validate_recipient":          h.ValidateRecipient,
+		"transaction_reset":           h.TransactionReset,
+		"invite_valid_recipient":      h.InviteValidRecipient,
+		"max_amount":                  h.MaxAmount,
+		"validate_amount":             h.ValidateAmount,
+		"reset_transaction_amount":    h.ResetTransactionAmount,
+		"get_recipient":               h.GetRecipient,
+		"get_sender":                  h.GetSender,
+		"get_amount":                  h.GetAmount,
+		"reset_incorrect":             h.ResetIncorrectPin,
+		"save_firstname":              h.SaveFirstname,
+		"save_familyname":             h.SaveFamilyname,
+		"save_gender":                 h.SaveGender,
+		"save_location":               h.SaveLocation,
+		"save_yob":                    h.SaveYob,
+		"save_offerings":              h.SaveOfferings,
+		"reset_account_authorized":    h.ResetAccountAuthorized,
+		"reset_allow_update":          h.ResetAllowUpdate,
+		"get_profile_info":            h.GetProfileInfo,
+		"verify_yob":                  h.VerifyYob,
+		"reset_incorrect_date_format": h.ResetIncorrectYob,
+		"initiate_transaction":        h.InitiateTransaction,
+		"check_pending_send":          h.CheckPendingSend,
+		"get_pending_send":            h.GetPendingSend,
+		"resume_pending_send":         h.ResumePendingSend,
+		"verify_new_pin":              h.VerifyNewPin,
+		"confirm_pin_change":          h.ConfirmPinChange,
+		"quit_with_help":              h.QuitWithHelp,
+		"fetch_community_balance":     h.FetchCommunityBalance,
+		"set_default_voucher":         h.SetDefaultVoucher,
+		"check_vouchers":              h.CheckVouchers,
+		"get_vouchers":                h.GetVoucherList,
+		"view_voucher":                h.ViewVoucher,
+		"set_voucher":                 h.SetVoucher,
+		"get_voucher_details":         h.GetVoucherDetails,
+		"reset_valid_pin":             h.ResetValidPin,
+		"check_pin_mismatch":          h.CheckBlockedNumPinMisMatch,
+		"validate_blocked_number":     h.ValidateBlockedNumber,
+		"retrieve_blocked_number":     h.RetrieveBlockedNumber,
+		"reset_unregistered_number":   h.ResetUnregisteredNumber,
+		"reset_others_pin":            h.ResetOthersPin,
+		"save_others_temporary_pin":   h.SaveOthersTemporaryPin,
+		"get_current_profile_info":    h.GetCurrentProfileInfo,
+		"check_transactions":          h.CheckTransactions,
+		"get_transactions":            h.GetTransactionsList,
+		"view_statement":              h.ViewTransactionStatement,
+		"page_list":                   h.PageList,
+		"update_all_profile_items":    h.UpdateAllProfileItems,
+		"set_back":                    h.SetBack,
+		"show_blocked_account":        h.ShowBlockedAccount,
+	}
+}
+
+// Flags implements handlers.Module.
+func (h *Handlers) Flags() []string {
+	return coreFlags
+}
+
+// Templates implements handlers.Module.
+//
+// The core menus make up the menu tree, which is entered at the root node.
+func (h *Handlers) Templates() []string {
+	return []string{"root"}
+}
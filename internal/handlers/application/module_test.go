@@ -0,0 +1,56 @@
+package application
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCoreFunctions checks that every function loaded in the menu .vis files is provided by the core module.
+func TestCoreFunctions(t *testing.T) {
+	h := &Handlers{}
+	fns := h.Functions()
+
+	fps, err := filepath.Glob(path.Join(baseDir, "services", "registration", "*.vis"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fps) == 0 {
+		t.Fatalf("no .vis files found")
+	}
+	for _, fp := range fps {
+		f, err := os.Open(fp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 2 || (fields[0] != "LOAD" && fields[0] != "RELOAD") {
+				continue
+			}
+			_, ok := fns[fields[1]]
+			if !ok {
+				t.Errorf("%s: function '%s' not provided by core module", path.Base(fp), fields[1])
+			}
+		}
+		f.Close()
+	}
+}
+
+func TestCoreFlags(t *testing.T) {
+	fm, err := NewFlagManager(flagsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &Handlers{}
+	for _, flag := range h.Flags() {
+		_, err := fm.GetFlag(flag)
+		if err != nil {
+			t.Errorf("flag '%s' not defined", flag)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"git.defalsify.org/vise.git/resource"
+
+	"git.grassecon.net/urdt/ussd/common"
+)
+
+const (
+	// Inputs to move to the next and previous page of a list.
+	pageNextInput = "11"
+	pagePrevInput = "22"
+)
+
+// menuItem is a menu symbol and the input selecting it, as declared with MOUT in a list node.
+type menuItem struct {
+	sym   string
+	input string
+}
+
+var (
+	// The menu shown below the list in the list nodes.
+	listMenu = []menuItem{
+		{"back", "0"},
+		{"quit", "99"},
+	}
+)
+
+// PageList moves the page cursor of the session when the next or previous page of a list is selected.
+//
+// On any other input flag_page_changed is reset, and the input is left to the next handler of the node.
+func (h *Handlers) PageList(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	var res resource.Result
+
+	sessionId, ok := common.SessionIdFromContext(ctx)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+
+	flag_page_changed, _ := h.flagManager.GetFlag("flag_page_changed")
+
+	inputStr := string(input)
+	if inputStr != pageNextInput && inputStr != pagePrevInput {
+		res.FlagReset = append(res.FlagReset, flag_page_changed)
+		return res, nil
+	}
+
+	cursor, err := common.GetPageCursor(ctx, h.userdataStore, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to read page cursor", "error", err)
+		return res, err
+	}
+	if inputStr == pageNextInput {
+		cursor += 1
+	} else if cursor > 0 {
+		cursor -= 1
+	}
+	err = common.SetPageCursor(ctx, h.userdataStore, sessionId, cursor)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to write page cursor", "error", err)
+		return res, err
+	}
+
+	res.FlagSet = append(res.FlagSet, flag_page_changed)
+	return res, nil
+}
+
+// listPage returns the page of the list items at the page cursor of the session, followed by the menu items to move to the next and previous page where there is one.
+//
+// The pages are sized to fit the output size with the template and menu of the current node. The cursor is reset to the first page unless the node is shown again after PageList.
+func (h *Handlers) listPage(ctx context.Context, items []string) (resource.Result, error) {
+	var res resource.Result
+
+	if h.outputSize == 0 || h.rs == nil {
+		res.Content = strings.Join(items, "\n")
+		return res, nil
+	}
+
+	sessionId, ok := common.SessionIdFromContext(ctx)
+	if !ok {
+		return res, fmt.Errorf("missing session")
+	}
+
+	flag_page_changed, _ := h.flagManager.GetFlag("flag_page_changed")
+
+	nextItem := h.menuLine(ctx, menuItem{"next", pageNextInput})
+	prevItem := h.menuLine(ctx, menuItem{"prev", pagePrevInput})
+	size := int(h.outputSize) - h.nodeReserve(ctx) - len(nextItem) - len(prevItem) - 2
+	pages := common.Paginate(items, size)
+
+	cursor := 0
+	if h.st != nil && h.st.MatchFlag(flag_page_changed, true) {
+		var err error
+		cursor, err = common.GetPageCursor(ctx, h.userdataStore, sessionId)
+		if err != nil {
+			return res, err
+		}
+		res.FlagReset = append(res.FlagReset, flag_page_changed)
+	}
+	if cursor >= len(pages) {
+		cursor = len(pages) - 1
+	}
+	err := common.SetPageCursor(ctx, h.userdataStore, sessionId, cursor)
+	if err != nil {
+		return res, err
+	}
+
+	lines := []string{pages[cursor]}
+	if cursor < len(pages)-1 {
+		lines = append(lines, nextItem)
+	}
+	if cursor > 0 {
+		lines = append(lines, prevItem)
+	}
+	res.Content = strings.Join(lines, "\n")
+	return res, nil
+}
+
+// nodeReserve returns the output size taken by the template and menu of the current node, excluding the list itself.
+func (h *Handlers) nodeReserve(ctx context.Context) int {
+	var reserve int
+
+	if h.st != nil {
+		node, _ := h.st.Where()
+		tpl, err := h.rs.GetTemplate(ctx, node)
+		if err != nil {
+			logg.WarnCtxf(ctx, "failed to read template of list node", "node", node, "error", err)
+		}
+		// drop the placeholder of the list
+		if i := strings.Index(tpl, "{{"); i >= 0 {
+			if j := strings.Index(tpl[i:], "}}"); j >= 0 {
+				tpl = tpl[:i] + tpl[i+j+2:]
+			}
+		}
+		reserve += len(tpl)
+	}
+	for _, item := range listMenu {
+		reserve += len(h.menuLine(ctx, item)) + 1
+	}
+	return reserve
+}
+
+// menuLine renders a menu item the way the engine does.
+func (h *Handlers) menuLine(ctx context.Context, item menuItem) string {
+	label, err := h.rs.GetMenu(ctx, item.sym)
+	if err != nil {
+		logg.WarnCtxf(ctx, "failed to read menu label", "sym", item.sym, "error", err)
+		label = item.sym
+	}
+	return item.input + h.ReplaceSeparatorFunc(":") + label
+}
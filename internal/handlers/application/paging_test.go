@@ -0,0 +1,79 @@
+package application
+
+import (
+	"context"
+	"testing"
+
+	"git.defalsify.org/vise.git/resource"
+	"git.defalsify.org/vise.git/state"
+	"github.com/alecthomas/assert/v2"
+
+	"git.grassecon.net/urdt/ussd/common"
+)
+
+// testListResource serves the template and menu labels of a list node.
+type testListResource struct {
+	resource.Resource
+}
+
+func (rs *testListResource) GetTemplate(ctx context.Context, nodeSym string) (string, error) {
+	return "Vouchers:\n{{.get_vouchers}}", nil
+}
+
+func (rs *testListResource) GetMenu(ctx context.Context, menuSym string) (string, error) {
+	labels := map[string]string{
+		"back": "Back",
+		"quit": "Quit",
+		"next": "Next",
+		"prev": "Prev",
+	}
+	return labels[menuSym], nil
+}
+
+func TestPageList(t *testing.T) {
+	fm, err := NewFlagManager(flagsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flag_page_changed, _ := fm.GetFlag("flag_page_changed")
+
+	ctx, store := InitializeTestStore(t)
+	sessionId := "session123"
+	ctx = common.WithRequestContext(ctx, common.RequestContext{SessionId: sessionId})
+
+	st := state.NewState(128)
+	h := &Handlers{
+		userdataStore:        store,
+		flagManager:          fm.parser,
+		st:                   st,
+		ReplaceSeparatorFunc: mockReplaceSeparator,
+	}
+	h = h.WithPaging(64, &testListResource{})
+
+	// the template, the menu and the next and previous items leave 19 bytes for the list
+	items := []string{"1: SRF", "2: MILO", "3: GEDA", "4: MUMBUA", "5: TEST"}
+
+	res, err := h.listPage(ctx, items)
+	assert.NoError(t, err)
+	assert.Equal(t, "1: SRF\n2: MILO\n11: Next", res.Content)
+
+	res, err = h.PageList(ctx, "page_list", []byte(pageNextInput))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{flag_page_changed}, res.FlagSet)
+	st.SetFlag(flag_page_changed)
+
+	res, err = h.listPage(ctx, items)
+	assert.NoError(t, err)
+	assert.Equal(t, "3: GEDA\n4: MUMBUA\n11: Next\n22: Prev", res.Content)
+	assert.Equal(t, []uint32{flag_page_changed}, res.FlagReset)
+	st.ResetFlag(flag_page_changed)
+
+	res, err = h.PageList(ctx, "page_list", []byte("1"))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{flag_page_changed}, res.FlagReset)
+
+	// a list shown anew starts at the first page
+	res, err = h.listPage(ctx, items)
+	assert.NoError(t, err)
+	assert.Equal(t, "1: SRF\n2: MILO\n11: Next", res.Content)
+}
@@ -1,12 +1,16 @@
 package handlers
 
 import (
+	"io"
+	"time"
+
 	"git.defalsify.org/vise.git/db"
 	"git.defalsify.org/vise.git/engine"
 	"git.defalsify.org/vise.git/persist"
 	"git.defalsify.org/vise.git/resource"
 
 	"git.grassecon.net/urdt/ussd/internal/handlers/application"
+	"git.grassecon.net/urdt/ussd/internal/metrics"
 	"git.grassecon.net/urdt/ussd/internal/storage"
 )
 
@@ -74,7 +78,13 @@ func (f *BaseSessionHandler) Process(rqs RequestSession) (RequestSession, error)
 	}
 	rqs.Engine = en
 
-	r, err = rqs.Engine.Exec(rqs.Ctx, rqs.Input)
+	r = true
+	if rqs.NewSession && len(rqs.InputChain) > 0 {
+		r, err = f.replay(rqs)
+	}
+	if err == nil && r {
+		r, err = f.exec(rqs, rqs.Input)
+	}
 	if err != nil {
 		perr := f.provider.Put(rqs.Config.SessionId, rqs.Storage)
 		rqs.Storage = nil
@@ -88,6 +98,34 @@ func (f *BaseSessionHandler) Process(rqs RequestSession) (RequestSession, error)
 	return rqs, nil
 }
 
+// replay executes the inputs entered before the current one on the first request of a session, e.g. from a shortcut dial string.
+//
+// The session is started with an empty input, as for any other first request. The output of each step is discarded. It returns false if the session ended during the replay, in which case the output of the last step is left for the caller.
+func (f *BaseSessionHandler) replay(rqs RequestSession) (bool, error) {
+	inputs := append([][]byte{[]byte{}}, rqs.InputChain...)
+	for i, input := range inputs {
+		r, err := f.exec(rqs, input)
+		if err != nil {
+			return false, err
+		}
+		if !r {
+			logg.InfoCtxf(rqs.Ctx, "session ended during input replay", "sessionId", rqs.Config.SessionId, "step", i)
+			return false, nil
+		}
+		_, err = rqs.Engine.Flush(rqs.Ctx, io.Discard)
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// exec executes the input with the engine of the request, recording the latency.
+func (f *BaseSessionHandler) exec(rqs RequestSession, input []byte) (bool, error) {
+	defer metrics.EngineExec.Since(time.Now())
+	return rqs.Engine.Exec(rqs.Ctx, input)
+}
+
 func (f *BaseSessionHandler) Output(rqs RequestSession) (RequestSession, error) {
 	var err error
 	_, err = rqs.Engine.Flush(rqs.Ctx, rqs.Writer)
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"git.defalsify.org/vise.git/db"
+	"git.defalsify.org/vise.git/engine"
+	"git.defalsify.org/vise.git/persist"
+	"git.defalsify.org/vise.git/resource"
+
+	"git.grassecon.net/urdt/ussd/internal/storage"
+	"git.grassecon.net/urdt/ussd/remote"
+)
+
+// HandlerFactory creates the request handler of a single request.
+type HandlerFactory func(ctx context.Context) (RequestHandler, error)
+
+// NewMenuHandlerFactory returns a HandlerFactory creating a BaseSessionHandler with a resource and menu handlers of its own, registered by the handler service.
+//
+// The stores are shared by all the handlers created.
+func NewMenuHandlerFactory(ms *storage.MenuStorageService, ls *LocalHandlerService, accountService remote.AccountServiceInterface, stateStore db.Db, userdataStore db.Db, rp RequestParser) HandlerFactory {
+	return func(ctx context.Context) (RequestHandler, error) {
+		rs, err := ms.GetResource(ctx)
+		if err != nil {
+			return nil, err
+		}
+		dbResource, ok := rs.(*resource.DbResource)
+		if !ok {
+			return nil, fmt.Errorf("unexpected resource type %T", rs)
+		}
+		hl, err := ls.ForResource(dbResource).GetHandler(accountService)
+		if err != nil {
+			return nil, err
+		}
+		return NewBaseSessionHandler(ls.Cfg, rs, stateStore, userdataStore, rp, hl), nil
+	}
+}
+
+// FactorySessionHandler is a request handler passing each request on to a handler of its own, created by the factory.
+//
+// The menu handlers and persister of a BaseSessionHandler hold the state of the request being processed, so that it must not be shared by requests that may overlap, like those of the HTTP frontends.
+type FactorySessionHandler struct {
+	cfgTemplate engine.Config
+	rp          RequestParser
+	factory     HandlerFactory
+}
+
+func NewFactorySessionHandler(cfg engine.Config, rp RequestParser, factory HandlerFactory) *FactorySessionHandler {
+	return &FactorySessionHandler{
+		cfgTemplate: cfg,
+		rp:          rp,
+		factory:     factory,
+	}
+}
+
+func (f *FactorySessionHandler) GetConfig() engine.Config {
+	return f.cfgTemplate
+}
+
+func (f *FactorySessionHandler) GetRequestParser() RequestParser {
+	return f.rp
+}
+
+func (f *FactorySessionHandler) GetEngine(cfg engine.Config, rs resource.Resource, pe *persist.Persister) engine.Engine {
+	en := engine.NewEngine(cfg, rs)
+	en = en.WithPersister(pe)
+	return en
+}
+
+// Process creates the handler of the request, and passes the request on to it.
+func (f *FactorySessionHandler) Process(rqs RequestSession) (RequestSession, error) {
+	h, err := f.factory(rqs.Ctx)
+	if err != nil {
+		logg.ErrorCtxf(rqs.Ctx, "request handler create error", "err", err)
+		return rqs, ErrEngineInit
+	}
+	rqs.handler = h
+	return h.Process(rqs)
+}
+
+func (f *FactorySessionHandler) Output(rqs RequestSession) (RequestSession, error) {
+	if rqs.handler == nil {
+		return rqs, ErrSessionMissing
+	}
+	return rqs.handler.Output(rqs)
+}
+
+func (f *FactorySessionHandler) Reset(rqs RequestSession) (RequestSession, error) {
+	if rqs.handler == nil {
+		return rqs, ErrSessionMissing
+	}
+	return rqs.handler.Reset(rqs)
+}
+
+// Shutdown does nothing, since the handlers created share the stores of their owner.
+func (f *FactorySessionHandler) Shutdown() {
+}
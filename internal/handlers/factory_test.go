@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"git.defalsify.org/vise.git/engine"
+)
+
+func TestFactorySessionHandler(t *testing.T) {
+	var created []*testHandler
+	factory := func(ctx context.Context) (RequestHandler, error) {
+		h := &testHandler{}
+		created = append(created, h)
+		return h, nil
+	}
+	rh := WithMiddleware(NewFactorySessionHandler(engine.Config{}, nil, factory), LogRequest)
+
+	// the second request is processed before the first one is output
+	var rqss []RequestSession
+	var ws []*bytes.Buffer
+	for i := 0; i < 2; i++ {
+		w := bytes.NewBuffer(nil)
+		rqs, err := rh.Process(RequestSession{
+			Ctx:    context.Background(),
+			Writer: w,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rqss = append(rqss, rqs)
+		ws = append(ws, w)
+	}
+	for i, rqs := range rqss {
+		rqs, err := rh.Output(rqs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = rh.Reset(rqs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ws[i].String() != "menu" {
+			t.Fatalf("request %d: expected 'menu', got '%s'", i, ws[i].String())
+		}
+	}
+
+	if len(created) != 2 {
+		t.Fatalf("expected a handler for each request, got %d", len(created))
+	}
+	for i, h := range created {
+		if len(h.calls) != 3 {
+			t.Fatalf("handler %d: expected process, output and reset, got %v", i, h.calls)
+		}
+	}
+}
+
+func TestFactorySessionHandlerError(t *testing.T) {
+	factory := func(ctx context.Context) (RequestHandler, error) {
+		return nil, errors.New("no resource")
+	}
+	rh := NewFactorySessionHandler(engine.Config{}, nil, factory)
+
+	rqs, err := rh.Process(RequestSession{
+		Ctx: context.Background(),
+	})
+	if err != ErrEngineInit {
+		t.Fatalf("expected ErrEngineInit, got %v", err)
+	}
+	_, err = rh.Output(rqs)
+	if err != ErrSessionMissing {
+		t.Fatalf("expected ErrSessionMissing, got %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"git.grassecon.net/urdt/ussd/internal/metrics"
+)
+
+// Middleware decorates a RequestHandler with behaviour that is shared by all frontends.
+//
+// A middleware will typically embed the RequestHandler it receives and override one or more of the Process, Output and Reset methods, calling through to the embedded handler to continue the chain.
+type Middleware func(RequestHandler) RequestHandler
+
+// ProcessFunc is the signature of RequestHandler.Process.
+type ProcessFunc func(rqs RequestSession) (RequestSession, error)
+
+// WithMiddleware wraps the given handler in the given middlewares.
+//
+// The first middleware in the list is the outermost, and thus sees the request first.
+func WithMiddleware(h RequestHandler, mws ...Middleware) RequestHandler {
+	h = &haltGuard{RequestHandler: h}
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// ProcessMiddleware creates a Middleware that only intercepts the Process step.
+//
+// The function is passed the request and the Process method of the next handler in the chain. It may return without calling next, in which case it should use Halt to provide the response.
+func ProcessMiddleware(fn func(rqs RequestSession, next ProcessFunc) (RequestSession, error)) Middleware {
+	return func(h RequestHandler) RequestHandler {
+		return &processMiddleware{
+			RequestHandler: h,
+			fn:             fn,
+		}
+	}
+}
+
+type processMiddleware struct {
+	RequestHandler
+	fn func(rqs RequestSession, next ProcessFunc) (RequestSession, error)
+}
+
+func (m *processMiddleware) Process(rqs RequestSession) (RequestSession, error) {
+	return m.fn(rqs, m.RequestHandler.Process)
+}
+
+// Halt short-circuits the request with the given content as the final response.
+//
+// The engine will not be executed for the request, and the session will be ended.
+func Halt(rqs RequestSession, content string) RequestSession {
+	rqs.Engine = &staticEngine{
+		content: content,
+	}
+	rqs.Continue = false
+	return rqs
+}
+
+// IsHalted returns true if the request was short-circuited by Halt.
+func IsHalted(rqs RequestSession) bool {
+	_, ok := rqs.Engine.(*staticEngine)
+	return ok
+}
+
+// LogRequest is a middleware that logs the outcome and duration of each processed request.
+func LogRequest(h RequestHandler) RequestHandler {
+	return &requestLogger{
+		RequestHandler: h,
+	}
+}
+
+type requestLogger struct {
+	RequestHandler
+}
+
+func (l *requestLogger) Process(rqs RequestSession) (RequestSession, error) {
+	start := time.Now()
+	rqs, err := l.RequestHandler.Process(rqs)
+	logg.InfoCtxf(rqs.Ctx, "request processed", "sessionId", rqs.Config.SessionId, "continue", rqs.Continue, "halted", IsHalted(rqs), "duration", time.Since(start), "err", err)
+	return rqs, err
+}
+
+// RecordMetrics is a middleware that counts the processed requests by outcome, and tracks the active sessions.
+func RecordMetrics(h RequestHandler) RequestHandler {
+	return &metricsRecorder{
+		RequestHandler: h,
+	}
+}
+
+type metricsRecorder struct {
+	RequestHandler
+}
+
+func (m *metricsRecorder) Process(rqs RequestSession) (RequestSession, error) {
+	rqs, err := m.RequestHandler.Process(rqs)
+	outcome := "end"
+	if err != nil {
+		outcome = "error"
+	} else if IsHalted(rqs) {
+		outcome = "halted"
+	} else if rqs.Continue {
+		outcome = "continue"
+	}
+	metrics.Requests.Inc(outcome)
+	if outcome == "continue" {
+		metrics.Sessions.Touch(rqs.Config.SessionId)
+	} else {
+		metrics.Sessions.End(rqs.Config.SessionId)
+	}
+	return rqs, err
+}
+
+// haltGuard is the innermost link of every middleware chain.
+//
+// It prevents a halted request from reaching the session handler's Reset, since no storage was retrieved for it.
+type haltGuard struct {
+	RequestHandler
+}
+
+func (g *haltGuard) Reset(rqs RequestSession) (RequestSession, error) {
+	if IsHalted(rqs) {
+		return rqs, rqs.Engine.Finish()
+	}
+	return g.RequestHandler.Reset(rqs)
+}
+
+// staticEngine is an engine.Engine that renders a fixed response.
+type staticEngine struct {
+	content string
+	flushed bool
+}
+
+func (en *staticEngine) Init(ctx context.Context) (bool, error) {
+	return false, nil
+}
+
+func (en *staticEngine) Exec(ctx context.Context, input []byte) (bool, error) {
+	return false, nil
+}
+
+func (en *staticEngine) Flush(ctx context.Context, w io.Writer) (int, error) {
+	if en.flushed {
+		return 0, nil
+	}
+	en.flushed = true
+	return io.WriteString(w, en.content)
+}
+
+func (en *staticEngine) Finish() error {
+	return nil
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"git.defalsify.org/vise.git/engine"
+	"git.defalsify.org/vise.git/persist"
+	"git.defalsify.org/vise.git/resource"
+
+	"git.grassecon.net/urdt/ussd/internal/metrics"
+)
+
+// testEngine renders a fixed string on flush.
+type testEngine struct {
+	content string
+}
+
+func (en *testEngine) Init(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+func (en *testEngine) Exec(ctx context.Context, input []byte) (bool, error) {
+	return true, nil
+}
+
+func (en *testEngine) Flush(ctx context.Context, w io.Writer) (int, error) {
+	return io.WriteString(w, en.content)
+}
+
+func (en *testEngine) Finish() error {
+	return nil
+}
+
+// testHandler records the calls made to it.
+type testHandler struct {
+	calls []string
+}
+
+func (h *testHandler) GetConfig() engine.Config {
+	return engine.Config{}
+}
+
+func (h *testHandler) GetRequestParser() RequestParser {
+	return nil
+}
+
+func (h *testHandler) GetEngine(cfg engine.Config, rs resource.Resource, pe *persist.Persister) engine.Engine {
+	return nil
+}
+
+func (h *testHandler) Process(rqs RequestSession) (RequestSession, error) {
+	h.calls = append(h.calls, "process")
+	rqs.Engine = &testEngine{content: "menu"}
+	rqs.Continue = true
+	return rqs, nil
+}
+
+func (h *testHandler) Output(rqs RequestSession) (RequestSession, error) {
+	h.calls = append(h.calls, "output")
+	_, err := rqs.Engine.Flush(rqs.Ctx, rqs.Writer)
+	return rqs, err
+}
+
+func (h *testHandler) Reset(rqs RequestSession) (RequestSession, error) {
+	h.calls = append(h.calls, "reset")
+	return rqs, nil
+}
+
+func (h *testHandler) Shutdown() {
+}
+
+func runCycle(t *testing.T, rh RequestHandler) (RequestSession, string) {
+	w := bytes.NewBuffer(nil)
+	rqs := RequestSession{
+		Ctx:    context.Background(),
+		Writer: w,
+	}
+	rqs, err := rh.Process(rqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rqs, err = rh.Output(rqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rqs, err = rh.Reset(rqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rqs, w.String()
+}
+
+func TestWithMiddlewareOrder(t *testing.T) {
+	var order []string
+	mark := func(name string) Middleware {
+		return ProcessMiddleware(func(rqs RequestSession, next ProcessFunc) (RequestSession, error) {
+			order = append(order, name)
+			return next(rqs)
+		})
+	}
+	h := &testHandler{}
+	rh := WithMiddleware(h, mark("outer"), mark("inner"))
+
+	rqs, s := runCycle(t, rh)
+	if s != "menu" {
+		t.Fatalf("expected 'menu', got '%s'", s)
+	}
+	if !rqs.Continue {
+		t.Fatalf("expected continue")
+	}
+	if len(order) != 2 || order[0] != "outer" || order[1] != "inner" {
+		t.Fatalf("unexpected middleware order: %v", order)
+	}
+	if len(h.calls) != 3 {
+		t.Fatalf("expected process, output and reset on handler, got %v", h.calls)
+	}
+}
+
+func TestWithMiddlewareHalt(t *testing.T) {
+	var reached bool
+	halt := ProcessMiddleware(func(rqs RequestSession, next ProcessFunc) (RequestSession, error) {
+		return Halt(rqs, "Service unavailable"), nil
+	})
+	after := ProcessMiddleware(func(rqs RequestSession, next ProcessFunc) (RequestSession, error) {
+		reached = true
+		return next(rqs)
+	})
+	h := &testHandler{}
+	rh := WithMiddleware(h, LogRequest, halt, after)
+
+	rqs, s := runCycle(t, rh)
+	if s != "Service unavailable" {
+		t.Fatalf("expected halt content, got '%s'", s)
+	}
+	if rqs.Continue {
+		t.Fatalf("expected halted request to end session")
+	}
+	if !IsHalted(rqs) {
+		t.Fatalf("expected request to be marked as halted")
+	}
+	if reached {
+		t.Fatalf("middleware after halt should not be reached")
+	}
+	if len(h.calls) != 1 || h.calls[0] != "output" {
+		t.Fatalf("expected only output on handler, got %v", h.calls)
+	}
+}
+
+func TestRecordMetrics(t *testing.T) {
+	halt := ProcessMiddleware(func(rqs RequestSession, next ProcessFunc) (RequestSession, error) {
+		return Halt(rqs, "Service unavailable"), nil
+	})
+	continued := metrics.Requests.Value("continue")
+	halted := metrics.Requests.Value("halted")
+
+	runCycle(t, WithMiddleware(&testHandler{}, RecordMetrics))
+	if metrics.Requests.Value("continue") != continued+1 {
+		t.Fatalf("expected continued request to be counted")
+	}
+	runCycle(t, WithMiddleware(&testHandler{}, RecordMetrics, halt))
+	if metrics.Requests.Value("halted") != halted+1 {
+		t.Fatalf("expected halted request to be counted")
+	}
+}
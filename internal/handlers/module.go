@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"git.defalsify.org/vise.git/resource"
+	"git.defalsify.org/vise.git/vm"
+)
+
+// Module is a self-contained set of menu features.
+//
+// A module declares everything it expects from the menu resources, so that mismatches are caught when it is registered with the handler service rather than when a user reaches the menu.
+type Module interface {
+	// Name identifies the module in log and error messages.
+	Name() string
+	// Functions returns the handler functions of the module, by the symbol they are loaded with in the .vis files.
+	Functions() map[string]resource.EntryFunc
+	// Flags returns the names of the flags from the flag definition file that the module uses.
+	Flags() []string
+	// Templates returns the menu nodes the module requires a template for.
+	Templates() []string
+}
+
+// RegisterModule checks the declarations of the module, and adds its functions to the resource.
+//
+// It fails if a function symbol is already registered by another module, if a flag is missing from the flag definition file, or if a template cannot be found. Functions loaded by the menu nodes but not registered by any module are caught by GetHandler, once all modules are registered.
+func (ls *LocalHandlerService) RegisterModule(m Module) error {
+	if ls.symbols == nil {
+		ls.symbols = make(map[string]string)
+	}
+
+	fns := m.Functions()
+	for sym := range fns {
+		other, ok := ls.symbols[sym]
+		if ok {
+			return fmt.Errorf("module %s: function '%s' already registered by module %s", m.Name(), sym, other)
+		}
+	}
+	for _, flag := range m.Flags() {
+		_, err := ls.Parser.GetFlag(flag)
+		if err != nil {
+			return fmt.Errorf("module %s: flag '%s' not defined: %v", m.Name(), flag, err)
+		}
+	}
+	if ls.Rs != nil {
+		ctx := context.Background()
+		for _, sym := range m.Templates() {
+			_, err := ls.Rs.GetTemplate(ctx, sym)
+			if err != nil {
+				return fmt.Errorf("module %s: template '%s' not found: %v", m.Name(), sym, err)
+			}
+		}
+	}
+
+	for sym, fn := range fns {
+		ls.DbRs.AddLocalFunc(sym, fn)
+		ls.symbols[sym] = m.Name()
+	}
+	logg.Debugf("module registered", "module", m.Name(), "functions", len(fns))
+	return nil
+}
+
+// ReadLoads returns the symbols loaded with LOAD or RELOAD by the compiled menu nodes in the directory, with the nodes that load them.
+func ReadLoads(dir string) (map[string][]string, error) {
+	loads := make(map[string][]string)
+	fps, err := filepath.Glob(path.Join(dir, "*.bin"))
+	if err != nil {
+		return nil, err
+	}
+	for _, fp := range fps {
+		b, err := os.ReadFile(fp)
+		if err != nil {
+			return nil, err
+		}
+		var w bytes.Buffer
+		_, err = vm.ParseAll(b, &w)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fp, err)
+		}
+		node := strings.TrimSuffix(path.Base(fp), ".bin")
+		for _, line := range strings.Split(w.String(), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			if fields[0] == "LOAD" || fields[0] == "RELOAD" {
+				loads[fields[1]] = append(loads[fields[1]], node)
+			}
+		}
+	}
+	return loads, nil
+}
+
+// checkLoads fails if a symbol loaded by the menu nodes is not registered by any module.
+func (ls *LocalHandlerService) checkLoads() error {
+	var missing []string
+	for sym, nodes := range ls.loads {
+		_, ok := ls.symbols[sym]
+		if !ok {
+			missing = append(missing, fmt.Sprintf("'%s' (loaded by %s)", sym, strings.Join(nodes, ", ")))
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("functions not registered by any module: %s", strings.Join(missing, "; "))
+	}
+	return nil
+}
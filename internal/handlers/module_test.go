@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"git.defalsify.org/vise.git/asm"
+	memdb "git.defalsify.org/vise.git/db/mem"
+	"git.defalsify.org/vise.git/resource"
+	testdataloader "github.com/peteole/testdata-loader"
+)
+
+type testModule struct {
+	name  string
+	fns   []string
+	flags []string
+}
+
+func (m *testModule) Name() string {
+	return m.name
+}
+
+func (m *testModule) Functions() map[string]resource.EntryFunc {
+	fns := make(map[string]resource.EntryFunc)
+	for _, sym := range m.fns {
+		fns[sym] = func(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+			return resource.Result{}, nil
+		}
+	}
+	return fns
+}
+
+func (m *testModule) Flags() []string {
+	return m.flags
+}
+
+func (m *testModule) Templates() []string {
+	return nil
+}
+
+func TestRegisterModule(t *testing.T) {
+	ctx := context.Background()
+	parser := asm.NewFlagParser()
+	_, err := parser.Load(path.Join(testdataloader.GetBasePath(), "services", "registration", "pp.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := memdb.NewMemDb()
+	err = store.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls := &LocalHandlerService{
+		Parser: parser,
+		DbRs:   resource.NewDbResource(store),
+	}
+
+	err = ls.RegisterModule(&testModule{
+		name:  "swap",
+		fns:   []string{"get_pools", "swap_preview"},
+		flags: []string{"flag_account_authorized"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ls.RegisterModule(&testModule{
+		name: "savings",
+		fns:  []string{"get_pools"},
+	})
+	if err == nil {
+		t.Fatalf("expected error on function collision")
+	}
+
+	err = ls.RegisterModule(&testModule{
+		name:  "savings",
+		fns:   []string{"get_savings"},
+		flags: []string{"flag_savings_set"},
+	})
+	if err == nil {
+		t.Fatalf("expected error on missing flag")
+	}
+}
+
+func TestForResource(t *testing.T) {
+	ctx := context.Background()
+	parser := asm.NewFlagParser()
+	_, err := parser.Load(path.Join(testdataloader.GetBasePath(), "services", "registration", "pp.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := memdb.NewMemDb()
+	err = store.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls := &LocalHandlerService{
+		Parser: parser,
+	}
+	m := &testModule{
+		name: "swap",
+		fns:  []string{"get_pools"},
+	}
+
+	// the same module is registered with the resource of each copy
+	for i := 0; i < 2; i++ {
+		rs := resource.NewDbResource(store)
+		c := ls.ForResource(rs)
+		err = c.RegisterModule(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.DbRs != rs {
+			t.Fatalf("expected copy to use the given resource")
+		}
+	}
+	if ls.DbRs != nil || ls.symbols != nil {
+		t.Fatalf("expected service to be left unchanged")
+	}
+}
+
+func TestCheckLoads(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	nodes := map[string]string{
+		"main":  "LOAD check_balance 128\nRELOAD check_balance\nMAP check_balance\nHALT\n",
+		"pools": "LOAD get_pools 0\nMAP get_pools\nHALT\n",
+	}
+	for node, src := range nodes {
+		var b bytes.Buffer
+		_, err := asm.Parse(src, &b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path.Join(dir, node+".bin"), b.Bytes(), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loads, err := ReadLoads(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loads) != 2 || len(loads["check_balance"]) != 2 || loads["get_pools"][0] != "pools" {
+		t.Fatalf("unexpected loads %v", loads)
+	}
+
+	store := memdb.NewMemDb()
+	err = store.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls := &LocalHandlerService{
+		Parser: asm.NewFlagParser(),
+		DbRs:   resource.NewDbResource(store),
+		loads:  loads,
+	}
+	err = ls.RegisterModule(&testModule{
+		name: "swap",
+		fns:  []string{"get_pools"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ls.checkLoads()
+	if err == nil {
+		t.Fatalf("expected error on unregistered symbol")
+	}
+	if !strings.Contains(err.Error(), "'check_balance' (loaded by main") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = ls.RegisterModule(&testModule{
+		name: "balance",
+		fns:  []string{"check_balance"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ls.checkLoads()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+
+	"git.defalsify.org/vise.git/db"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/storage"
+)
+
+// SessionTracker follows the USSD session boundaries reported by the gateway.
+//
+// When a subscriber starts a new gateway session, or the gateway reports that a session has ended, the scratch data of unfinished sends and PIN changes is wiped.
+type SessionTracker struct {
+	stateStore    db.Db
+	userdataStore common.DataStore
+	mu            sync.Mutex
+}
+
+// NewSessionTracker creates a new SessionTracker, recording the current gateway session of each subscriber in the state store.
+func NewSessionTracker(stateStore db.Db, userdataStore db.Db) *SessionTracker {
+	return &SessionTracker{
+		stateStore: stateStore,
+		userdataStore: &common.UserDataStore{
+			Db: userdataStore,
+		},
+	}
+}
+
+// Middleware returns a request handler middleware that resets the scratch data when a request belongs to a new gateway session.
+//
+// The first request of a gateway session is marked with RequestSession.NewSession.
+//
+// Requests without a gateway session id are passed on untouched.
+func (t *SessionTracker) Middleware() Middleware {
+	return ProcessMiddleware(t.process)
+}
+
+func (t *SessionTracker) process(rqs RequestSession, next ProcessFunc) (RequestSession, error) {
+	if rqs.GatewaySessionId != "" {
+		var err error
+		rqs.NewSession, err = t.Begin(rqs.Ctx, rqs.Config.SessionId, rqs.GatewaySessionId)
+		if err != nil {
+			logg.ErrorCtxf(rqs.Ctx, "session tracking failed", "sessionId", rqs.Config.SessionId, "gatewaySessionId", rqs.GatewaySessionId, "err", err)
+			return rqs, ErrStorage
+		}
+	}
+	return next(rqs)
+}
+
+// Begin records gatewaySessionId as the current gateway session of the subscriber.
+//
+// If it differs from the one previously recorded, the scratch data of the subscriber is reset, and true is returned.
+func (t *SessionTracker) Begin(ctx context.Context, sessionId string, gatewaySessionId string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current, err := t.get(ctx, sessionId)
+	if err != nil {
+		return false, err
+	}
+	if current == gatewaySessionId {
+		return false, nil
+	}
+	logg.DebugCtxf(ctx, "new gateway session", "sessionId", sessionId, "gatewaySessionId", gatewaySessionId, "previous", current)
+	err = common.ResetScratch(ctx, t.userdataStore, sessionId)
+	if err != nil {
+		return false, err
+	}
+	return true, t.put(ctx, sessionId, gatewaySessionId)
+}
+
+// End resets the scratch data of the subscriber when the gateway reports that the session has ended.
+//
+// Nothing is done if the subscriber has since started another gateway session.
+func (t *SessionTracker) End(ctx context.Context, sessionId string, gatewaySessionId string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current, err := t.get(ctx, sessionId)
+	if err != nil {
+		return err
+	}
+	if current != "" && current != gatewaySessionId {
+		logg.DebugCtxf(ctx, "ignoring end of superseded gateway session", "sessionId", sessionId, "gatewaySessionId", gatewaySessionId, "current", current)
+		return nil
+	}
+	err = common.ResetScratch(ctx, t.userdataStore, sessionId)
+	if err != nil {
+		return err
+	}
+	return t.put(ctx, sessionId, "")
+}
+
+func (t *SessionTracker) get(ctx context.Context, sessionId string) (string, error) {
+	k := append([]byte{storage.EXTEND_GATEWAY_SESSION}, []byte(sessionId)...)
+	t.stateStore.SetLanguage(nil)
+	t.stateStore.SetPrefix(storage.DATATYPE_EXTEND)
+	v, err := t.stateStore.Get(ctx, k)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return string(v), nil
+}
+
+func (t *SessionTracker) put(ctx context.Context, sessionId string, gatewaySessionId string) error {
+	k := append([]byte{storage.EXTEND_GATEWAY_SESSION}, []byte(sessionId)...)
+	t.stateStore.SetPrefix(storage.DATATYPE_EXTEND)
+	return t.stateStore.Put(ctx, k, []byte(gatewaySessionId))
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	memdb "git.defalsify.org/vise.git/db/mem"
+
+	"git.grassecon.net/urdt/ussd/common"
+)
+
+func TestSessionTracker(t *testing.T) {
+	ctx := context.Background()
+	stateDb := memdb.NewMemDb()
+	err := stateDb.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	userDb := memdb.NewMemDb()
+	err = userDb.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &common.UserDataStore{
+		Db: userDb,
+	}
+	sessionId := "+254700000000"
+	tracker := NewSessionTracker(stateDb, userDb)
+
+	check := func(expect string) {
+		t.Helper()
+		v, err := store.ReadEntry(ctx, sessionId, common.DATA_RECIPIENT)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(v) != expect {
+			t.Fatalf("expected recipient '%s', got '%s'", expect, v)
+		}
+	}
+
+	isNew, err := tracker.Begin(ctx, sessionId, "ATUid_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isNew {
+		t.Fatalf("expected new session")
+	}
+	err = store.WriteEntry(ctx, sessionId, common.DATA_RECIPIENT, []byte("0x1234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// same gateway session keeps the scratch data
+	isNew, err = tracker.Begin(ctx, sessionId, "ATUid_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isNew {
+		t.Fatalf("expected continued session")
+	}
+	check("0x1234")
+
+	// a new gateway session discards it
+	_, err = tracker.Begin(ctx, sessionId, "ATUid_2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("")
+
+	// the end of a superseded session leaves the current one alone
+	err = store.WriteEntry(ctx, sessionId, common.DATA_RECIPIENT, []byte("0x5678"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tracker.End(ctx, sessionId, "ATUid_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("0x5678")
+
+	err = tracker.End(ctx, sessionId, "ATUid_2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("")
+}
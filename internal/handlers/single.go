@@ -5,11 +5,13 @@ import (
 	"errors"
 	"io"
 
+	"git.defalsify.org/vise.git/db"
 	"git.defalsify.org/vise.git/engine"
 	"git.defalsify.org/vise.git/logging"
 	"git.defalsify.org/vise.git/persist"
 	"git.defalsify.org/vise.git/resource"
 
+	"git.grassecon.net/urdt/ussd/common"
 	"git.grassecon.net/urdt/ussd/internal/storage"
 )
 
@@ -35,6 +37,18 @@ type RequestSession struct {
 	Storage  *storage.Storage
 	Writer   io.Writer
 	Continue bool
+	// Source identifies the gateway or client the request arrived from, e.g. its network address.
+	Source string
+	// GatewaySessionId is the identifier the gateway assigned to the USSD session, if any.
+	GatewaySessionId string
+	// NewSession is true if the request is the first one of the gateway session.
+	NewSession bool
+	// InputChain holds the inputs entered earlier in the session, for gateways that send the cumulative input with each request.
+	//
+	// It does not include Input.
+	InputChain [][]byte
+	// handler is the handler created for the request by a FactorySessionHandler.
+	handler RequestHandler
 }
 
 // TODO: seems like can remove this.
@@ -43,6 +57,54 @@ type RequestParser interface {
 	GetInput(rq any) ([]byte, error)
 }
 
+// RequestContextParser is implemented by request parsers that can extract details of the request beyond the session id, like the gateway session id and the dialed service code.
+type RequestContextParser interface {
+	GetRequestContext(ctx context.Context, rq any) (common.RequestContext, error)
+}
+
+// ToRequestContext returns a copy of ctx with the request context of the request.
+//
+// The request context already in ctx, e.g. the defaults set by the cmd entrypoint, is merged with the one extracted by the request parser, if supported. A request id is generated if none is given.
+func ToRequestContext(ctx context.Context, rp RequestParser, rq any, sessionId string) (context.Context, error) {
+	rc, _ := common.GetRequestContext(ctx)
+	rc.SessionId = sessionId
+	cp, ok := rp.(RequestContextParser)
+	if ok {
+		prc, err := cp.GetRequestContext(ctx, rq)
+		if err != nil {
+			return ctx, err
+		}
+		rc = rc.Merge(prc)
+	}
+	if rc.RequestId == "" {
+		rc.RequestId = common.NewRequestId()
+	}
+	return common.WithRequestContext(ctx, rc), nil
+}
+
+// InputChainParser is implemented by request parsers for gateways that send the cumulative input of the session with each request.
+type InputChainParser interface {
+	GetInputChain(rq any) ([][]byte, error)
+}
+
+// StateResolver is implemented by request handlers that keep the session state of some requests in a state store of their own, like the router.
+type StateResolver interface {
+	// GetStateStore returns the state store holding the session state of the request, or nil if it is the default one.
+	GetStateStore(ctx context.Context) db.Db
+}
+
+// ResolveStateStore returns the state store the resolver gives for the request, or the default store if there is none.
+func ResolveStateStore(ctx context.Context, sr StateResolver, stateStore db.Db) db.Db {
+	if sr == nil {
+		return stateStore
+	}
+	store := sr.GetStateStore(ctx)
+	if store == nil {
+		return stateStore
+	}
+	return store
+}
+
 type RequestHandler interface {
 	GetConfig() engine.Config
 	GetRequestParser() RequestParser
@@ -0,0 +1,226 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+	"git.defalsify.org/vise.git/logging"
+
+	"git.grassecon.net/urdt/ussd/internal/storage"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("health")
+)
+
+const (
+	// DefaultTimeout is the time allowed for each readiness check.
+	DefaultTimeout = 2 * time.Second
+)
+
+// Check reports whether a dependency of the server is usable.
+type Check func(ctx context.Context) error
+
+// Checker serves the liveness and readiness endpoints of a server.
+type Checker struct {
+	timeout time.Duration
+	mu      sync.Mutex
+	checks  map[string]*entry
+}
+
+// entry is a readiness check, together with its run still in progress, if any.
+type entry struct {
+	check   Check
+	mu      sync.Mutex
+	running *probe
+}
+
+// probe is a run of a check, which may outlast the readiness request that started it.
+type probe struct {
+	done chan struct{}
+	err  error
+}
+
+// NewChecker creates a new Checker, allowing each readiness check the given time to complete.
+func NewChecker(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Checker{
+		timeout: timeout,
+		checks:  make(map[string]*entry),
+	}
+}
+
+// Add adds a named readiness check.
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = &entry{
+		check: check,
+	}
+}
+
+// Ready runs all readiness checks concurrently, and returns the error of each failed check by name.
+//
+// A check that does not finish in time fails, so that a store blocked on a lock is reported as not ready instead of blocking the probe.
+func (c *Checker) Ready(ctx context.Context) map[string]error {
+	c.mu.Lock()
+	checks := make(map[string]*entry, len(c.checks))
+	for k, v := range c.checks {
+		checks[k] = v
+	}
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failed := make(map[string]error)
+	for name, e := range checks {
+		wg.Add(1)
+		go func(name string, e *entry) {
+			defer wg.Done()
+			err := e.run(ctx)
+			if err != nil {
+				mu.Lock()
+				failed[name] = err
+				mu.Unlock()
+			}
+		}(name, e)
+	}
+	wg.Wait()
+	return failed
+}
+
+// run runs the check, giving up when the context is done.
+//
+// A run that does not finish in time is left to finish in the background, and later calls wait for its result rather than running the check again. A check blocked on a lock thus holds one goroutine, however often the readiness is probed.
+func (e *entry) run(ctx context.Context) error {
+	e.mu.Lock()
+	p := e.running
+	if p == nil {
+		p = &probe{
+			done: make(chan struct{}),
+		}
+		e.running = p
+		go func() {
+			p.err = e.check(ctx)
+			e.mu.Lock()
+			e.running = nil
+			e.mu.Unlock()
+			close(p.done)
+		}()
+	}
+	e.mu.Unlock()
+
+	select {
+	case <-p.done:
+		return p.err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out: %v", ctx.Err())
+	}
+}
+
+// Healthz reports that the process is serving requests.
+func (c *Checker) Healthz(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(200)
+	w.Write([]byte("ok\n"))
+}
+
+// Readyz reports whether all dependencies are usable, listing the result of each check.
+func (c *Checker) Readyz(w http.ResponseWriter, req *http.Request) {
+	failed := c.Ready(req.Context())
+
+	c.mu.Lock()
+	names := make([]string, 0, len(c.checks))
+	for k := range c.checks {
+		names = append(names, k)
+	}
+	c.mu.Unlock()
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		err, ok := failed[name]
+		if ok {
+			fmt.Fprintf(&b, "%s: fail: %v\n", name, err)
+		} else {
+			fmt.Fprintf(&b, "%s: ok\n", name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	if len(failed) > 0 {
+		logg.WarnCtxf(req.Context(), "not ready", "failed", len(failed))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(200)
+	}
+	w.Write([]byte(b.String()))
+}
+
+// Register adds the liveness and readiness endpoints to the mux.
+func (c *Checker) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", c.Healthz)
+	mux.HandleFunc("/readyz", c.Readyz)
+}
+
+// DbCheck checks that the store can be read from.
+//
+// It reads a key that is never written, so that a missing key counts as success.
+//
+// The check sets the prefix of the store, so the store must not be the one shared by the requests being served. Use a handle of its own from the storage service, as AddStorageChecks does.
+func DbCheck(store db.Db) Check {
+	return func(ctx context.Context) error {
+		k := []byte{storage.EXTEND_HEALTH}
+		store.SetLanguage(nil)
+		store.SetPrefix(storage.DATATYPE_EXTEND)
+		_, err := store.Get(ctx, k)
+		if err != nil && !db.IsNotFound(err) {
+			return err
+		}
+		return nil
+	}
+}
+
+// AddStorageChecks adds the readiness checks of the state and userdata stores of the storage service, each reading from a handle of its own.
+func (c *Checker) AddStorageChecks(ctx context.Context, ms *storage.MenuStorageService) error {
+	stateStore, err := ms.GetStateStoreHandle(ctx)
+	if err != nil {
+		return err
+	}
+	userdataStore, err := ms.GetUserdataDbHandle(ctx)
+	if err != nil {
+		return err
+	}
+	c.Add("state store", DbCheck(stateStore))
+	c.Add("userdata store", DbCheck(userdataStore))
+	return nil
+}
+
+// UrlCheck checks that the service at the url can be reached.
+//
+// Any HTTP response counts as success, since only the reachability of the service is checked.
+func UrlCheck(url string) Check {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+}
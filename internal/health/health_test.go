@@ -0,0 +1,117 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	memdb "git.defalsify.org/vise.git/db/mem"
+)
+
+func TestReady(t *testing.T) {
+	c := NewChecker(50 * time.Millisecond)
+	c.Add("ok", func(ctx context.Context) error {
+		return nil
+	})
+	c.Add("fail", func(ctx context.Context) error {
+		return errors.New("connection refused")
+	})
+	c.Add("hang", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+
+	failed := c.Ready(context.Background())
+	if len(failed) != 2 {
+		t.Fatalf("expected 2 failed checks, got %v", failed)
+	}
+	_, ok := failed["fail"]
+	if !ok {
+		t.Fatalf("expected failing check to fail")
+	}
+	_, ok = failed["hang"]
+	if !ok {
+		t.Fatalf("expected hanging check to time out")
+	}
+}
+
+func TestReadyBlockedCheck(t *testing.T) {
+	var runs atomic.Int32
+	unblock := make(chan struct{})
+	c := NewChecker(20 * time.Millisecond)
+	c.Add("blocked", func(ctx context.Context) error {
+		runs.Add(1)
+		<-unblock
+		return nil
+	})
+
+	// probes while the check is blocked wait for the same run
+	for i := 0; i < 3; i++ {
+		failed := c.Ready(context.Background())
+		_, ok := failed["blocked"]
+		if !ok {
+			t.Fatalf("expected blocked check to time out")
+		}
+	}
+	if n := runs.Load(); n != 1 {
+		t.Fatalf("expected 1 run of the blocked check, got %d", n)
+	}
+
+	close(unblock)
+	var failed map[string]error
+	for i := 0; i < 10; i++ {
+		failed = c.Ready(context.Background())
+		if len(failed) == 0 {
+			break
+		}
+	}
+	if len(failed) > 0 {
+		t.Fatalf("expected check to succeed once unblocked, got %v", failed)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	c := NewChecker(0)
+	mux := http.NewServeMux()
+	c.Register(mux)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != 200 {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	ctx := context.Background()
+	store := memdb.NewMemDb()
+	err := store.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Add("state store", DbCheck(store))
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != 200 {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Body.String() != "state store: ok\n" {
+		t.Fatalf("unexpected body %s", w.Body.String())
+	}
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+	c.Add("data service", UrlCheck(url))
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if !strings.HasPrefix(w.Body.String(), "data service: fail") {
+		t.Fatalf("unexpected body %s", w.Body.String())
+	}
+}
@@ -0,0 +1,53 @@
+package at
+
+import (
+	"net/http"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/handlers"
+)
+
+// ATEndSessionHandler receives the end of session notifications sent by Africa's Talking.
+//
+// The notification is passed on to the session tracker, so that scratch data of the ended session is discarded.
+type ATEndSessionHandler struct {
+	tracker *handlers.SessionTracker
+}
+
+func NewATEndSessionHandler(tracker *handlers.SessionTracker) *ATEndSessionHandler {
+	return &ATEndSessionHandler{
+		tracker: tracker,
+	}
+}
+
+func (eh *ATEndSessionHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	err := req.ParseForm()
+	if err != nil {
+		logg.WarnCtxf(ctx, "failed to parse form data", "err", err)
+		http.Error(w, "failed to parse form data", 400)
+		return
+	}
+
+	gatewaySessionId := req.FormValue("sessionId")
+	phoneNumber := req.FormValue("phoneNumber")
+	if gatewaySessionId == "" || phoneNumber == "" {
+		http.Error(w, "missing session", 400)
+		return
+	}
+	sessionId, err := common.FormatPhoneNumber(phoneNumber)
+	if err != nil {
+		logg.WarnCtxf(ctx, "failed to format phone number", "err", err)
+		http.Error(w, "failed to format number", 400)
+		return
+	}
+
+	logg.InfoCtxf(ctx, "session ended", "sessionId", sessionId, "gatewaySessionId", gatewaySessionId, "status", req.FormValue("status"), "errorMessage", req.FormValue("errorMessage"))
+	err = eh.tracker.End(ctx, sessionId, gatewaySessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "session end failed", "sessionId", sessionId, "err", err)
+		http.Error(w, handlers.ErrStorage.Error(), 500)
+		return
+	}
+	w.WriteHeader(200)
+}
@@ -0,0 +1,97 @@
+package at
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	memdb "git.defalsify.org/vise.git/db/mem"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/handlers"
+)
+
+func TestATEndSessionHandler(t *testing.T) {
+	ctx := context.Background()
+	stateDb := memdb.NewMemDb()
+	err := stateDb.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	userDb := memdb.NewMemDb()
+	err = userDb.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &common.UserDataStore{
+		Db: userDb,
+	}
+	tracker := handlers.NewSessionTracker(stateDb, userDb)
+	eh := NewATEndSessionHandler(tracker)
+
+	sessionId := "+254712345678"
+	_, err = tracker.Begin(ctx, sessionId, "ATUid_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.WriteEntry(ctx, sessionId, common.DATA_AMOUNT, []byte("42"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		formData       url.Values
+		expectedStatus int
+	}{
+		{
+			name: "Missing session",
+			formData: url.Values{
+				"phoneNumber": []string{"0712345678"},
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Invalid phone number",
+			formData: url.Values{
+				"sessionId":   []string{"ATUid_1"},
+				"phoneNumber": []string{"abc"},
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Session ended",
+			formData: url.Values{
+				"sessionId":   []string{"ATUid_1"},
+				"phoneNumber": []string{"0712345678"},
+				"status":      []string{"Success"},
+			},
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.formData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+
+			eh.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+
+	v, err := store.ReadEntry(ctx, sessionId, common.DATA_AMOUNT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v) != 0 {
+		t.Fatalf("expected amount to be reset, got '%s'", v)
+	}
+}
@@ -7,11 +7,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"strings"
 
 	"git.grassecon.net/urdt/ussd/common"
 	"git.grassecon.net/urdt/ussd/internal/handlers"
+	httpserver "git.grassecon.net/urdt/ussd/internal/http"
 )
 
 type ATRequestParser struct {
@@ -39,10 +39,6 @@ func (arp *ATRequestParser) GetSessionId(ctx context.Context, rq any) (string, e
 		logg.Warnf("failed to marshal request body", "err", err)
 	} else {
 		decodedStr := string(logBytes)
-		sessionId, err := extractATSessionId(decodedStr)
-		if err != nil {
-			ctx = context.WithValue(ctx, "AT-SessionId", sessionId)
-		}
 		logg.DebugCtxf(ctx, "Received request:", decodedStr)
 	}
 
@@ -85,36 +81,59 @@ func (arp *ATRequestParser) GetInput(rq any) ([]byte, error) {
 	return []byte(trimmedInput), nil
 }
 
-func parseQueryParams(query string) map[string]string {
-	params := make(map[string]string)
-
-	queryParams := strings.Split(query, "&")
-	for _, param := range queryParams {
-		// Split each key-value pair by '='
-		parts := strings.SplitN(param, "=", 2)
-		if len(parts) == 2 {
-			params[parts[0]] = parts[1]
+// GetRequestContext returns the details of the USSD session sent by Africa's Talking with the request.
+func (arp *ATRequestParser) GetRequestContext(ctx context.Context, rq any) (common.RequestContext, error) {
+	var rc common.RequestContext
+	rqv, ok := rq.(*http.Request)
+	if !ok {
+		return rc, handlers.ErrInvalidRequest
+	}
+	if err := rqv.ParseForm(); err != nil {
+		return rc, fmt.Errorf("failed to parse form data: %v", err)
+	}
+	phoneNumber := rqv.FormValue("phoneNumber")
+	if phoneNumber != "" {
+		sessionId, err := common.FormatPhoneNumber(phoneNumber)
+		if err != nil {
+			return rc, fmt.Errorf("failed to format number")
 		}
+		rc.SessionId = sessionId
 	}
-	return params
+	rc.GatewaySessionId = rqv.FormValue("sessionId")
+	rc.NetworkCode = rqv.FormValue("networkCode")
+	rc.ServiceCode = rqv.FormValue("serviceCode")
+	return rc, nil
 }
 
-func extractATSessionId(decodedStr string) (string, error) {
-	var data map[string]string
-	err := json.Unmarshal([]byte(decodedStr), &data)
-
-	if err != nil {
-		logg.Errorf("Error unmarshalling JSON: %v", err)
-		return "", nil
+// GetInputChain returns all the inputs of the session, in the order they were entered.
+//
+// Africa's Talking sends the input of the whole session with each request, separated by '*'. A user dialing a shortcut like *384*96*1*0712345678*100# will have several inputs already on the first request.
+func (arp *ATRequestParser) GetInputChain(rq any) ([][]byte, error) {
+	rqv, ok := rq.(*http.Request)
+	if !ok {
+		return nil, handlers.ErrInvalidRequest
 	}
-	decodedBody, err := url.QueryUnescape(data["body"])
-	if err != nil {
-		logg.Errorf("Error URL-decoding body: %v", err)
-		return "", nil
+	if err := rqv.ParseForm(); err != nil {
+		return nil, fmt.Errorf("failed to parse form data: %v", err)
 	}
-	params := parseQueryParams(decodedBody)
 
-	sessionId := params["sessionId"]
-	return sessionId, nil
+	text := rqv.FormValue("text")
+	if text == "" {
+		return nil, nil
+	}
+
+	var chain [][]byte
+	for _, v := range strings.Split(text, "*") {
+		chain = append(chain, []byte(strings.TrimSpace(v)))
+	}
+	return chain, nil
+}
 
+// WriteResponse frames the menu content for Africa's Talking, prefixing it with "CON " if the session continues and "END " otherwise.
+func (arp *ATRequestParser) WriteResponse(w http.ResponseWriter, req *http.Request, rqs handlers.RequestSession, content []byte) error {
+	prefix := "END "
+	if rqs.Continue {
+		prefix = "CON "
+	}
+	return httpserver.WritePlain(w, append([]byte(prefix), content...))
 }
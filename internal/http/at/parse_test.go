@@ -0,0 +1,104 @@
+package at
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"git.grassecon.net/urdt/ussd/internal/testutil/gatewaytest"
+)
+
+func TestATRequestParser_GetInputChain(t *testing.T) {
+	tests := []struct {
+		name          string
+		text          string
+		expectedChain []string
+		expectedInput string
+	}{
+		{
+			name:          "Empty text",
+			text:          "",
+			expectedChain: nil,
+			expectedInput: "",
+		},
+		{
+			name:          "Single input",
+			text:          "1",
+			expectedChain: []string{"1"},
+			expectedInput: "1",
+		},
+		{
+			name:          "Shortcut dial",
+			text:          "1*0712345678*100",
+			expectedChain: []string{"1", "0712345678", "100"},
+			expectedInput: "100",
+		},
+	}
+
+	parser := &ATRequestParser{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{
+				"phoneNumber": []string{"+254712345678"},
+				"text":        []string{tt.text},
+			}
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			chain, err := parser.GetInputChain(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(chain) != len(tt.expectedChain) {
+				t.Fatalf("Expected chain %v, got %s", tt.expectedChain, chain)
+			}
+			for i, v := range tt.expectedChain {
+				if string(chain[i]) != v {
+					t.Errorf("Expected input %d to be %s, got %s", i, v, chain[i])
+				}
+			}
+
+			input, err := parser.GetInput(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(input) != tt.expectedInput {
+				t.Errorf("Expected input %s, got %s", tt.expectedInput, input)
+			}
+		})
+	}
+}
+
+func TestATRequestParser_GetRequestContext(t *testing.T) {
+	form := url.Values{
+		"sessionId":   []string{"ATUid_1"},
+		"phoneNumber": []string{"0712345678"},
+		"networkCode": []string{"63902"},
+		"serviceCode": []string{"*384*96#"},
+		"text":        []string{""},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	parser := &ATRequestParser{}
+	rc, err := parser.GetRequestContext(req.Context(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.SessionId != "+254712345678" {
+		t.Errorf("Expected session id +254712345678, got %s", rc.SessionId)
+	}
+	if rc.GatewaySessionId != "ATUid_1" {
+		t.Errorf("Expected gateway session id ATUid_1, got %s", rc.GatewaySessionId)
+	}
+	if rc.NetworkCode != "63902" || rc.ServiceCode != "*384*96#" {
+		t.Errorf("Unexpected network or service code: %v", rc)
+	}
+}
+
+func TestFixtures(t *testing.T) {
+	gatewaytest.RunFixtures(t, &ATRequestParser{}, "testdata/*.json")
+}
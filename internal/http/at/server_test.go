@@ -31,37 +31,28 @@ func TestNewATSessionHandler(t *testing.T) {
 func TestATSessionHandler_ServeHTTP(t *testing.T) {
 	tests := []struct {
 		name           string
-		setupMocks     func(*httpmocks.MockRequestHandler, *httpmocks.MockRequestParser, *httpmocks.MockEngine)
+		setupMocks     func(*httpmocks.MockRequestHandler, *httpmocks.MockEngine)
 		formData       url.Values
+		body           string
 		expectedStatus int
 		expectedBody   string
+		expectedBodyPrefix string
 	}{
 		{
 			name: "Successful request",
-			setupMocks: func(mh *httpmocks.MockRequestHandler, mrp *httpmocks.MockRequestParser, me *httpmocks.MockEngine) {
-				mrp.GetSessionIdFunc = func(rq any) (string, error) {
-					req := rq.(*http.Request)
-					return req.FormValue("phoneNumber"), nil
-				}
-				mrp.GetInputFunc = func(rq any) ([]byte, error) {
-					req := rq.(*http.Request)
-					text := req.FormValue("text")
-					parts := strings.Split(text, "*")
-					return []byte(parts[len(parts)-1]), nil
-				}
+			setupMocks: func(mh *httpmocks.MockRequestHandler, me *httpmocks.MockEngine) {
 				mh.ProcessFunc = func(rqs handlers.RequestSession) (handlers.RequestSession, error) {
 					rqs.Continue = true
 					rqs.Engine = me
 					return rqs, nil
 				}
 				mh.GetConfigFunc = func() engine.Config { return engine.Config{} }
-				mh.GetRequestParserFunc = func() handlers.RequestParser { return mrp }
 				mh.OutputFunc = func(rs handlers.RequestSession) (handlers.RequestSession, error) { return rs, nil }
 				mh.ResetFunc = func(rs handlers.RequestSession) (handlers.RequestSession, error) { return rs, nil }
 				me.FlushFunc = func(context.Context, io.Writer) (int, error) { return 0, nil }
 			},
 			formData: url.Values{
-				"phoneNumber": []string{"+1234567890"},
+				"phoneNumber": []string{"+254712345678"},
 				"text":        []string{"1*2*3"},
 			},
 			expectedStatus: http.StatusOK,
@@ -69,12 +60,8 @@ func TestATSessionHandler_ServeHTTP(t *testing.T) {
 		},
 		{
 			name: "GetSessionId error",
-			setupMocks: func(mh *httpmocks.MockRequestHandler, mrp *httpmocks.MockRequestParser, me *httpmocks.MockEngine) {
-				mrp.GetSessionIdFunc = func(rq any) (string, error) {
-					return "", errors.New("no phone number found")
-				}
+			setupMocks: func(mh *httpmocks.MockRequestHandler, me *httpmocks.MockEngine) {
 				mh.GetConfigFunc = func() engine.Config { return engine.Config{} }
-				mh.GetRequestParserFunc = func() handlers.RequestParser { return mrp }
 			},
 			formData: url.Values{
 				"text": []string{"1*2*3"},
@@ -83,62 +70,56 @@ func TestATSessionHandler_ServeHTTP(t *testing.T) {
 			expectedBody:   "",
 		},
 		{
-			name: "GetInput error",
-			setupMocks: func(mh *httpmocks.MockRequestHandler, mrp *httpmocks.MockRequestParser, me *httpmocks.MockEngine) {
-				mrp.GetSessionIdFunc = func(rq any) (string, error) {
-					req := rq.(*http.Request)
-					return req.FormValue("phoneNumber"), nil
-				}
-				mrp.GetInputFunc = func(rq any) ([]byte, error) {
-					return nil, errors.New("no input found")
-				}
+			name: "Invalid phone number",
+			setupMocks: func(mh *httpmocks.MockRequestHandler, me *httpmocks.MockEngine) {
 				mh.GetConfigFunc = func() engine.Config { return engine.Config{} }
-				mh.GetRequestParserFunc = func() handlers.RequestParser { return mrp }
 			},
 			formData: url.Values{
 				"phoneNumber": []string{"+1234567890"},
+				"text":        []string{"1*2*3"},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "",
+		},
+		{
+			name: "Unparseable form body",
+			setupMocks: func(mh *httpmocks.MockRequestHandler, me *httpmocks.MockEngine) {
+				mh.GetConfigFunc = func() engine.Config { return engine.Config{} }
 			},
+			body:           "phoneNumber=%2B254712345678&text=1%ZZ",
 			expectedStatus: http.StatusBadRequest,
 			expectedBody:   "",
 		},
 		{
 			name: "Process error",
-			setupMocks: func(mh *httpmocks.MockRequestHandler, mrp *httpmocks.MockRequestParser, me *httpmocks.MockEngine) {
-				mrp.GetSessionIdFunc = func(rq any) (string, error) {
-					req := rq.(*http.Request)
-					return req.FormValue("phoneNumber"), nil
-				}
-				mrp.GetInputFunc = func(rq any) ([]byte, error) {
-					req := rq.(*http.Request)
-					text := req.FormValue("text")
-					parts := strings.Split(text, "*")
-					return []byte(parts[len(parts)-1]), nil
-				}
+			setupMocks: func(mh *httpmocks.MockRequestHandler, me *httpmocks.MockEngine) {
 				mh.ProcessFunc = func(rqs handlers.RequestSession) (handlers.RequestSession, error) {
 					return rqs, handlers.ErrStorage
 				}
 				mh.GetConfigFunc = func() engine.Config { return engine.Config{} }
-				mh.GetRequestParserFunc = func() handlers.RequestParser { return mrp }
 			},
 			formData: url.Values{
-				"phoneNumber": []string{"+1234567890"},
+				"phoneNumber": []string{"+254712345678"},
 				"text":        []string{"1*2*3"},
 			},
-			expectedStatus: http.StatusInternalServerError,
-			expectedBody:   "",
+			expectedStatus:     http.StatusOK,
+			expectedBodyPrefix: "END Service temporarily unavailable. Please try again later. Reference: ",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockHandler := &httpmocks.MockRequestHandler{}
-			mockRequestParser := &httpmocks.MockRequestParser{}
 			mockEngine := &httpmocks.MockEngine{}
-			tt.setupMocks(mockHandler, mockRequestParser, mockEngine)
+			tt.setupMocks(mockHandler, mockEngine)
 
 			ash := NewATSessionHandler(mockHandler)
 
-			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.formData.Encode()))
+			body := tt.body
+			if body == "" {
+				body = tt.formData.Encode()
+			}
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
 			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 			w := httptest.NewRecorder()
 
@@ -151,6 +132,10 @@ func TestATSessionHandler_ServeHTTP(t *testing.T) {
 			if tt.expectedBody != "" && w.Body.String() != tt.expectedBody {
 				t.Errorf("Expected body %q, got %q", tt.expectedBody, w.Body.String())
 			}
+
+			if !strings.HasPrefix(w.Body.String(), tt.expectedBodyPrefix) {
+				t.Errorf("Expected body to start with %q, got %q", tt.expectedBodyPrefix, w.Body.String())
+			}
 		})
 	}
 }
@@ -158,77 +143,68 @@ func TestATSessionHandler_ServeHTTP(t *testing.T) {
 func TestATSessionHandler_Output(t *testing.T) {
 	tests := []struct {
 		name           string
-		input          handlers.RequestSession
+		cont           bool
+		flushErr       error
 		expectedPrefix string
-		expectedError  bool
 	}{
 		{
-			name: "Continue true",
-			input: handlers.RequestSession{
-				Continue: true,
-				Engine: &httpmocks.MockEngine{
-					FlushFunc: func(context.Context, io.Writer) (int, error) {
-						return 0, nil
-					},
-				},
-				Writer: &httpmocks.MockWriter{},
-			},
-			expectedPrefix: "CON ",
-			expectedError:  false,
+			name:           "Continue true",
+			cont:           true,
+			expectedPrefix: "CON menu",
 		},
 		{
-			name: "Continue false",
-			input: handlers.RequestSession{
-				Continue: false,
-				Engine: &httpmocks.MockEngine{
-					FlushFunc: func(context.Context, io.Writer) (int, error) {
-						return 0, nil
-					},
-				},
-				Writer: &httpmocks.MockWriter{},
-			},
-			expectedPrefix: "END ",
-			expectedError:  false,
+			name:           "Continue false",
+			cont:           false,
+			expectedPrefix: "END menu",
 		},
 		{
-			name: "Flush error",
-			input: handlers.RequestSession{
-				Continue: true,
-				Engine: &httpmocks.MockEngine{
-					FlushFunc: func(context.Context, io.Writer) (int, error) {
-						return 0, errors.New("write error")
-					},
-				},
-				Writer: &httpmocks.MockWriter{},
-			},
-			expectedPrefix: "CON ",
-			expectedError:  true,
+			name:           "Flush error",
+			cont:           true,
+			flushErr:       errors.New("write error"),
+			expectedPrefix: "END Service temporarily unavailable. Please try again later. Reference: ",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ash := &ATSessionHandler{}
-			_, err := ash.Output(tt.input)
-
-			if tt.expectedError && err == nil {
-				t.Error("Expected an error, but got nil")
+			me := &httpmocks.MockEngine{
+				FlushFunc: func(ctx context.Context, w io.Writer) (int, error) {
+					if tt.flushErr != nil {
+						return 0, tt.flushErr
+					}
+					return io.WriteString(w, "menu")
+				},
 			}
-
-			if !tt.expectedError && err != nil {
-				t.Errorf("Unexpected error: %v", err)
+			mh := &httpmocks.MockRequestHandler{
+				GetConfigFunc: func() engine.Config { return engine.Config{} },
+				ProcessFunc: func(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+					rqs.Continue = tt.cont
+					rqs.Engine = me
+					return rqs, nil
+				},
+				OutputFunc: func(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+					_, err := rqs.Engine.Flush(rqs.Ctx, rqs.Writer)
+					return rqs, err
+				},
+				ResetFunc: func(rqs handlers.RequestSession) (handlers.RequestSession, error) { return rqs, nil },
 			}
 
-			mw := tt.input.Writer.(*httpmocks.MockWriter)
-			if !mw.WriteStringCalled {
-				t.Error("WriteString was not called")
+			form := url.Values{
+				"phoneNumber": []string{"+254712345678"},
+				"text":        []string{"1"},
 			}
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
 
-			if mw.WrittenString != tt.expectedPrefix {
-				t.Errorf("Expected prefix %q, got %q", tt.expectedPrefix, mw.WrittenString)
+			NewATSessionHandler(mh).ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			if !strings.HasPrefix(w.Body.String(), tt.expectedPrefix) {
+				t.Errorf("Expected body to start with %q, got %q", tt.expectedPrefix, w.Body.String())
 			}
 		})
 	}
 }
-
-
@@ -0,0 +1,187 @@
+package http
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"git.grassecon.net/urdt/ussd/initializers"
+)
+
+const (
+	// DefaultTokenHeader is the header carrying the shared secret of the gateway.
+	DefaultTokenHeader = "X-Gateway-Token"
+	// DefaultTokenParam is the query parameter carrying the shared secret, for gateways that cannot set headers.
+	DefaultTokenParam = "token"
+	// DefaultSignatureHeader is the header carrying the hex encoded HMAC-SHA256 signature of the request body.
+	DefaultSignatureHeader = "X-Signature"
+)
+
+var (
+	ErrSourceNotAllowed = errors.New("source not allowed")
+	ErrTokenInvalid     = errors.New("invalid token")
+	ErrSignatureInvalid = errors.New("invalid signature")
+)
+
+// InboundAuth authenticates the requests of a USSD gateway.
+//
+// Each of the checks is only done if it is configured, and a request must pass all configured checks.
+type InboundAuth struct {
+	// Networks are the networks requests may come from.
+	Networks []*net.IPNet
+	// Token is the shared secret sent by the gateway in the token header or query parameter.
+	Token string
+	// HmacKey is the key of the HMAC-SHA256 signature of the request body.
+	HmacKey []byte
+	// TokenHeader defaults to DefaultTokenHeader.
+	TokenHeader string
+	// TokenParam defaults to DefaultTokenParam.
+	TokenParam string
+	// SignatureHeader defaults to DefaultSignatureHeader.
+	SignatureHeader string
+}
+
+// InboundAuthFromEnv reads the authentication of a gateway from the environment variables with the given prefix.
+//
+// <prefix>_ALLOW_IPS is a comma separated list of IP addresses and CIDR networks, <prefix>_TOKEN is the shared secret, and <prefix>_HMAC_KEY is the signature key.
+func InboundAuthFromEnv(prefix string) (*InboundAuth, error) {
+	var err error
+	auth := &InboundAuth{
+		Token:   initializers.GetEnv(prefix+"_TOKEN", ""),
+		HmacKey: []byte(initializers.GetEnv(prefix+"_HMAC_KEY", "")),
+	}
+	auth.Networks, err = ParseNetworks(initializers.GetEnv(prefix+"_ALLOW_IPS", ""))
+	if err != nil {
+		return nil, fmt.Errorf("%s_ALLOW_IPS: %v", prefix, err)
+	}
+	return auth, nil
+}
+
+// ParseNetworks parses a comma separated list of IP addresses and CIDR networks.
+func ParseNetworks(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip address '%s'", v)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			v = fmt.Sprintf("%s/%d", v, bits)
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Enabled returns true if any check is configured.
+func (a *InboundAuth) Enabled() bool {
+	return len(a.Networks) > 0 || a.Token != "" || len(a.HmacKey) > 0
+}
+
+// Check returns an error if the request does not pass the configured checks.
+func (a *InboundAuth) Check(req *http.Request) error {
+	if len(a.Networks) > 0 {
+		ip := net.ParseIP(RemoteHost(req))
+		if ip == nil || !a.allowed(ip) {
+			return ErrSourceNotAllowed
+		}
+	}
+	if a.Token != "" {
+		tokenHeader := a.TokenHeader
+		if tokenHeader == "" {
+			tokenHeader = DefaultTokenHeader
+		}
+		tokenParam := a.TokenParam
+		if tokenParam == "" {
+			tokenParam = DefaultTokenParam
+		}
+		v := req.Header.Get(tokenHeader)
+		if v == "" {
+			v = req.URL.Query().Get(tokenParam)
+		}
+		if subtle.ConstantTimeCompare([]byte(v), []byte(a.Token)) != 1 {
+			return ErrTokenInvalid
+		}
+	}
+	if len(a.HmacKey) > 0 {
+		return a.checkSignature(req)
+	}
+	return nil
+}
+
+func (a *InboundAuth) allowed(ip net.IP) bool {
+	for _, n := range a.Networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSignature verifies the signature of the request body, leaving the body in place for the request parser.
+func (a *InboundAuth) checkSignature(req *http.Request) error {
+	signatureHeader := a.SignatureHeader
+	if signatureHeader == "" {
+		signatureHeader = DefaultSignatureHeader
+	}
+	sig, err := hex.DecodeString(strings.TrimPrefix(req.Header.Get(signatureHeader), "sha256="))
+	if err != nil || len(sig) == 0 {
+		return ErrSignatureInvalid
+	}
+	var body []byte
+	if req.Body != nil {
+		body, err = io.ReadAll(req.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read request body: %v", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	mac := hmac.New(sha256.New, a.HmacKey)
+	mac.Write(body)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+// Handler wraps the handler to serve only authenticated requests.
+//
+// Requests from sources that are not allowed are rejected with 403, and requests with an invalid token or signature with 401.
+func (a *InboundAuth) Handler(h http.Handler) http.Handler {
+	if !a.Enabled() {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		err := a.Check(req)
+		if err != nil {
+			logg.WarnCtxf(req.Context(), "inbound request rejected", "source", RemoteHost(req), "path", req.URL.Path, "err", err)
+			code := http.StatusUnauthorized
+			if errors.Is(err, ErrSourceNotAllowed) {
+				code = http.StatusForbidden
+			}
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,163 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func sign(key string, body string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestInboundAuth(t *testing.T) {
+	nets, err := ParseNetworks("10.0.0.0/8, 192.168.1.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := "sessionId=123&phoneNumber=%2B254712345678&text=1"
+
+	tests := []struct {
+		name         string
+		auth         InboundAuth
+		remoteAddr   string
+		url          string
+		header       map[string]string
+		expectedCode int
+	}{
+		{
+			name:         "No checks",
+			remoteAddr:   "203.0.113.1:1234",
+			expectedCode: 200,
+		},
+		{
+			name:         "Allowed network",
+			auth:         InboundAuth{Networks: nets},
+			remoteAddr:   "10.1.2.3:1234",
+			expectedCode: 200,
+		},
+		{
+			name:         "Allowed address",
+			auth:         InboundAuth{Networks: nets},
+			remoteAddr:   "192.168.1.5:1234",
+			expectedCode: 200,
+		},
+		{
+			name:         "Source not allowed",
+			auth:         InboundAuth{Networks: nets},
+			remoteAddr:   "192.168.1.6:1234",
+			expectedCode: 403,
+		},
+		{
+			name:         "Token in header",
+			auth:         InboundAuth{Token: "s3cret"},
+			header:       map[string]string{"X-Gateway-Token": "s3cret"},
+			expectedCode: 200,
+		},
+		{
+			name:         "Token in query",
+			auth:         InboundAuth{Token: "s3cret"},
+			url:          "/?token=s3cret",
+			expectedCode: 200,
+		},
+		{
+			name:         "Wrong token",
+			auth:         InboundAuth{Token: "s3cret"},
+			header:       map[string]string{"X-Gateway-Token": "guess"},
+			expectedCode: 401,
+		},
+		{
+			name:         "Missing token",
+			auth:         InboundAuth{Token: "s3cret"},
+			expectedCode: 401,
+		},
+		{
+			name:         "Valid signature",
+			auth:         InboundAuth{HmacKey: []byte("k3y")},
+			header:       map[string]string{"X-Signature": "sha256=" + sign("k3y", body)},
+			expectedCode: 200,
+		},
+		{
+			name:         "Signature with other key",
+			auth:         InboundAuth{HmacKey: []byte("k3y")},
+			header:       map[string]string{"X-Signature": sign("other", body)},
+			expectedCode: 401,
+		},
+		{
+			name:         "Missing signature",
+			auth:         InboundAuth{HmacKey: []byte("k3y")},
+			expectedCode: 401,
+		},
+		{
+			name:         "All checks",
+			auth:         InboundAuth{Networks: nets, Token: "s3cret", HmacKey: []byte("k3y")},
+			remoteAddr:   "10.1.2.3:1234",
+			header:       map[string]string{"X-Gateway-Token": "s3cret", "X-Signature": sign("k3y", body)},
+			expectedCode: 200,
+		},
+		{
+			name:         "All checks with wrong source",
+			auth:         InboundAuth{Networks: nets, Token: "s3cret", HmacKey: []byte("k3y")},
+			remoteAddr:   "203.0.113.1:1234",
+			header:       map[string]string{"X-Gateway-Token": "s3cret", "X-Signature": sign("k3y", body)},
+			expectedCode: 403,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := tt.auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				b, _ := io.ReadAll(req.Body)
+				got = string(b)
+			}))
+			url := tt.url
+			if url == "" {
+				url = "/"
+			}
+			req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tt.expectedCode {
+				t.Fatalf("expected status %d, got %d", tt.expectedCode, w.Code)
+			}
+			if w.Code == 200 && got != body {
+				t.Fatalf("expected body to reach the handler, got '%s'", got)
+			}
+		})
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	nets, err := ParseNetworks("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nets) != 0 {
+		t.Fatalf("expected no networks, got %v", nets)
+	}
+	nets, err = ParseNetworks("::1,172.16.0.0/12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nets) != 2 {
+		t.Fatalf("expected 2 networks, got %v", nets)
+	}
+	_, err = ParseNetworks("10.0.0")
+	if err == nil {
+		t.Fatal("expected error on invalid address")
+	}
+}
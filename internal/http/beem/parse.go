@@ -0,0 +1,133 @@
+package beem
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"git.defalsify.org/vise.git/logging"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/handlers"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("beem")
+)
+
+const (
+	commandInitiate  = "initiate"
+	commandContinue  = "continue"
+	commandTerminate = "terminate"
+)
+
+// payload is the part of a Beem USSD message carrying the menu exchange.
+type payload struct {
+	RequestId string `json:"request_id"`
+	// Response holds the input of the subscriber in requests.
+	Response string `json:"response,omitempty"`
+	// Request holds the menu content in responses.
+	Request string `json:"request,omitempty"`
+}
+
+// message is the body of both the requests from and the responses to Beem.
+type message struct {
+	Command   string  `json:"command"`
+	Msisdn    string  `json:"msisdn"`
+	SessionId string  `json:"session_id"`
+	Operator  string  `json:"operator"`
+	Payload   payload `json:"payload"`
+}
+
+// BeemRequestParser is the gateway for USSD requests from Beem Africa.
+//
+// Beem posts a JSON message for each step of the session, and expects the menu in a JSON message of the same form in the response.
+type BeemRequestParser struct {
+}
+
+// decode reads the message from the request body, leaving the body in place for subsequent reads.
+func decode(rq any) (message, error) {
+	var msg message
+	rqv, ok := rq.(*http.Request)
+	if !ok {
+		return msg, handlers.ErrInvalidRequest
+	}
+	body, err := io.ReadAll(rqv.Body)
+	if err != nil {
+		return msg, fmt.Errorf("failed to read request body: %v", err)
+	}
+	rqv.Body = io.NopCloser(bytes.NewReader(body))
+	err = json.Unmarshal(body, &msg)
+	if err != nil {
+		return msg, fmt.Errorf("failed to parse request body: %v", err)
+	}
+	return msg, nil
+}
+
+func (brp *BeemRequestParser) GetSessionId(ctx context.Context, rq any) (string, error) {
+	msg, err := decode(rq)
+	if err != nil {
+		logg.WarnCtxf(ctx, "got an invalid request", "err", err)
+		return "", err
+	}
+	if msg.Msisdn == "" {
+		return "", fmt.Errorf("no msisdn found")
+	}
+	return common.FormatMsisdn(msg.Msisdn)
+}
+
+// GetInput returns the input of the subscriber, which is empty at the start of the session.
+func (brp *BeemRequestParser) GetInput(rq any) ([]byte, error) {
+	msg, err := decode(rq)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Command == commandInitiate {
+		return []byte{}, nil
+	}
+	return []byte(strings.TrimSpace(msg.Payload.Response)), nil
+}
+
+// IsSessionEnd returns true for the messages of Beem terminating the session.
+func (brp *BeemRequestParser) IsSessionEnd(rq any) (bool, error) {
+	msg, err := decode(rq)
+	if err != nil {
+		return false, err
+	}
+	return msg.Command == commandTerminate, nil
+}
+
+// GetRequestContext returns the details of the USSD session sent by Beem with the request.
+func (brp *BeemRequestParser) GetRequestContext(ctx context.Context, rq any) (common.RequestContext, error) {
+	var rc common.RequestContext
+	msg, err := decode(rq)
+	if err != nil {
+		return rc, err
+	}
+	rc.GatewaySessionId = msg.SessionId
+	rc.NetworkCode = msg.Operator
+	return rc, nil
+}
+
+// WriteResponse returns the menu content in a message continuing or terminating the session.
+func (brp *BeemRequestParser) WriteResponse(w http.ResponseWriter, req *http.Request, rqs handlers.RequestSession, content []byte) error {
+	msg, err := decode(req)
+	if err != nil {
+		return err
+	}
+	msg.Command = commandTerminate
+	if rqs.Continue {
+		msg.Command = commandContinue
+	}
+	msg.Payload = payload{
+		RequestId: msg.Payload.RequestId,
+		Request:   string(content),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	return json.NewEncoder(w).Encode(msg)
+}
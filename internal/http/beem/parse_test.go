@@ -0,0 +1,11 @@
+package beem
+
+import (
+	"testing"
+
+	"git.grassecon.net/urdt/ussd/internal/testutil/gatewaytest"
+)
+
+func TestFixtures(t *testing.T) {
+	gatewaytest.RunFixtures(t, &BeemRequestParser{}, "testdata/*.json")
+}
@@ -0,0 +1,47 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"path"
+	"strings"
+
+	"gopkg.in/leonelquinteros/gotext.v1"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/config"
+)
+
+var (
+	translationDir = path.Join("services", "registration", "locale")
+)
+
+// NewErrorReference returns a short code identifying an internal error, for the subscriber to give to support.
+func NewErrorReference() string {
+	b := make([]byte, 4)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "00000000"
+	}
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+// InternalError logs the error of the request against a new reference code, and returns the localized message ending the session with it.
+//
+// The message is in the language of the request context, falling back to the default language.
+//
+// The message is shown to the subscriber instead of the error, which may reveal internals of the server.
+func InternalError(ctx context.Context, err error) string {
+	ref := NewErrorReference()
+	sessionId, _ := common.SessionIdFromContext(ctx)
+	logg.ErrorCtxf(ctx, "internal error", "ref", ref, "requestId", common.RequestIdFromContext(ctx), "sessionId", sessionId, "err", err)
+
+	code := common.LanguageCodeFromContext(ctx)
+	if code == "" {
+		code = config.DefaultLanguage
+	}
+	lc := gotext.NewLocale(translationDir, code)
+	lc.AddDomain("default")
+	return lc.Get("Service temporarily unavailable. Please try again later. Reference: %s", ref)
+}
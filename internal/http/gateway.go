@@ -0,0 +1,211 @@
+package http
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+
+	"git.defalsify.org/vise.git/db"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/handlers"
+)
+
+// Gateway adapts the request and response formats of a USSD aggregator to the request handler.
+type Gateway interface {
+	handlers.RequestParser
+	// WriteResponse writes the menu content to the response to the request, framed as the aggregator expects.
+	//
+	// The session continues if rqs.Continue is set, and is ended by the aggregator otherwise.
+	WriteResponse(w http.ResponseWriter, req *http.Request, rqs handlers.RequestSession, content []byte) error
+}
+
+// SessionEndParser is implemented by gateways that notify the end of a session on the same endpoint as the menu requests.
+type SessionEndParser interface {
+	// IsSessionEnd returns true if the request notifies that the session has ended, and carries no input for the menu.
+	IsSessionEnd(rq any) (bool, error)
+}
+
+// GatewaySessionHandler serves menu requests in the format of the given Gateway.
+//
+// If the gateway also implements handlers.RequestContextParser or handlers.InputChainParser, the request context and the input chain of the request are extracted with it. If it implements SessionEndParser, notifications that the session has ended are answered without running the menu.
+type GatewaySessionHandler struct {
+	*SessionHandler
+	gw            Gateway
+	tracker       *handlers.SessionTracker
+	userdataStore common.DataStore
+}
+
+// NewGatewaySessionHandler creates a new GatewaySessionHandler.
+func NewGatewaySessionHandler(h handlers.RequestHandler, gw Gateway) *GatewaySessionHandler {
+	return &GatewaySessionHandler{
+		SessionHandler: ToSessionHandler(h),
+		gw:             gw,
+	}
+}
+
+// WithSessionTracker passes the notifications of the gateway that a session has ended on to the session tracker.
+func (f *GatewaySessionHandler) WithSessionTracker(tracker *handlers.SessionTracker) *GatewaySessionHandler {
+	f.tracker = tracker
+	return f
+}
+
+// WithUserdataStore reads the language chosen by the subscriber from the userdata store, for the message ending the session on internal errors.
+func (f *GatewaySessionHandler) WithUserdataStore(store db.Db) *GatewaySessionHandler {
+	f.userdataStore = &common.UserDataStore{
+		Db: store,
+	}
+	return f
+}
+
+func (f *GatewaySessionHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var err error
+	var content bytes.Buffer
+
+	rqs := handlers.RequestSession{
+		Ctx:    req.Context(),
+		Writer: &content,
+		Source: RemoteHost(req),
+	}
+
+	cfg := f.GetConfig()
+	cfg.SessionId, err = f.gw.GetSessionId(req.Context(), req)
+	if err != nil {
+		logg.ErrorCtxf(rqs.Ctx, "", "header processing error", err)
+		f.WriteError(w, 400, err)
+		return
+	}
+	rqs.Config = cfg
+	rqs.Ctx, err = handlers.ToRequestContext(rqs.Ctx, f.gw, req, cfg.SessionId)
+	if err != nil {
+		logg.ErrorCtxf(rqs.Ctx, "", "header processing error", err)
+		f.WriteError(w, 400, err)
+		return
+	}
+	rqs.GatewaySessionId = common.GatewaySessionIdFromContext(rqs.Ctx)
+	ep, ok := f.gw.(SessionEndParser)
+	if ok {
+		end, err := ep.IsSessionEnd(req)
+		if err != nil {
+			logg.ErrorCtxf(rqs.Ctx, "", "header processing error", err)
+			f.WriteError(w, 400, err)
+			return
+		}
+		if end {
+			f.endSession(w, rqs)
+			return
+		}
+	}
+	rqs.Input, err = f.gw.GetInput(req)
+	if err != nil {
+		logg.ErrorCtxf(rqs.Ctx, "", "header processing error", err)
+		f.WriteError(w, 400, err)
+		return
+	}
+	cp, ok := f.gw.(handlers.InputChainParser)
+	if ok {
+		chain, err := cp.GetInputChain(req)
+		if err != nil {
+			logg.ErrorCtxf(rqs.Ctx, "", "header processing error", err)
+			f.WriteError(w, 400, err)
+			return
+		}
+		if len(chain) > 1 {
+			rqs.InputChain = chain[:len(chain)-1]
+		}
+	}
+
+	rqs, err = f.Process(rqs)
+	if err != nil {
+		f.writeInternalError(w, req, rqs, err)
+		return
+	}
+	rqs, err = f.Output(rqs)
+	if err != nil {
+		f.Reset(rqs)
+		f.writeInternalError(w, req, rqs, err)
+		return
+	}
+	rqs, err = f.Reset(rqs)
+	if err != nil {
+		f.writeInternalError(w, req, rqs, err)
+		return
+	}
+
+	err = f.gw.WriteResponse(w, req, rqs, content.Bytes())
+	if err != nil {
+		logg.ErrorCtxf(rqs.Ctx, "gateway response write fail", "err", err)
+	}
+}
+
+// endSession answers the notification that the session has ended, resetting the scratch data of the session with the session tracker.
+func (f *GatewaySessionHandler) endSession(w http.ResponseWriter, rqs handlers.RequestSession) {
+	logg.InfoCtxf(rqs.Ctx, "session ended", "sessionId", rqs.Config.SessionId, "gatewaySessionId", rqs.GatewaySessionId)
+	if f.tracker != nil {
+		err := f.tracker.End(rqs.Ctx, rqs.Config.SessionId, rqs.GatewaySessionId)
+		if err != nil {
+			logg.ErrorCtxf(rqs.Ctx, "session end failed", "sessionId", rqs.Config.SessionId, "err", err)
+			f.WriteError(w, 500, handlers.ErrStorage)
+			return
+		}
+	}
+	w.WriteHeader(200)
+}
+
+// writeInternalError ends the session with the message for internal errors, keeping the gateway response successful.
+//
+// The message is in the language chosen by the subscriber, if known.
+func (f *GatewaySessionHandler) writeInternalError(w http.ResponseWriter, req *http.Request, rqs handlers.RequestSession, err error) {
+	rqs.Continue = false
+	ctx := rqs.Ctx
+	code := f.sessionLanguage(rqs)
+	if code != "" {
+		rc, _ := common.GetRequestContext(ctx)
+		rc.Language = code
+		ctx = common.WithRequestContext(ctx, rc)
+	}
+	err = f.gw.WriteResponse(w, req, rqs, []byte(InternalError(ctx, err)))
+	if err != nil {
+		logg.ErrorCtxf(rqs.Ctx, "gateway response write fail", "err", err)
+	}
+}
+
+// sessionLanguage returns the code of the language chosen by the subscriber, or an empty string if it is not known.
+//
+// It is read from the state of the session if the request got that far, and from the userdata store otherwise.
+func (f *GatewaySessionHandler) sessionLanguage(rqs handlers.RequestSession) string {
+	store := f.userdataStore
+	if rqs.Storage != nil {
+		if rqs.Storage.Persister != nil {
+			st := rqs.Storage.Persister.GetState()
+			if st != nil && st.Language != nil {
+				return st.Language.Code
+			}
+		}
+		if rqs.Storage.UserdataDb != nil {
+			store = &common.UserDataStore{
+				Db: rqs.Storage.UserdataDb,
+			}
+		}
+	}
+	if store == nil {
+		return ""
+	}
+	code, err := store.ReadEntry(rqs.Ctx, rqs.Config.SessionId, common.DATA_SELECTED_LANGUAGE_CODE)
+	if err != nil {
+		if !db.IsNotFound(err) {
+			logg.WarnCtxf(rqs.Ctx, "cannot read language of session", "sessionId", rqs.Config.SessionId, "err", err)
+		}
+		return ""
+	}
+	return string(code)
+}
+
+// WritePlain writes the content as a plain text response.
+func WritePlain(w http.ResponseWriter, content []byte) error {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(200)
+	_, err := w.Write(content)
+	return err
+}
@@ -0,0 +1,183 @@
+package hubtel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"git.defalsify.org/vise.git/logging"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/handlers"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("hubtel")
+)
+
+var (
+	// ErrSessionReleased is returned for the notifications of Hubtel that the session has ended, which carry no input for the menu.
+	ErrSessionReleased = errors.New("session released")
+)
+
+const (
+	typeInitiation = "Initiation"
+	typeRelease    = "Release"
+	typeTimeout    = "Timeout"
+)
+
+// request is the body of a USSD request from Hubtel.
+type request struct {
+	Type        string `json:"Type"`
+	Mobile      string `json:"Mobile"`
+	SessionId   string `json:"SessionId"`
+	ServiceCode string `json:"ServiceCode"`
+	// Message is the dialed code at initiation, and the input of the subscriber after.
+	Message     string `json:"Message"`
+	Operator    string `json:"Operator"`
+	Sequence    int    `json:"Sequence"`
+	ClientState string `json:"ClientState"`
+}
+
+// response is the body of the response to Hubtel.
+type response struct {
+	SessionId   string `json:"SessionId"`
+	Type        string `json:"Type"`
+	Message     string `json:"Message"`
+	Label       string `json:"Label"`
+	ClientState string `json:"ClientState"`
+	DataType    string `json:"DataType"`
+	FieldType   string `json:"FieldType"`
+}
+
+// HubtelRequestParser is the gateway for the Hubtel programmable USSD service.
+type HubtelRequestParser struct {
+}
+
+// decode reads the request from the body, leaving the body in place for subsequent reads.
+func decode(rq any) (request, error) {
+	var hrq request
+	rqv, ok := rq.(*http.Request)
+	if !ok {
+		return hrq, handlers.ErrInvalidRequest
+	}
+	body, err := io.ReadAll(rqv.Body)
+	if err != nil {
+		return hrq, fmt.Errorf("failed to read request body: %v", err)
+	}
+	rqv.Body = io.NopCloser(bytes.NewReader(body))
+	err = json.Unmarshal(body, &hrq)
+	if err != nil {
+		return hrq, fmt.Errorf("failed to parse request body: %v", err)
+	}
+	return hrq, nil
+}
+
+func (hrp *HubtelRequestParser) GetSessionId(ctx context.Context, rq any) (string, error) {
+	hrq, err := decode(rq)
+	if err != nil {
+		logg.WarnCtxf(ctx, "got an invalid request", "err", err)
+		return "", err
+	}
+	if hrq.Mobile == "" {
+		return "", fmt.Errorf("no mobile number found")
+	}
+	return common.FormatMsisdn(hrq.Mobile)
+}
+
+// GetInput returns the input of the subscriber.
+//
+// At initiation, this is the last input of a shortcut dial string, if any.
+func (hrp *HubtelRequestParser) GetInput(rq any) ([]byte, error) {
+	hrq, err := decode(rq)
+	if err != nil {
+		return nil, err
+	}
+	switch hrq.Type {
+	case typeRelease, typeTimeout:
+		return nil, ErrSessionReleased
+	case typeInitiation:
+		chain := dialChain(hrq)
+		if len(chain) == 0 {
+			return []byte{}, nil
+		}
+		return []byte(chain[len(chain)-1]), nil
+	}
+	return []byte(strings.TrimSpace(hrq.Message)), nil
+}
+
+// IsSessionEnd returns true for the notifications of Hubtel that the session was released or timed out.
+func (hrp *HubtelRequestParser) IsSessionEnd(rq any) (bool, error) {
+	hrq, err := decode(rq)
+	if err != nil {
+		return false, err
+	}
+	return hrq.Type == typeRelease || hrq.Type == typeTimeout, nil
+}
+
+// GetInputChain returns the inputs of a shortcut dial string, like *713*1*2#, at initiation.
+func (hrp *HubtelRequestParser) GetInputChain(rq any) ([][]byte, error) {
+	hrq, err := decode(rq)
+	if err != nil {
+		return nil, err
+	}
+	if hrq.Type != typeInitiation {
+		return nil, nil
+	}
+	var chain [][]byte
+	for _, v := range dialChain(hrq) {
+		chain = append(chain, []byte(v))
+	}
+	return chain, nil
+}
+
+// GetRequestContext returns the details of the USSD session sent by Hubtel with the request.
+func (hrp *HubtelRequestParser) GetRequestContext(ctx context.Context, rq any) (common.RequestContext, error) {
+	var rc common.RequestContext
+	hrq, err := decode(rq)
+	if err != nil {
+		return rc, err
+	}
+	rc.GatewaySessionId = hrq.SessionId
+	rc.NetworkCode = hrq.Operator
+	rc.ServiceCode = hrq.ServiceCode
+	return rc, nil
+}
+
+// WriteResponse returns the menu content, asking for input if the session continues and releasing the session otherwise.
+func (hrp *HubtelRequestParser) WriteResponse(w http.ResponseWriter, req *http.Request, rqs handlers.RequestSession, content []byte) error {
+	hrq, err := decode(req)
+	if err != nil {
+		return err
+	}
+	hrs := response{
+		SessionId:   hrq.SessionId,
+		Type:        "release",
+		Message:     string(content),
+		ClientState: hrq.ClientState,
+		DataType:    "display",
+		FieldType:   "text",
+	}
+	if rqs.Continue {
+		hrs.Type = "response"
+		hrs.DataType = "input"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	return json.NewEncoder(w).Encode(hrs)
+}
+
+// dialChain returns the inputs following the service code in the dialed code.
+func dialChain(hrq request) []string {
+	sc := strings.Trim(hrq.ServiceCode, "*#")
+	s := strings.Trim(hrq.Message, "*#")
+	if !strings.HasPrefix(s, sc+"*") {
+		return nil
+	}
+	return strings.Split(s[len(sc)+1:], "*")
+}
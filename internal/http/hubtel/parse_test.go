@@ -0,0 +1,107 @@
+package hubtel
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	memdb "git.defalsify.org/vise.git/db/mem"
+	"git.defalsify.org/vise.git/engine"
+
+	"git.grassecon.net/urdt/ussd/internal/handlers"
+	httpserver "git.grassecon.net/urdt/ussd/internal/http"
+	"git.grassecon.net/urdt/ussd/internal/testutil/gatewaytest"
+	"git.grassecon.net/urdt/ussd/internal/testutil/mocks/httpmocks"
+)
+
+func TestFixtures(t *testing.T) {
+	gatewaytest.RunFixtures(t, &HubtelRequestParser{}, "testdata/*.json")
+}
+
+// TestSessionTracking serves requests through the session tracker, as the HTTP server does, and checks that shortcut dials are replayed at the start of a session only, and that released sessions are ended.
+func TestSessionTracking(t *testing.T) {
+	ctx := context.Background()
+	stateStore := memdb.NewMemDb()
+	err := stateStore.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	userdataStore := memdb.NewMemDb()
+	err = userdataStore.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []handlers.RequestSession
+	me := &httpmocks.MockEngine{
+		FlushFunc: func(ctx context.Context, w io.Writer) (int, error) {
+			return io.WriteString(w, "menu")
+		},
+	}
+	mh := &httpmocks.MockRequestHandler{
+		GetConfigFunc: func() engine.Config {
+			return engine.Config{}
+		},
+		ProcessFunc: func(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+			got = append(got, rqs)
+			rqs.Engine = me
+			rqs.Continue = true
+			return rqs, nil
+		},
+		OutputFunc: func(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+			_, err := rqs.Engine.Flush(rqs.Ctx, rqs.Writer)
+			return rqs, err
+		},
+		ResetFunc: func(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+			return rqs, nil
+		},
+	}
+	tracker := handlers.NewSessionTracker(stateStore, userdataStore)
+	rh := handlers.WithMiddleware(mh, handlers.LogRequest, handlers.RecordMetrics, tracker.Middleware())
+	h := httpserver.NewGatewaySessionHandler(rh, &HubtelRequestParser{}).WithSessionTracker(tracker)
+
+	serve := func(typ string, sessionId string, message string) {
+		b, err := json.Marshal(request{
+			Type:        typ,
+			Mobile:      "233200585542",
+			SessionId:   sessionId,
+			ServiceCode: "713",
+			Message:     message,
+			Operator:    "vodafone",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(b)))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+	}
+
+	serve(typeInitiation, "session_1", "*713*1*0244123456#")
+	serve("Response", "session_1", "2")
+	serve(typeInitiation, "session_2", "*713#")
+	// the release is not passed to the menu, and a session with the same id counts as new after it
+	serve(typeRelease, "session_2", "")
+	serve(typeInitiation, "session_2", "*713#")
+
+	if len(got) != 4 {
+		t.Fatalf("expected 4 requests, got %d", len(got))
+	}
+	if !got[0].NewSession || len(got[0].InputChain) != 1 || string(got[0].InputChain[0]) != "1" || string(got[0].Input) != "0244123456" {
+		t.Fatalf("expected shortcut to be replayed on a new session, got %v %s %s", got[0].NewSession, got[0].InputChain, got[0].Input)
+	}
+	if got[1].NewSession || string(got[1].Input) != "2" {
+		t.Fatalf("expected input within the session, got %v %s", got[1].NewSession, got[1].Input)
+	}
+	if !got[2].NewSession || !got[3].NewSession {
+		t.Fatalf("expected new sessions")
+	}
+}
@@ -0,0 +1,157 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/handlers"
+)
+
+// JsonRequest is the body of a request to the JSON API.
+type JsonRequest struct {
+	Session string `json:"session"`
+	Input   string `json:"input"`
+	// Language optionally sets the ISO 639 code of the language of the request.
+	Language string `json:"language,omitempty"`
+}
+
+// JsonMenuItem is a menu choice of the current node.
+type JsonMenuItem struct {
+	// Selector is the input that picks the choice.
+	Selector string `json:"selector"`
+	Label    string `json:"label"`
+}
+
+// JsonResponse is the body of a response of the JSON API.
+type JsonResponse struct {
+	// Content is the menu output without the menu choices.
+	Content  string         `json:"content"`
+	Menu     []JsonMenuItem `json:"menu"`
+	Continue bool           `json:"continue"`
+	Language string         `json:"language"`
+	// Node is the menu node the session is at.
+	Node string `json:"node"`
+}
+
+// JsonRequestParser serves the menu as structured data, for app and web clients that render their own interface.
+//
+// The menu choices are split from the rendered output, which lets the same menu tree drive both USSD and smartphone clients.
+type JsonRequestParser struct {
+}
+
+// decodeJson reads the request from the body, leaving the body in place for subsequent reads.
+func decodeJson(rq any) (JsonRequest, error) {
+	var jrq JsonRequest
+	rqv, ok := rq.(*http.Request)
+	if !ok {
+		return jrq, handlers.ErrInvalidRequest
+	}
+	body, err := io.ReadAll(rqv.Body)
+	if err != nil {
+		return jrq, fmt.Errorf("failed to read request body: %v", err)
+	}
+	rqv.Body = io.NopCloser(bytes.NewReader(body))
+	err = json.Unmarshal(body, &jrq)
+	if err != nil {
+		return jrq, fmt.Errorf("failed to parse request body: %v", err)
+	}
+	return jrq, nil
+}
+
+func (jrp *JsonRequestParser) GetSessionId(ctx context.Context, rq any) (string, error) {
+	jrq, err := decodeJson(rq)
+	if err != nil {
+		logg.WarnCtxf(ctx, "got an invalid request", "err", err)
+		return "", err
+	}
+	if jrq.Session == "" {
+		return "", handlers.ErrSessionMissing
+	}
+	return jrq.Session, nil
+}
+
+func (jrp *JsonRequestParser) GetInput(rq any) ([]byte, error) {
+	jrq, err := decodeJson(rq)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(jrq.Input), nil
+}
+
+// GetRequestContext picks up the request id from the X-Request-Id header, and the language from the request, if given.
+func (jrp *JsonRequestParser) GetRequestContext(ctx context.Context, rq any) (common.RequestContext, error) {
+	var rc common.RequestContext
+	jrq, err := decodeJson(rq)
+	if err != nil {
+		return rc, err
+	}
+	rc.RequestId = rq.(*http.Request).Header.Get("X-Request-Id")
+	rc.Language = jrq.Language
+	return rc, nil
+}
+
+// WriteResponse writes the menu output as a JsonResponse.
+func (jrp *JsonRequestParser) WriteResponse(w http.ResponseWriter, req *http.Request, rqs handlers.RequestSession, content []byte) error {
+	jrs := NewJsonResponse(rqs, content)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	return json.NewEncoder(w).Encode(jrs)
+}
+
+// NewJsonResponse creates the JsonResponse for the menu output of the request session.
+func NewJsonResponse(rqs handlers.RequestSession, content []byte) JsonResponse {
+	jrs := JsonResponse{
+		Continue: rqs.Continue,
+		Language: common.LanguageCodeFromContext(rqs.Ctx),
+	}
+	jrs.Content, jrs.Menu = splitMenu(string(content), rqs.Config.MenuSeparator)
+	if rqs.Storage != nil && rqs.Storage.Persister != nil {
+		st := rqs.Storage.Persister.GetState()
+		if st != nil {
+			jrs.Node, _ = st.Where()
+			if st.Language != nil {
+				jrs.Language = st.Language.Code
+			}
+		}
+	}
+	return jrs
+}
+
+// splitMenu separates the menu choices rendered at the end of the output from the content above them.
+//
+// Only lines with a numeric selector are taken as menu choices, so that content lines like "Balance: 10" are left alone.
+func splitMenu(s string, sep string) (string, []JsonMenuItem) {
+	menu := []JsonMenuItem{}
+	if sep == "" {
+		return s, menu
+	}
+	lines := strings.Split(s, "\n")
+	i := len(lines)
+	for i > 0 {
+		sel, label, ok := strings.Cut(lines[i-1], sep)
+		if !ok || !isSelector(sel) {
+			break
+		}
+		menu = append([]JsonMenuItem{{Selector: sel, Label: label}}, menu...)
+		i--
+	}
+	return strings.Join(lines[:i], "\n"), menu
+}
+
+func isSelector(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
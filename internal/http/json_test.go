@@ -0,0 +1,131 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/handlers"
+)
+
+func TestJsonRequestParser_Parse(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		expectedId    string
+		expectedInput string
+		expectedError bool
+	}{
+		{
+			name:          "Valid request",
+			body:          `{"session":"+254712345678","input":"1"}`,
+			expectedId:    "+254712345678",
+			expectedInput: "1",
+		},
+		{
+			name:          "Empty input",
+			body:          `{"session":"+254712345678"}`,
+			expectedId:    "+254712345678",
+			expectedInput: "",
+		},
+		{
+			name:          "Missing session",
+			body:          `{"input":"1"}`,
+			expectedError: true,
+		},
+		{
+			name:          "Invalid body",
+			body:          `session=1`,
+			expectedError: true,
+		},
+	}
+
+	rp := &JsonRequestParser{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			id, err := rp.GetSessionId(context.Background(), req)
+			if tt.expectedError {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != tt.expectedId {
+				t.Errorf("expected session id '%s', got '%s'", tt.expectedId, id)
+			}
+			input, err := rp.GetInput(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(input) != tt.expectedInput {
+				t.Errorf("expected input '%s', got '%s'", tt.expectedInput, input)
+			}
+		})
+	}
+}
+
+func TestJsonRequestParser_WriteResponse(t *testing.T) {
+	rp := &JsonRequestParser{}
+	rqs := handlers.RequestSession{
+		Ctx: common.WithRequestContext(context.Background(), common.RequestContext{
+			Language: "swa",
+		}),
+		Continue: true,
+	}
+	rqs.Config.MenuSeparator = ": "
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"session":"+254712345678"}`))
+	w := httptest.NewRecorder()
+	err := rp.WriteResponse(w, req, rqs, []byte("Balance: 10.00 SRF\n1: Send\n2: My Vouchers\n99: Quit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected json content type, got '%s'", w.Header().Get("Content-Type"))
+	}
+	var jrs JsonResponse
+	err = json.Unmarshal(w.Body.Bytes(), &jrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := JsonResponse{
+		Content: "Balance: 10.00 SRF",
+		Menu: []JsonMenuItem{
+			{Selector: "1", Label: "Send"},
+			{Selector: "2", Label: "My Vouchers"},
+			{Selector: "99", Label: "Quit"},
+		},
+		Continue: true,
+		Language: "swa",
+	}
+	if !reflect.DeepEqual(jrs, expected) {
+		t.Errorf("expected %+v, got %+v", expected, jrs)
+	}
+}
+
+func TestSplitMenu(t *testing.T) {
+	content, menu := splitMenu("Thank you for using Sarafu. Goodbye!", ": ")
+	if content != "Thank you for using Sarafu. Goodbye!" {
+		t.Errorf("unexpected content '%s'", content)
+	}
+	if len(menu) != 0 {
+		t.Errorf("expected no menu items, got %v", menu)
+	}
+
+	content, menu = splitMenu("1: English\n2: Kiswahili", ": ")
+	if content != "" {
+		t.Errorf("expected empty content, got '%s'", content)
+	}
+	if len(menu) != 2 || menu[1].Label != "Kiswahili" {
+		t.Errorf("unexpected menu %v", menu)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"net/http"
 
+	"git.grassecon.net/urdt/ussd/common"
 	"git.grassecon.net/urdt/ussd/internal/handlers"
 )
 
@@ -23,6 +24,17 @@ func (rp *DefaultRequestParser) GetSessionId(ctx context.Context, rq any) (strin
 	return v, nil
 }
 
+// GetRequestContext picks up the request id from the X-Request-Id header, if given.
+func (rp *DefaultRequestParser) GetRequestContext(ctx context.Context, rq any) (common.RequestContext, error) {
+	var rc common.RequestContext
+	rqv, ok := rq.(*http.Request)
+	if !ok {
+		return rc, handlers.ErrInvalidRequest
+	}
+	rc.RequestId = rqv.Header.Get("X-Request-Id")
+	return rc, nil
+}
+
 func (rp *DefaultRequestParser) GetInput(rq any) ([]byte, error) {
 	rqv, ok := rq.(*http.Request)
 	if !ok {
@@ -35,3 +47,8 @@ func (rp *DefaultRequestParser) GetInput(rq any) ([]byte, error) {
 	}
 	return v, nil
 }
+
+// WriteResponse writes the menu content as is.
+func (rp *DefaultRequestParser) WriteResponse(w http.ResponseWriter, req *http.Request, rqs handlers.RequestSession, content []byte) error {
+	return WritePlain(w, content)
+}
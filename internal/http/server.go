@@ -1,6 +1,7 @@
 package http
 
 import (
+	"net"
 	"net/http"
 	"strconv"
 
@@ -23,6 +24,15 @@ func ToSessionHandler(h handlers.RequestHandler) *SessionHandler {
 	}
 }
 
+// RemoteHost returns the host part of the remote address of the request.
+func RemoteHost(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
 func (f *SessionHandler) WriteError(w http.ResponseWriter, code int, err error) {
 	s := err.Error()
 	w.Header().Set("Content-Length", strconv.Itoa(len(s)))
@@ -42,6 +52,7 @@ func (f *SessionHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	rqs := handlers.RequestSession{
 		Ctx:    req.Context(),
 		Writer: w,
+		Source: RemoteHost(req),
 	}
 
 	rp := f.GetRequestParser()
@@ -52,6 +63,12 @@ func (f *SessionHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		f.WriteError(w, 400, err)
 	}
 	rqs.Config = cfg
+	rqs.Ctx, err = handlers.ToRequestContext(rqs.Ctx, rp, req, cfg.SessionId)
+	if err != nil {
+		logg.ErrorCtxf(rqs.Ctx, "", "header processing error", err)
+		f.WriteError(w, 400, err)
+		return
+	}
 	rqs.Input, err = rp.GetInput(req)
 	if err != nil {
 		logg.ErrorCtxf(rqs.Ctx, "", "header processing error", err)
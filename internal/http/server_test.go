@@ -6,13 +6,24 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
 	"testing"
 
+	memdb "git.defalsify.org/vise.git/db/mem"
 	"git.defalsify.org/vise.git/engine"
+	"git.grassecon.net/urdt/ussd/common"
 	"git.grassecon.net/urdt/ussd/internal/handlers"
 	"git.grassecon.net/urdt/ussd/internal/testutil/mocks/httpmocks"
+	testdataloader "github.com/peteole/testdata-loader"
 )
 
+func TestMain(m *testing.M) {
+	translationDir = path.Join(testdataloader.GetBasePath(), "services", "registration", "locale")
+	os.Exit(m.Run())
+}
+
 // invalidRequestType is a custom type to test invalid request scenarios
 type invalidRequestType struct{}
 
@@ -228,3 +239,95 @@ func TestDefaultRequestParser_GetInput(t *testing.T) {
 		})
 	}
 }
+
+func TestInternalError(t *testing.T) {
+	ctx := common.WithRequestContext(context.Background(), common.RequestContext{
+		Language: "eng",
+	})
+	s := InternalError(ctx, errors.New("storage exploded"))
+	prefix := "Service temporarily unavailable. Please try again later. Reference: "
+	if !strings.HasPrefix(s, prefix) {
+		t.Fatalf("expected message to start with %q, got %q", prefix, s)
+	}
+	if len(s) != len(prefix)+8 {
+		t.Fatalf("expected 8 character reference, got %q", s[len(prefix):])
+	}
+	if strings.Contains(s, "storage") {
+		t.Fatalf("expected error to be hidden, got %q", s)
+	}
+}
+
+func TestGatewaySessionHandler_InternalError(t *testing.T) {
+	mh := &httpmocks.MockRequestHandler{
+		GetConfigFunc: func() engine.Config {
+			return engine.Config{}
+		},
+		ProcessFunc: func(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+			return rqs, handlers.ErrStorage
+		},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"session":"+254712345678","input":"1"}`))
+	w := httptest.NewRecorder()
+	NewGatewaySessionHandler(mh, &JsonRequestParser{}).ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"continue":false`) || !strings.Contains(w.Body.String(), "Reference: ") {
+		t.Fatalf("expected session to end with error message, got %s", w.Body.String())
+	}
+}
+
+func TestGatewaySessionHandler_InternalErrorLanguage(t *testing.T) {
+	ctx := context.Background()
+	store := memdb.NewMemDb()
+	err := store.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	userdataStore := &common.UserDataStore{Db: store}
+	err = userdataStore.WriteEntry(ctx, "+254712345678", common.DATA_SELECTED_LANGUAGE_CODE, []byte("swa"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mh := &httpmocks.MockRequestHandler{
+		GetConfigFunc: func() engine.Config {
+			return engine.Config{}
+		},
+		ProcessFunc: func(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+			return rqs, handlers.ErrStorage
+		},
+	}
+	// the request context holds the default language of the server
+	rctx := common.WithRequestContext(ctx, common.RequestContext{
+		Language: "eng",
+	})
+	tests := []struct {
+		name     string
+		session  string
+		expected string
+	}{
+		{
+			name:     "Language chosen by the subscriber",
+			session:  "+254712345678",
+			expected: "Huduma haipatikani kwa sasa. Tafadhali jaribu tena baadaye. Kumbukumbu: ",
+		},
+		{
+			name:     "No language chosen",
+			session:  "+254711111111",
+			expected: "Service temporarily unavailable. Please try again later. Reference: ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"session":"` + tt.session + `","input":"1"}`
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)).WithContext(rctx)
+			w := httptest.NewRecorder()
+			NewGatewaySessionHandler(mh, &JsonRequestParser{}).WithUserdataStore(store).ServeHTTP(w, req)
+			if !strings.Contains(w.Body.String(), tt.expected) {
+				t.Fatalf("expected message %q, got %s", tt.expected, w.Body.String())
+			}
+		})
+	}
+}
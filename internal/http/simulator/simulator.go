@@ -0,0 +1,152 @@
+package simulator
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+
+	"git.defalsify.org/vise.git/asm"
+	"git.defalsify.org/vise.git/engine"
+	"git.defalsify.org/vise.git/logging"
+	"git.defalsify.org/vise.git/state"
+
+	"git.grassecon.net/urdt/ussd/internal/handlers"
+	httpserver "git.grassecon.net/urdt/ussd/internal/http"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("simulator")
+)
+
+//go:embed static
+var static embed.FS
+
+// Debug is the engine state after the request, shown in the debug pane of the simulator.
+type Debug struct {
+	// Flags are the names of the user flags that are set.
+	Flags []string `json:"flags"`
+	// OutputSize is the length of the menu output.
+	OutputSize int `json:"output_size"`
+	// OutputLimit is the maximum length of the menu output, 0 if unlimited.
+	OutputLimit uint32 `json:"output_limit"`
+}
+
+// Response is the body of a response of the simulator endpoint.
+type Response struct {
+	httpserver.JsonResponse
+	// Debug is only included when engine debug output is enabled.
+	Debug *Debug `json:"debug,omitempty"`
+}
+
+// Simulator serves a browser-based phone for walking the menus, for testers and field staff.
+//
+// The page is served at the root of the handler, and the menu is driven through the JSON endpoint at "api".
+type Simulator struct {
+	h      handlers.RequestHandler
+	parser *asm.FlagParser
+	mux    *http.ServeMux
+}
+
+// NewSimulator creates a new Simulator for the request handler.
+//
+// The flag parser is used to name the flags in the debug pane, and may be nil.
+func NewSimulator(h handlers.RequestHandler, parser *asm.FlagParser) *Simulator {
+	sim := &Simulator{
+		h:      h,
+		parser: parser,
+		mux:    http.NewServeMux(),
+	}
+	sub, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+	sim.mux.Handle("/api", http.HandlerFunc(sim.serveApi))
+	sim.mux.Handle("/", http.FileServer(http.FS(sub)))
+	return sim
+}
+
+func (sim *Simulator) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	sim.mux.ServeHTTP(w, req)
+}
+
+func (sim *Simulator) serveApi(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	gw := &gateway{
+		sim: sim,
+	}
+	h := &configHandler{
+		RequestHandler: sim.h,
+		cfg:            sim.h.GetConfig(),
+	}
+	rc, err := gw.GetRequestContext(req.Context(), req)
+	if err == nil && rc.Language != "" {
+		h.cfg.Language = rc.Language
+	}
+	httpserver.NewGatewaySessionHandler(h, gw).ServeHTTP(w, req)
+}
+
+// debug returns the engine state of the request session.
+func (sim *Simulator) debug(rqs handlers.RequestSession, content []byte) *Debug {
+	d := &Debug{
+		Flags:       []string{},
+		OutputSize:  len(content),
+		OutputLimit: rqs.Config.OutputSize,
+	}
+	if rqs.Storage == nil || rqs.Storage.Persister == nil {
+		return d
+	}
+	st := rqs.Storage.Persister.GetState()
+	if st == nil {
+		return d
+	}
+	for i := uint32(state.FLAG_USERSTART); i < st.FlagBitSize(); i++ {
+		if !st.GetFlag(i) {
+			continue
+		}
+		d.Flags = append(d.Flags, sim.flagName(i))
+	}
+	return d
+}
+
+func (sim *Simulator) flagName(idx uint32) string {
+	if sim.parser != nil {
+		s, err := sim.parser.GetAsString(idx)
+		if err == nil {
+			return s
+		}
+	}
+	return fmt.Sprintf("%d", idx)
+}
+
+// gateway is the JSON API gateway, with the engine state added to the response when engine debug output is enabled.
+type gateway struct {
+	httpserver.JsonRequestParser
+	sim *Simulator
+}
+
+func (gw *gateway) WriteResponse(w http.ResponseWriter, req *http.Request, rqs handlers.RequestSession, content []byte) error {
+	rs := Response{
+		JsonResponse: httpserver.NewJsonResponse(rqs, content),
+	}
+	if rqs.Config.EngineDebug {
+		rs.Debug = gw.sim.debug(rqs, content)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	return json.NewEncoder(w).Encode(rs)
+}
+
+// configHandler is the request handler with the engine configuration of the request.
+type configHandler struct {
+	handlers.RequestHandler
+	cfg engine.Config
+}
+
+func (h *configHandler) GetConfig() engine.Config {
+	return h.cfg
+}
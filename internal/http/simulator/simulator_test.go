@@ -0,0 +1,124 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"git.defalsify.org/vise.git/engine"
+
+	"git.grassecon.net/urdt/ussd/internal/handlers"
+	"git.grassecon.net/urdt/ussd/internal/testutil/mocks/httpmocks"
+)
+
+func testHandler(debug bool, got *handlers.RequestSession) *httpmocks.MockRequestHandler {
+	me := &httpmocks.MockEngine{
+		FlushFunc: func(ctx context.Context, w io.Writer) (int, error) {
+			return io.WriteString(w, "Balance: 10.00 SRF\n1: Send\n2: My Vouchers")
+		},
+	}
+	return &httpmocks.MockRequestHandler{
+		GetConfigFunc: func() engine.Config {
+			return engine.Config{
+				OutputSize:    160,
+				MenuSeparator: ": ",
+				EngineDebug:   debug,
+			}
+		},
+		ProcessFunc: func(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+			*got = rqs
+			rqs.Engine = me
+			rqs.Continue = true
+			return rqs, nil
+		},
+		OutputFunc: func(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+			_, err := rqs.Engine.Flush(rqs.Ctx, rqs.Writer)
+			return rqs, err
+		},
+		ResetFunc: func(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+			return rqs, nil
+		},
+	}
+}
+
+func TestSimulatorPage(t *testing.T) {
+	var got handlers.RequestSession
+	sim := NewSimulator(testHandler(false, &got), nil)
+
+	w := httptest.NewRecorder()
+	sim.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != 200 {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "USSD simulator") {
+		t.Errorf("expected simulator page, got %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	sim.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
+
+func TestSimulatorApi(t *testing.T) {
+	tests := []struct {
+		name  string
+		debug bool
+	}{
+		{
+			name: "Without debug",
+		},
+		{
+			name:  "With debug",
+			debug: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got handlers.RequestSession
+			sim := NewSimulator(testHandler(tt.debug, &got), nil)
+
+			body := `{"session":"+254712345678","input":"1","language":"swa"}`
+			w := httptest.NewRecorder()
+			sim.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(body)))
+			if w.Code != 200 {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+			if got.Config.SessionId != "+254712345678" {
+				t.Errorf("expected session id '+254712345678', got '%s'", got.Config.SessionId)
+			}
+			if got.Config.Language != "swa" {
+				t.Errorf("expected language 'swa', got '%s'", got.Config.Language)
+			}
+			if string(got.Input) != "1" {
+				t.Errorf("expected input '1', got '%s'", got.Input)
+			}
+
+			var rs Response
+			err := json.Unmarshal(w.Body.Bytes(), &rs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rs.Content != "Balance: 10.00 SRF" || len(rs.Menu) != 2 || !rs.Continue {
+				t.Errorf("unexpected response %+v", rs.JsonResponse)
+			}
+			if !tt.debug {
+				if rs.Debug != nil {
+					t.Errorf("expected no debug output, got %+v", rs.Debug)
+				}
+				return
+			}
+			if rs.Debug == nil {
+				t.Fatal("expected debug output")
+			}
+			if rs.Debug.OutputSize != 41 || rs.Debug.OutputLimit != 160 {
+				t.Errorf("unexpected output size %d/%d", rs.Debug.OutputSize, rs.Debug.OutputLimit)
+			}
+		})
+	}
+}
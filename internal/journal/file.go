@@ -0,0 +1,72 @@
+package journal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// FileSink appends journal entries to a file, one JSON object per line.
+type FileSink struct {
+	path string
+	f    *os.File
+	mu   sync.Mutex
+}
+
+// NewFileSink opens the file at the given path for appending, creating it if it does not exist.
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{
+		path: path,
+		f:    f,
+	}, nil
+}
+
+// Write implements Sink.
+func (fs *FileSink) Write(ctx context.Context, entry Entry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	b = append(b, 0x0a)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	_, err = fs.f.Write(b)
+	return err
+}
+
+// Read implements Sink.
+func (fs *FileSink) Read(ctx context.Context, sessionId string) ([]Entry, error) {
+	var entries []Entry
+
+	f, err := os.Open(fs.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry Entry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			logg.WarnCtxf(ctx, "skipping invalid journal line", "err", err)
+			continue
+		}
+		if entry.SessionId == sessionId {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// Close implements Sink.
+func (fs *FileSink) Close() error {
+	return fs.f.Close()
+}
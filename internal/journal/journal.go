@@ -0,0 +1,227 @@
+package journal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+	"git.defalsify.org/vise.git/logging"
+	"git.defalsify.org/vise.git/persist"
+	"git.defalsify.org/vise.git/state"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/config"
+	"git.grassecon.net/urdt/ussd/internal/handlers"
+	"git.grassecon.net/urdt/ussd/internal/storage"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("journal")
+)
+
+const (
+	// Replaces the input in journal entries when it is a PIN.
+	maskedInput = "****"
+)
+
+var (
+	// PinSymbols are the menu nodes that receive a PIN as input.
+	PinSymbols = map[string]bool{
+		"check_statement":        true,
+		"confirm_create_pin":     true,
+		"confirm_others_new_pin": true,
+		"confirm_pin_change":     true,
+		"create_pin":             true,
+		"enter_others_new_pin":   true,
+		"enter_pin":              true,
+		"new_pin":                true,
+		"old_pin":                true,
+		"pin_entry":              true,
+		"transaction_pin":        true,
+		"view_voucher":           true,
+	}
+)
+
+// Entry is the journal record of a single engine step.
+type Entry struct {
+	Time             time.Time `json:"time"`
+	SessionId        string    `json:"session_id"`
+	GatewaySessionId string    `json:"gateway_session_id,omitempty"`
+	// From is the node that received the input.
+	From string `json:"from"`
+	// Symbol is the node the subscriber was left at after the step.
+	Symbol string `json:"symbol"`
+	Input  string `json:"input"`
+	// Replayed holds the inputs replayed before Input from a shortcut dial string.
+	Replayed  []string `json:"replayed,omitempty"`
+	FlagSet   []uint32 `json:"flag_set,omitempty"`
+	FlagReset []uint32 `json:"flag_reset,omitempty"`
+	Continue  bool     `json:"continue"`
+	Halted    bool     `json:"halted,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// Sink stores journal entries.
+type Sink interface {
+	// Write adds an entry to the journal.
+	Write(ctx context.Context, entry Entry) error
+	// Read returns all entries for the session, in the order they were written.
+	Read(ctx context.Context, sessionId string) ([]Entry, error)
+	Close() error
+}
+
+// NewSink creates the sink matching the connection data.
+//
+// A postgres connection stores the journal in a table in the schema of the connection. An absolute path is used as a file to append the entries to.
+func NewSink(ctx context.Context, conn storage.ConnData) (Sink, error) {
+	switch conn.DbType() {
+	case storage.DBTYPE_POSTGRES:
+		return NewPgSink(ctx, conn)
+	case storage.DBTYPE_GDBM:
+		return NewFileSink(conn.String())
+	}
+	return nil, fmt.Errorf("unsupported journal connection string: '%s'", conn.String())
+}
+
+// SinkFromConfig creates the sink for the journal connection in the config settings.
+//
+// It returns nil if no journal is configured.
+func SinkFromConfig(ctx context.Context) (Sink, error) {
+	if config.JournalConn == "" {
+		return nil, nil
+	}
+	conn, err := storage.ToConnData(config.JournalConn)
+	if err != nil {
+		return nil, err
+	}
+	return NewSink(ctx, conn)
+}
+
+// Journal records each request processed by the engine to a sink.
+type Journal struct {
+	stateStore db.Db
+	resolver   handlers.StateResolver
+	sink       Sink
+}
+
+// NewJournal creates a new Journal, using the state store to look up the node the subscriber is at before each step.
+//
+// If sink is nil, the journal is disabled.
+func NewJournal(stateStore db.Db, sink Sink) *Journal {
+	return &Journal{
+		stateStore: stateStore,
+		sink:       sink,
+	}
+}
+
+// WithStateResolver makes the journal look up the node of requests in the state store the resolver gives for them, like that of the route a request is passed on to.
+func (j *Journal) WithStateResolver(sr handlers.StateResolver) *Journal {
+	j.resolver = sr
+	return j
+}
+
+// Middleware returns a request handler middleware writing a journal entry for every processed request.
+//
+// Failure to write the entry is logged, but does not affect the request.
+func (j *Journal) Middleware() handlers.Middleware {
+	if j.sink == nil {
+		return func(h handlers.RequestHandler) handlers.RequestHandler {
+			return h
+		}
+	}
+	return handlers.ProcessMiddleware(j.process)
+}
+
+func (j *Journal) process(rqs handlers.RequestSession, next handlers.ProcessFunc) (handlers.RequestSession, error) {
+	var flags []byte
+
+	sessionId := rqs.Config.SessionId
+	entry := Entry{
+		Time:             time.Now(),
+		SessionId:        sessionId,
+		GatewaySessionId: rqs.GatewaySessionId,
+	}
+
+	pe := persist.NewPersister(handlers.ResolveStateStore(rqs.Ctx, j.resolver, j.stateStore))
+	err := pe.Load(sessionId)
+	if err != nil {
+		if !db.IsNotFound(err) {
+			logg.WarnCtxf(rqs.Ctx, "cannot load state for journal", "sessionId", sessionId, "err", err)
+		}
+	} else if st := pe.GetState(); st != nil {
+		entry.From, _ = st.Where()
+		flags = append(flags, st.Flags...)
+	}
+
+	// replayed inputs are taken by the nodes reached during the replay, not by the node the last session ended at
+	if rqs.NewSession && len(rqs.InputChain) > 0 {
+		for _, v := range rqs.InputChain {
+			entry.Replayed = append(entry.Replayed, maskInput("", v))
+		}
+		entry.Input = maskInput("", rqs.Input)
+	} else {
+		entry.Input = maskInput(entry.From, rqs.Input)
+	}
+
+	rqs, err = next(rqs)
+
+	entry.Continue = rqs.Continue
+	entry.Halted = handlers.IsHalted(rqs)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if rqs.Storage != nil && rqs.Storage.Persister != nil {
+		st := rqs.Storage.Persister.GetState()
+		if st != nil {
+			entry.Symbol, _ = st.Where()
+			entry.FlagSet, entry.FlagReset = diffFlags(flags, st)
+		}
+	}
+
+	werr := j.sink.Write(rqs.Ctx, entry)
+	if werr != nil {
+		logg.ErrorCtxf(rqs.Ctx, "journal write failed", "sessionId", sessionId, "err", werr)
+	}
+	return rqs, err
+}
+
+// maskInput hides the input if it is entered at a PIN node.
+//
+// If the node is not known, any input that could be a PIN is hidden.
+func maskInput(sym string, input []byte) string {
+	if PinSymbols[sym] {
+		return maskedInput
+	}
+	if sym == "" && common.IsValidPIN(string(input)) {
+		return maskedInput
+	}
+	return string(input)
+}
+
+// diffFlags returns the user flags that were set and reset in the state, compared to the flags before the step.
+func diffFlags(before []byte, st *state.State) ([]uint32, []uint32) {
+	var set []uint32
+	var reset []uint32
+
+	for i, b := range st.Flags {
+		var p byte
+		if i < len(before) {
+			p = before[i]
+		}
+		for j := 0; j < 8; j++ {
+			idx := uint32(i*8 + j)
+			if idx < state.FLAG_USERSTART {
+				continue
+			}
+			was := p&(1<<j) > 0
+			is := b&(1<<j) > 0
+			if is && !was {
+				set = append(set, idx)
+			} else if was && !is {
+				reset = append(reset, idx)
+			}
+		}
+	}
+	return set, reset
+}
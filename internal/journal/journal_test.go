@@ -0,0 +1,192 @@
+package journal
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"git.defalsify.org/vise.git/cache"
+	memdb "git.defalsify.org/vise.git/db/mem"
+	"git.defalsify.org/vise.git/persist"
+	"git.defalsify.org/vise.git/state"
+
+	"git.grassecon.net/urdt/ussd/internal/handlers"
+)
+
+// testSink keeps the written entries in memory.
+type testSink struct {
+	entries []Entry
+}
+
+func (ts *testSink) Write(ctx context.Context, entry Entry) error {
+	ts.entries = append(ts.entries, entry)
+	return nil
+}
+
+func (ts *testSink) Read(ctx context.Context, sessionId string) ([]Entry, error) {
+	return ts.entries, nil
+}
+
+func (ts *testSink) Close() error {
+	return nil
+}
+
+func TestMaskInput(t *testing.T) {
+	if v := maskInput("transaction_pin", []byte("1234")); v != maskedInput {
+		t.Fatalf("expected PIN to be masked, got '%s'", v)
+	}
+	if v := maskInput("amount", []byte("1234")); v != "1234" {
+		t.Fatalf("expected amount to be kept, got '%s'", v)
+	}
+	if v := maskInput("", []byte("1234")); v != maskedInput {
+		t.Fatalf("expected PIN-like input at unknown node to be masked, got '%s'", v)
+	}
+	if v := maskInput("", []byte("1")); v != "1" {
+		t.Fatalf("expected menu choice to be kept, got '%s'", v)
+	}
+}
+
+func TestProcessReplayMasksPin(t *testing.T) {
+	ctx := context.Background()
+	sessionId := "+254712345678"
+	store := memdb.NewMemDb()
+	err := store.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// the last session ended at a node that does not take a PIN
+	st := state.NewState(128)
+	st.Down("main")
+	pe := persist.NewPersister(store).WithSession(sessionId).WithContent(st, cache.NewCache())
+	err = pe.Save(sessionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &testSink{}
+	j := NewJournal(store, sink)
+	next := func(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+		return rqs, nil
+	}
+
+	// a shortcut dial ending with the PIN for the send
+	rqs := handlers.RequestSession{
+		Ctx:        ctx,
+		Input:      []byte("1234"),
+		NewSession: true,
+		InputChain: [][]byte{[]byte("1"), []byte("0712345678"), []byte("5")},
+	}
+	rqs.Config.SessionId = sessionId
+	_, err = j.process(rqs, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same input within the session, at the node it was taken by
+	rqs.NewSession = false
+	rqs.InputChain = nil
+	_, err = j.process(rqs, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sink.entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(sink.entries))
+	}
+	entry := sink.entries[0]
+	if entry.Input != maskedInput {
+		t.Fatalf("expected replayed PIN to be masked, got '%s'", entry.Input)
+	}
+	if len(entry.Replayed) != 3 || entry.Replayed[1] != "0712345678" {
+		t.Fatalf("unexpected replayed inputs %v", entry.Replayed)
+	}
+	entry = sink.entries[1]
+	if entry.From != "main" {
+		t.Fatalf("expected input to be taken at main, got '%s'", entry.From)
+	}
+	if entry.Input != "1234" {
+		t.Fatalf("expected input at main to be kept, got '%s'", entry.Input)
+	}
+}
+
+func TestDiffFlags(t *testing.T) {
+	st := &state.State{
+		Flags: []byte{0x00, 0x05, 0x80},
+	}
+	set, reset := diffFlags([]byte{0x01, 0x06}, st)
+	if len(set) != 2 || set[0] != 8 || set[1] != 23 {
+		t.Fatalf("unexpected flags set: %v", set)
+	}
+	if len(reset) != 1 || reset[0] != 9 {
+		t.Fatalf("unexpected flags reset: %v", reset)
+	}
+}
+
+func TestFileSinkTranscript(t *testing.T) {
+	ctx := context.Background()
+	fp := path.Join(t.TempDir(), "journal.jsonl")
+	sink, err := NewFileSink(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	tm := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	entries := []Entry{
+		{
+			Time:             tm,
+			SessionId:        "+254712345678",
+			GatewaySessionId: "ATUid_1",
+			From:             "",
+			Symbol:           "main",
+			Continue:         true,
+			FlagSet:          []uint32{24},
+		},
+		{
+			Time:             tm,
+			SessionId:        "+254700000000",
+			GatewaySessionId: "ATUid_2",
+			Symbol:           "main",
+			Continue:         true,
+		},
+		{
+			Time:             tm.Add(time.Second),
+			SessionId:        "+254712345678",
+			GatewaySessionId: "ATUid_1",
+			From:             "transaction_pin",
+			Symbol:           "transaction_pin",
+			Input:            maskedInput,
+			Error:            "engine exec fail",
+		},
+	}
+	for _, entry := range entries {
+		err = sink.Write(ctx, entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := sink.Read(ctx, "+254712345678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(r))
+	}
+
+	w := bytes.NewBuffer(nil)
+	err = WriteTranscript(w, r, map[uint32]string{24: "flag_account_authorized"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := w.String()
+	for _, v := range []string{"gateway session 'ATUid_1'", "-> [main]", "set: flag_account_authorized", "[transaction_pin] > \"****\"", "error: engine exec fail"} {
+		if !strings.Contains(s, v) {
+			t.Fatalf("expected '%s' in transcript:\n%s", v, s)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package journal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"git.grassecon.net/urdt/ussd/internal/storage"
+)
+
+// PgSink stores journal entries in the journal table of a postgres schema.
+type PgSink struct {
+	pool   *pgxpool.Pool
+	schema string
+}
+
+// NewPgSink connects to the database, and creates the journal table if it does not exist.
+func NewPgSink(ctx context.Context, conn storage.ConnData) (*PgSink, error) {
+	pool, err := pgxpool.New(ctx, conn.Path())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the database: %w", err)
+	}
+	ps := &PgSink{
+		pool:   pool,
+		schema: conn.Domain(),
+	}
+	queries := []string{
+		fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", ps.schema),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.journal (
+	id BIGSERIAL PRIMARY KEY,
+	session_id VARCHAR NOT NULL,
+	gateway_session_id VARCHAR NOT NULL DEFAULT '',
+	created TIMESTAMPTZ NOT NULL,
+	entry JSONB NOT NULL
+)`, ps.schema),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS journal_session_id_idx ON %s.journal (session_id)", ps.schema),
+	}
+	for _, query := range queries {
+		_, err = pool.Exec(ctx, query)
+		if err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("failed to create journal table: %w", err)
+		}
+	}
+	return ps, nil
+}
+
+// Write implements Sink.
+func (ps *PgSink) Write(ctx context.Context, entry Entry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("INSERT INTO %s.journal (session_id, gateway_session_id, created, entry) VALUES ($1, $2, $3, $4)", ps.schema)
+	_, err = ps.pool.Exec(ctx, query, entry.SessionId, entry.GatewaySessionId, entry.Time, b)
+	return err
+}
+
+// Read implements Sink.
+func (ps *PgSink) Read(ctx context.Context, sessionId string) ([]Entry, error) {
+	var entries []Entry
+
+	query := fmt.Sprintf("SELECT entry FROM %s.journal WHERE session_id = $1 ORDER BY id", ps.schema)
+	rows, err := ps.pool.Query(ctx, query, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b []byte
+		var entry Entry
+		err = rows.Scan(&b)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(b, &entry)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Close implements Sink.
+func (ps *PgSink) Close() error {
+	ps.pool.Close()
+	return nil
+}
@@ -0,0 +1,101 @@
+package journal
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoadFlagNames reads the names of the flags from a flag definition file, like pp.csv.
+func LoadFlagNames(fp string) (map[uint32]string, error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[uint32]string)
+	for _, rec := range records {
+		if len(rec) < 3 || rec[0] != "flag" {
+			continue
+		}
+		idx, err := strconv.ParseUint(rec[2], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid flag index for %s: %v", rec[1], err)
+		}
+		names[uint32(idx)] = rec[1]
+	}
+	return names, nil
+}
+
+// WriteTranscript renders the journal entries as a human readable transcript of the session.
+//
+// Flags are shown by name if found in flagNames. If gatewaySessionId is not empty, only entries of that gateway session are included.
+func WriteTranscript(w io.Writer, entries []Entry, flagNames map[uint32]string, gatewaySessionId string) error {
+	var current string
+	var first bool = true
+
+	for _, entry := range entries {
+		if gatewaySessionId != "" && entry.GatewaySessionId != gatewaySessionId {
+			continue
+		}
+		if first || entry.GatewaySessionId != current {
+			current = entry.GatewaySessionId
+			first = false
+			_, err := fmt.Fprintf(w, "=== session %s gateway session '%s' started %s\n", entry.SessionId, current, entry.Time.Format(time.RFC3339))
+			if err != nil {
+				return err
+			}
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s  [%s]", entry.Time.Format("15:04:05"), entry.From)
+		if len(entry.Replayed) > 0 {
+			fmt.Fprintf(&b, " > %s", strings.Join(entry.Replayed, " > "))
+		}
+		fmt.Fprintf(&b, " > %q -> [%s]", entry.Input, entry.Symbol)
+		if entry.Halted {
+			b.WriteString(" HALTED")
+		} else if !entry.Continue {
+			b.WriteString(" END")
+		}
+		b.WriteString("\n")
+		if len(entry.FlagSet) > 0 {
+			fmt.Fprintf(&b, "\tset: %s\n", formatFlags(entry.FlagSet, flagNames))
+		}
+		if len(entry.FlagReset) > 0 {
+			fmt.Fprintf(&b, "\treset: %s\n", formatFlags(entry.FlagReset, flagNames))
+		}
+		if entry.Error != "" {
+			fmt.Fprintf(&b, "\terror: %s\n", entry.Error)
+		}
+		_, err := io.WriteString(w, b.String())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatFlags(flags []uint32, flagNames map[uint32]string) string {
+	var s []string
+	for _, v := range flags {
+		name, ok := flagNames[v]
+		if !ok {
+			name = strconv.FormatUint(uint64(v), 10)
+		}
+		s = append(s, name)
+	}
+	return strings.Join(s, ", ")
+}
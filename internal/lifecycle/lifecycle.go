@@ -0,0 +1,146 @@
+package lifecycle
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"git.defalsify.org/vise.git/logging"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("lifecycle")
+)
+
+type closer struct {
+	name string
+	fn   func() error
+}
+
+// Manager coordinates the shutdown of a frontend.
+//
+// On shutdown, the frontend first stops accepting work. The request cycles in flight are then given until the deadline to finish, so that the state of their sessions is persisted. Last, the resources of the frontend are closed, in the reverse order they were registered in.
+type Manager struct {
+	timeout  time.Duration
+	stops    []func(context.Context) error
+	closers  []closer
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	stopping bool
+	once     sync.Once
+	done     chan struct{}
+}
+
+// NewManager creates a new Manager, allowing the request cycles in flight the given time to finish on shutdown.
+func NewManager(timeout time.Duration) *Manager {
+	return &Manager{
+		timeout: timeout,
+		done:    make(chan struct{}),
+	}
+}
+
+// OnStop registers a function that makes the frontend stop accepting work, e.g. closing a listener.
+//
+// The functions are called in the order they were registered, and are passed the shutdown deadline.
+func (m *Manager) OnStop(fn func(context.Context) error) {
+	m.stops = append(m.stops, fn)
+}
+
+// OnClose registers a resource to close once the request cycles in flight have finished.
+//
+// Resources are closed in the reverse order they were registered in, like deferred calls. Thus stores should be registered before the components using them.
+func (m *Manager) OnClose(name string, fn func() error) {
+	m.closers = append(m.closers, closer{
+		name: name,
+		fn:   fn,
+	})
+}
+
+// Begin marks the start of a request cycle, spanning the Process, Output and Reset steps of the request handler.
+//
+// It returns false if the manager is shutting down, in which case the request must not be processed. Otherwise End must be called when the cycle is done.
+func (m *Manager) Begin() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopping {
+		return false
+	}
+	m.wg.Add(1)
+	return true
+}
+
+// End marks the end of a request cycle started with Begin.
+func (m *Manager) End() {
+	m.wg.Done()
+}
+
+// Shutdown stops the frontend, waits for the request cycles in flight and closes the registered resources.
+//
+// Only the first call has any effect. Later calls wait for the first to complete.
+func (m *Manager) Shutdown() {
+	m.once.Do(m.shutdown)
+	<-m.done
+}
+
+func (m *Manager) shutdown() {
+	defer close(m.done)
+
+	m.mu.Lock()
+	m.stopping = true
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	logg.InfoCtxf(ctx, "shutdown started", "timeout", m.timeout)
+	for _, fn := range m.stops {
+		err := fn(ctx)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "stop error", "err", err)
+		}
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		logg.DebugCtxf(ctx, "requests in flight drained")
+	case <-ctx.Done():
+		logg.WarnCtxf(ctx, "shutdown deadline exceeded with requests in flight")
+	}
+
+	for i := len(m.closers) - 1; i >= 0; i-- {
+		c := m.closers[i]
+		logg.TraceCtxf(ctx, "closing", "resource", c.name)
+		err := c.fn()
+		if err != nil {
+			logg.ErrorCtxf(ctx, "close error", "resource", c.name, "err", err)
+		}
+	}
+	logg.InfoCtxf(ctx, "shutdown complete")
+}
+
+// Notify starts the shutdown when the process receives SIGINT or SIGTERM.
+func (m *Manager) Notify() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-c:
+			m.Shutdown()
+		case <-m.done:
+		}
+		signal.Stop(c)
+	}()
+}
+
+// Wait blocks until the shutdown has completed.
+func (m *Manager) Wait() {
+	<-m.done
+}
@@ -0,0 +1,79 @@
+package lifecycle
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShutdownOrder(t *testing.T) {
+	var events []string
+	var mu sync.Mutex
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+
+	m := NewManager(time.Second)
+	m.OnStop(func(ctx context.Context) error {
+		record("stop")
+		return nil
+	})
+	m.OnClose("store", func() error {
+		record("store")
+		return nil
+	})
+	m.OnClose("journal", func() error {
+		record("journal")
+		return nil
+	})
+
+	if !m.Begin() {
+		t.Fatalf("expected request cycle to begin")
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		record("request")
+		m.End()
+	}()
+
+	m.Shutdown()
+	if m.Begin() {
+		t.Fatalf("expected request cycle to be refused after shutdown")
+	}
+
+	expect := []string{"stop", "request", "journal", "store"}
+	if len(events) != len(expect) {
+		t.Fatalf("expected %v, got %v", expect, events)
+	}
+	for i, v := range expect {
+		if events[i] != v {
+			t.Fatalf("expected %v, got %v", expect, events)
+		}
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	var closed bool
+
+	m := NewManager(10 * time.Millisecond)
+	m.OnClose("store", func() error {
+		closed = true
+		return nil
+	})
+	if !m.Begin() {
+		t.Fatalf("expected request cycle to begin")
+	}
+
+	m.Shutdown()
+	if !closed {
+		t.Fatalf("expected store to be closed after deadline")
+	}
+	m.End()
+
+	// later calls return once shutdown is complete
+	m.Shutdown()
+	m.Wait()
+}
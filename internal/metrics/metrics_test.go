@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_requests_total", "Test requests.", "outcome")
+	h := r.NewHistogram("test_latency_seconds", "Test latency.", []float64{0.1, 1}, "call")
+	r.NewGaugeFunc("test_sessions", "Test sessions.", func() float64 {
+		return 3
+	})
+
+	c.Inc("ok")
+	c.Inc("ok")
+	c.Inc("error")
+	h.Observe(0.05, "balance")
+	h.Observe(0.5, "balance")
+	h.Observe(5, "balance")
+
+	w := bytes.NewBuffer(nil)
+	err := r.Write(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `# HELP test_requests_total Test requests.
+# TYPE test_requests_total counter
+test_requests_total{outcome="error"} 1
+test_requests_total{outcome="ok"} 2
+# HELP test_latency_seconds Test latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{call="balance",le="0.1"} 1
+test_latency_seconds_bucket{call="balance",le="1"} 2
+test_latency_seconds_bucket{call="balance",le="+Inf"} 3
+test_latency_seconds_sum{call="balance"} 5.55
+test_latency_seconds_count{call="balance"} 3
+# HELP test_sessions Test sessions.
+# TYPE test_sessions gauge
+test_sessions 3
+`
+	if w.String() != expect {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expect, w.String())
+	}
+}
+
+func TestRegistryServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "Test.")
+	c.Inc()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("unexpected content type '%s'", w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Body.String(), "test_total 1\n") {
+		t.Fatalf("expected counter in output, got %s", w.Body.String())
+	}
+}
+
+func TestLabelEscape(t *testing.T) {
+	k := labelKey([]string{"call"}, []string{"a\"b\\c\nd"})
+	if k != `{call="a\"b\\c\nd"}` {
+		t.Fatalf("unexpected label set %s", k)
+	}
+}
+
+func TestActiveSessions(t *testing.T) {
+	s := NewActiveSessions(time.Hour)
+	s.Touch("+254712345678")
+	s.Touch("+254712345679")
+	s.Touch("+254712345678")
+	if s.Count() != 2 {
+		t.Fatalf("expected 2 active sessions, got %d", s.Count())
+	}
+	s.End("+254712345678")
+	if s.Count() != 1 {
+		t.Fatalf("expected 1 active session, got %d", s.Count())
+	}
+
+	s = NewActiveSessions(time.Millisecond)
+	s.Touch("+254712345678")
+	time.Sleep(5 * time.Millisecond)
+	if s.Count() != 0 {
+		t.Fatalf("expected idle session to expire, got %d", s.Count())
+	}
+}
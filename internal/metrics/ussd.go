@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"git.defalsify.org/vise.git/logging"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("metrics")
+)
+
+const (
+	// SessionIdle is the time after its last request that a session is no longer counted as active.
+	//
+	// It matches the time most USSD gateways keep a session open waiting for input.
+	SessionIdle = 3 * time.Minute
+)
+
+var (
+	// Default is the registry served by the metrics endpoint of the servers.
+	Default = NewRegistry()
+
+	// Requests counts the menu requests by outcome: "continue", "end", "halted" or "error".
+	Requests = Default.NewCounter("ussd_requests_total", "Menu requests processed, by outcome.", "outcome")
+	// EngineExec is the latency of the menu engine executing an input.
+	EngineExec = Default.NewHistogram("ussd_engine_exec_seconds", "Latency of the menu engine executing an input.", DefaultBuckets)
+	// RemoteCall is the latency of the calls to the custodial and data services, by call and outcome.
+	RemoteCall = Default.NewHistogram("ussd_remote_call_seconds", "Latency of calls to the custodial and data services, by call and outcome.", DefaultBuckets, "call", "outcome")
+	// Sessions tracks the sessions currently in use.
+	Sessions = NewActiveSessions(SessionIdle)
+)
+
+func init() {
+	Default.NewGaugeFunc("ussd_active_sessions", "Sessions that are waiting for input.", func() float64 {
+		return float64(Sessions.Count())
+	})
+}
+
+// Outcome returns "ok" for a nil error, and "error" otherwise.
+func Outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// ActiveSessions counts the sessions that are waiting for input.
+//
+// A session is active from a request that continues it until a request ends it, or until it has been idle for longer than the idle time.
+type ActiveSessions struct {
+	idle time.Duration
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// NewActiveSessions creates a new ActiveSessions, expiring sessions after the given idle time.
+func NewActiveSessions(idle time.Duration) *ActiveSessions {
+	return &ActiveSessions{
+		idle: idle,
+		seen: make(map[string]time.Time),
+	}
+}
+
+// Touch marks the session as active.
+func (s *ActiveSessions) Touch(sessionId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seen[sessionId] = time.Now()
+}
+
+// End marks the session as ended.
+func (s *ActiveSessions) End(sessionId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.seen, sessionId)
+}
+
+// Count returns the number of active sessions, forgetting the ones that have been idle for too long.
+func (s *ActiveSessions) Count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, v := range s.seen {
+		if now.Sub(v) > s.idle {
+			delete(s.seen, k)
+		}
+	}
+	return len(s.seen)
+}
@@ -0,0 +1,200 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+	"git.defalsify.org/vise.git/logging"
+	"gopkg.in/leonelquinteros/gotext.v1"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/config"
+	"git.grassecon.net/urdt/ussd/internal/sms"
+	"git.grassecon.net/urdt/ussd/internal/storage"
+)
+
+var (
+	logg           = logging.NewVanilla().WithDomain("notify")
+	translationDir = path.Join("services", "registration", "locale")
+)
+
+var (
+	ErrInvalidEvent = errors.New("invalid transfer event")
+)
+
+const (
+	// StatusQueued is returned when the notification of the recipient was queued.
+	StatusQueued = "queued"
+	// StatusDuplicate is returned when the transfer was notified before.
+	StatusDuplicate = "duplicate"
+	// StatusIgnored is returned when the recipient has no account on the service.
+	StatusIgnored = "ignored"
+)
+
+// TransferEvent is a token transfer reported by the custodial or indexer services.
+type TransferEvent struct {
+	TxHash string `json:"tx_hash"`
+	// From is the address of the sender.
+	From string `json:"from"`
+	// To is the address of the recipient.
+	To string `json:"to"`
+	// Value is the amount transferred, in the smallest unit of the token.
+	Value         string `json:"value"`
+	TokenSymbol   string `json:"token_symbol"`
+	TokenDecimals uint8  `json:"token_decimals"`
+}
+
+// Validate returns ErrInvalidEvent if a field needed for the notification is missing or malformed.
+func (ev *TransferEvent) Validate() error {
+	if ev.TxHash == "" || ev.Value == "" || ev.TokenSymbol == "" {
+		return fmt.Errorf("%w: missing tx hash, value or token symbol", ErrInvalidEvent)
+	}
+	_, err := common.NormalizeHex(ev.TxHash)
+	if err != nil {
+		return fmt.Errorf("%w: tx hash: %v", ErrInvalidEvent, err)
+	}
+	_, err = common.NormalizeHex(ev.To)
+	if err != nil {
+		return fmt.Errorf("%w: recipient address: %v", ErrInvalidEvent, err)
+	}
+	return nil
+}
+
+// Notifier tells the recipients of transfers about them by SMS.
+type Notifier struct {
+	userdataDb    db.Db
+	userdataStore common.DataStore
+	queue         sms.Enqueuer
+	mu            sync.Mutex
+}
+
+// NewNotifier creates a new Notifier, looking up the recipients in the userdata store and sending through the queue.
+//
+// The transfers notified are recorded in the userdata store, so that each is notified only once.
+func NewNotifier(userdataStore db.Db, queue sms.Enqueuer) *Notifier {
+	return &Notifier{
+		userdataDb: userdataStore,
+		userdataStore: &common.UserDataStore{
+			Db: userdataStore,
+		},
+		queue: queue,
+	}
+}
+
+// Notify queues the notification of the transfer to its recipient, and returns the resulting status.
+//
+// Transfers to addresses without an account on the service are ignored.
+func (n *Notifier) Notify(ctx context.Context, ev TransferEvent) (string, error) {
+	err := ev.Validate()
+	if err != nil {
+		return "", err
+	}
+	txHash, _ := common.NormalizeHex(ev.TxHash)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	notified, err := n.notified(ctx, txHash)
+	if err != nil {
+		return "", err
+	}
+	if notified {
+		logg.DebugCtxf(ctx, "transfer already notified", "txHash", txHash)
+		return StatusDuplicate, nil
+	}
+
+	recipient, err := n.lookup(ctx, ev.To)
+	if err != nil {
+		return "", err
+	}
+	if recipient == "" {
+		logg.DebugCtxf(ctx, "transfer recipient has no account", "txHash", txHash, "to", ev.To)
+		return StatusIgnored, nil
+	}
+
+	sender, err := n.lookup(ctx, ev.From)
+	if err != nil {
+		return "", err
+	}
+	if sender == "" {
+		sender = ev.From
+	}
+
+	code, err := n.userdataStore.ReadEntry(ctx, recipient, common.DATA_SELECTED_LANGUAGE_CODE)
+	if err != nil {
+		if !db.IsNotFound(err) {
+			return "", err
+		}
+		code = []byte(config.DefaultLanguage)
+	}
+	l := gotext.NewLocale(translationDir, string(code))
+	l.AddDomain("default")
+
+	number, err := common.FormatPhoneNumber(recipient)
+	if err != nil {
+		number = recipient
+	}
+	amount := common.ScaleDownBalance(ev.Value, strconv.Itoa(int(ev.TokenDecimals)))
+	err = n.queue.Enqueue(ctx, sms.Message{
+		Recipient: number,
+		Text:      l.Get("You have received %s %s from %s.", amount, ev.TokenSymbol, sender),
+		SessionId: recipient,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = n.markNotified(ctx, txHash)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to record notified transfer", "txHash", txHash, "err", err)
+	}
+	logg.InfoCtxf(ctx, "transfer notification queued", "txHash", txHash, "recipient", recipient)
+	return StatusQueued, nil
+}
+
+// lookup returns the session id of the account with the address, or an empty string if there is none.
+func (n *Notifier) lookup(ctx context.Context, address string) (string, error) {
+	if address == "" {
+		return "", nil
+	}
+	addr, err := common.NormalizeHex(address)
+	if err != nil {
+		return "", nil
+	}
+	v, err := n.userdataStore.ReadEntry(ctx, addr, common.DATA_PUBLIC_KEY_REVERSE)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return string(v), nil
+}
+
+func (n *Notifier) notified(ctx context.Context, txHash string) (bool, error) {
+	k := append([]byte{storage.EXTEND_TX_NOTIFIED}, []byte(txHash)...)
+	n.userdataDb.SetLanguage(nil)
+	n.userdataDb.SetSession("")
+	n.userdataDb.SetPrefix(storage.DATATYPE_EXTEND)
+	_, err := n.userdataDb.Get(ctx, k)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (n *Notifier) markNotified(ctx context.Context, txHash string) error {
+	k := append([]byte{storage.EXTEND_TX_NOTIFIED}, []byte(txHash)...)
+	n.userdataDb.SetSession("")
+	n.userdataDb.SetPrefix(storage.DATATYPE_EXTEND)
+	return n.userdataDb.Put(ctx, k, []byte(time.Now().UTC().Format(time.RFC3339)))
+}
@@ -0,0 +1,279 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	memdb "git.defalsify.org/vise.git/db/mem"
+	testdataloader "github.com/peteole/testdata-loader"
+
+	"git.grassecon.net/urdt/ussd/common"
+	httpserver "git.grassecon.net/urdt/ussd/internal/http"
+	"git.grassecon.net/urdt/ussd/internal/sms"
+)
+
+const (
+	recipientAddress = "0x5523058cdFfe5F3c1EaDADD5015E55C6E00fb439"
+	senderAddress    = "0xd4c288865Ce0985a481Eef3be02443dF5E2e4Ea9"
+	txHash           = "0x0f4e6ad95bdd4e9b3e0b2d6e1b41eeea3d8d1c3bff5e8a9c4a13b0d1e3c9f7a1"
+)
+
+type testQueue struct {
+	msgs []sms.Message
+	err  error
+}
+
+func (q *testQueue) Enqueue(ctx context.Context, msg sms.Message) error {
+	if q.err != nil {
+		return q.err
+	}
+	q.msgs = append(q.msgs, msg)
+	return nil
+}
+
+func TestMain(m *testing.M) {
+	translationDir = path.Join(testdataloader.GetBasePath(), "services", "registration", "locale")
+	os.Exit(m.Run())
+}
+
+func newTestNotifier(t *testing.T, q sms.Enqueuer) (context.Context, *Notifier) {
+	ctx := context.Background()
+	db := memdb.NewMemDb()
+	err := db.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	store := &common.UserDataStore{Db: db}
+	accounts := map[string]string{
+		recipientAddress: "+254711111111",
+		senderAddress:    "+254722222222",
+	}
+	for addr, sessionId := range accounts {
+		k, _ := common.NormalizeHex(addr)
+		err = store.WriteEntry(ctx, k, common.DATA_PUBLIC_KEY_REVERSE, []byte(sessionId))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = store.WriteEntry(ctx, "+254711111111", common.DATA_SELECTED_LANGUAGE_CODE, []byte("swa"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctx, NewNotifier(db, q)
+}
+
+func TestNotify(t *testing.T) {
+	q := &testQueue{}
+	ctx, n := newTestNotifier(t, q)
+	ev := TransferEvent{
+		TxHash:        txHash,
+		From:          senderAddress,
+		To:            recipientAddress,
+		Value:         "1500000",
+		TokenSymbol:   "SRF",
+		TokenDecimals: 6,
+	}
+
+	status, err := n.Notify(ctx, ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != StatusQueued {
+		t.Fatalf("expected %s, got %s", StatusQueued, status)
+	}
+	if len(q.msgs) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(q.msgs))
+	}
+	msg := q.msgs[0]
+	if msg.Recipient != "+254711111111" || msg.SessionId != "+254711111111" {
+		t.Fatalf("unexpected recipient %v", msg)
+	}
+	expected := "Umepokea 1.5 SRF kutoka kwa +254722222222."
+	if msg.Text != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, msg.Text)
+	}
+
+	// the same transfer is notified once, whatever the case of the hash
+	ev.TxHash = strings.ToUpper(txHash[2:])
+	status, err = n.Notify(ctx, ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != StatusDuplicate {
+		t.Fatalf("expected %s, got %s", StatusDuplicate, status)
+	}
+	if len(q.msgs) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(q.msgs))
+	}
+}
+
+func TestNotifyUnknownAccounts(t *testing.T) {
+	q := &testQueue{}
+	ctx, n := newTestNotifier(t, q)
+
+	status, err := n.Notify(ctx, TransferEvent{
+		TxHash:      txHash,
+		From:        senderAddress,
+		To:          "0x0000000000000000000000000000000000000001",
+		Value:       "1",
+		TokenSymbol: "SRF",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != StatusIgnored {
+		t.Fatalf("expected %s, got %s", StatusIgnored, status)
+	}
+
+	// unknown senders are shown by address
+	status, err = n.Notify(ctx, TransferEvent{
+		TxHash:      txHash,
+		From:        "0x0000000000000000000000000000000000000002",
+		To:          recipientAddress,
+		Value:       "1",
+		TokenSymbol: "SRF",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != StatusQueued {
+		t.Fatalf("expected %s, got %s", StatusQueued, status)
+	}
+	if !strings.HasSuffix(q.msgs[0].Text, "0x0000000000000000000000000000000000000002.") {
+		t.Fatalf("unexpected text '%s'", q.msgs[0].Text)
+	}
+}
+
+func TestNotifyQueueFailure(t *testing.T) {
+	q := &testQueue{err: sms.ErrQueueFull}
+	ctx, n := newTestNotifier(t, q)
+	ev := TransferEvent{
+		TxHash:      txHash,
+		To:          recipientAddress,
+		Value:       "1",
+		TokenSymbol: "SRF",
+	}
+
+	_, err := n.Notify(ctx, ev)
+	if !errors.Is(err, sms.ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+
+	// a failed notification is not recorded, so it is tried again
+	q.err = nil
+	status, err := n.Notify(ctx, ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != StatusQueued {
+		t.Fatalf("expected %s, got %s", StatusQueued, status)
+	}
+}
+
+func sign(key string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookHandler(t *testing.T) {
+	key := "secret"
+	valid := []byte(`{"tx_hash":"` + txHash + `","from":"` + senderAddress + `","to":"` + recipientAddress + `","value":"2000000","token_symbol":"SRF","token_decimals":6}`)
+
+	_, err := NewWebhookHandler(nil, &httpserver.InboundAuth{})
+	if err == nil {
+		t.Fatal("expected error without signature key")
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		body           []byte
+		signature      string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "queued",
+			method:         http.MethodPost,
+			body:           valid,
+			signature:      sign(key, valid),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"status":"queued"}`,
+		},
+		{
+			name:           "duplicate",
+			method:         http.MethodPost,
+			body:           valid,
+			signature:      "sha256=" + sign(key, valid),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"status":"duplicate"}`,
+		},
+		{
+			name:           "bad signature",
+			method:         http.MethodPost,
+			body:           valid,
+			signature:      sign("other", valid),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "missing signature",
+			method:         http.MethodPost,
+			body:           valid,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "invalid event",
+			method:         http.MethodPost,
+			body:           []byte(`{"tx_hash":"foo"}`),
+			signature:      sign(key, []byte(`{"tx_hash":"foo"}`)),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "malformed body",
+			method:         http.MethodPost,
+			body:           []byte(`{`),
+			signature:      sign(key, []byte(`{`)),
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	q := &testQueue{}
+	_, n := newTestNotifier(t, q)
+	h, err := NewWebhookHandler(n, &httpserver.InboundAuth{HmacKey: []byte(key)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, DefaultEndpoint, bytes.NewReader(tt.body))
+			if tt.signature != "" {
+				req.Header.Set(httpserver.DefaultSignatureHeader, tt.signature)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedBody != "" && strings.TrimSpace(w.Body.String()) != tt.expectedBody {
+				t.Fatalf("expected body %s, got %s", tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+	if len(q.msgs) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(q.msgs))
+	}
+}
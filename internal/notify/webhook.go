@@ -0,0 +1,85 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"git.defalsify.org/vise.git/db"
+
+	httpserver "git.grassecon.net/urdt/ussd/internal/http"
+	"git.grassecon.net/urdt/ussd/internal/sms"
+)
+
+const (
+	// DefaultEndpoint is the path transfer events are posted to.
+	DefaultEndpoint = "/webhook/transfer"
+	// EnvPrefix is the prefix of the environment variables configuring the signature of the webhook.
+	EnvPrefix = "WEBHOOK"
+)
+
+type webhookResponse struct {
+	Status string `json:"status"`
+}
+
+// WebhookHandler receives transfer events, and notifies their recipients.
+type WebhookHandler struct {
+	notifier *Notifier
+}
+
+// NewWebhookHandler creates a new WebhookHandler, verifying the requests with auth.
+//
+// auth must at least check the signature of the request body, as anyone able to post events could send SMS to the subscribers of the service.
+func NewWebhookHandler(notifier *Notifier, auth *httpserver.InboundAuth) (http.Handler, error) {
+	if len(auth.HmacKey) == 0 {
+		return nil, fmt.Errorf("webhook needs a signature key")
+	}
+	return auth.Handler(&WebhookHandler{
+		notifier: notifier,
+	}), nil
+}
+
+// WebhookFromEnv creates the webhook handler notifying the recipients in the userdata store through the queue, verifying the requests with the settings of the WEBHOOK_ environment variables.
+//
+// It returns nil if there is no queue, or WEBHOOK_HMAC_KEY is not set.
+func WebhookFromEnv(userdataStore db.Db, queue *sms.Queue) (http.Handler, error) {
+	auth, err := httpserver.InboundAuthFromEnv(EnvPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if queue == nil || len(auth.HmacKey) == 0 {
+		return nil, nil
+	}
+	return NewWebhookHandler(NewNotifier(userdataStore, queue), auth)
+}
+
+// ServeHTTP implements http.Handler.
+//
+// Events that cannot be parsed are rejected with 400. If the notification cannot be queued, 503 is returned so that the event is sent again.
+func (wh *WebhookHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	var ev TransferEvent
+	err := json.NewDecoder(req.Body).Decode(&ev)
+	if err != nil {
+		http.Error(w, "invalid event", http.StatusBadRequest)
+		return
+	}
+	status, err := wh.notifier.Notify(req.Context(), ev)
+	if err != nil {
+		if errors.Is(err, ErrInvalidEvent) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logg.ErrorCtxf(req.Context(), "transfer notification failed", "txHash", ev.TxHash, "err", err)
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhookResponse{
+		Status: status,
+	})
+}
@@ -0,0 +1,271 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+	"git.defalsify.org/vise.git/logging"
+	"git.defalsify.org/vise.git/persist"
+	"gopkg.in/leonelquinteros/gotext.v1"
+
+	"git.grassecon.net/urdt/ussd/config"
+	"git.grassecon.net/urdt/ussd/internal/handlers"
+	"git.grassecon.net/urdt/ussd/internal/storage"
+)
+
+var (
+	logg           = logging.NewVanilla().WithDomain("ratelimit")
+	translationDir = path.Join("services", "registration", "locale")
+)
+
+const (
+	scopeSession = "session"
+	scopeSource  = "source"
+	scopeSymbol  = "symbol"
+)
+
+// Policy is a token bucket policy.
+//
+// The bucket holds at most Burst tokens, and is refilled at a rate of Burst tokens per Period.
+type Policy struct {
+	Burst  uint
+	Period time.Duration
+}
+
+// ParsePolicy parses a policy from its string representation "<requests>/<period>", e.g. "10/1m".
+func ParsePolicy(s string) (Policy, error) {
+	var p Policy
+	v := strings.SplitN(strings.TrimSpace(s), "/", 2)
+	if len(v) != 2 {
+		return p, fmt.Errorf("invalid rate limit policy: '%s'", s)
+	}
+	burst, err := strconv.ParseUint(v[0], 10, 32)
+	if err != nil {
+		return p, fmt.Errorf("invalid rate limit requests: '%s'", s)
+	}
+	period, err := time.ParseDuration(v[1])
+	if err != nil {
+		return p, fmt.Errorf("invalid rate limit period: '%s'", s)
+	}
+	if burst == 0 || period <= 0 {
+		return p, fmt.Errorf("rate limit policy must be positive: '%s'", s)
+	}
+	p.Burst = uint(burst)
+	p.Period = period
+	return p, nil
+}
+
+// ParseSymbolPolicies parses a comma separated list of "<symbol>=<policy>" pairs.
+func ParseSymbolPolicies(s string) (map[string]Policy, error) {
+	policies := make(map[string]Policy)
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid symbol rate limit: '%s'", v)
+		}
+		p, err := ParsePolicy(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		policies[strings.TrimSpace(kv[0])] = p
+	}
+	return policies, nil
+}
+
+// Policies is the set of rate limits applied to requests.
+//
+// A nil Session or Source policy disables the respective limit.
+type Policies struct {
+	// Session limits all requests made by a single session.
+	Session *Policy
+	// Source limits all requests arriving from the same gateway source.
+	Source *Policy
+	// Symbols limits requests per session made at the given menu nodes.
+	//
+	// The node is the one receiving the input, e.g. "send" for recipient lookups, and "transaction_pin" for PIN entry.
+	Symbols map[string]Policy
+}
+
+// PoliciesFromConfig builds the rate limit policies from the config settings.
+func PoliciesFromConfig() (Policies, error) {
+	var pl Policies
+	if config.RateLimitSession != "" {
+		p, err := ParsePolicy(config.RateLimitSession)
+		if err != nil {
+			return pl, err
+		}
+		pl.Session = &p
+	}
+	if config.RateLimitSource != "" {
+		p, err := ParsePolicy(config.RateLimitSource)
+		if err != nil {
+			return pl, err
+		}
+		pl.Source = &p
+	}
+	symbols, err := ParseSymbolPolicies(config.RateLimitSymbols)
+	if err != nil {
+		return pl, err
+	}
+	pl.Symbols = symbols
+	return pl, nil
+}
+
+// Empty returns true if no limits are defined.
+func (pl Policies) Empty() bool {
+	return pl.Session == nil && pl.Source == nil && len(pl.Symbols) == 0
+}
+
+// Limiter enforces rate limit policies with token buckets kept in the state store.
+type Limiter struct {
+	store    db.Db
+	resolver handlers.StateResolver
+	policies Policies
+	mu       sync.Mutex
+	now      func() time.Time
+}
+
+// NewLimiter creates a new Limiter persisting its buckets in the given state store.
+func NewLimiter(store db.Db, policies Policies) *Limiter {
+	return &Limiter{
+		store:    store,
+		policies: policies,
+		now:      time.Now,
+	}
+}
+
+// WithStateResolver makes the limiter look up the node and language of requests in the state store the resolver gives for them, like that of the route a request is passed on to.
+//
+// The buckets are still kept in the store given to NewLimiter, so that they are shared by all routes.
+func (l *Limiter) WithStateResolver(sr handlers.StateResolver) *Limiter {
+	l.resolver = sr
+	return l
+}
+
+// Allow consumes a token from each bucket that applies to the request, and returns false if any of them is empty.
+//
+// An empty source or symbol skips the respective limits.
+func (l *Limiter) Allow(ctx context.Context, sessionId string, source string, sym string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.policies.Source != nil && source != "" {
+		ok, err := l.take(ctx, scopeSource, source, *l.policies.Source)
+		if err != nil || !ok {
+			return ok, err
+		}
+	}
+	if l.policies.Session != nil {
+		ok, err := l.take(ctx, scopeSession, sessionId, *l.policies.Session)
+		if err != nil || !ok {
+			return ok, err
+		}
+	}
+	p, ok := l.policies.Symbols[sym]
+	if ok && sym != "" {
+		return l.take(ctx, scopeSymbol, sym+":"+sessionId, p)
+	}
+	return true, nil
+}
+
+// take refills the bucket for the time elapsed since it was last used, and then attempts to remove one token from it.
+func (l *Limiter) take(ctx context.Context, scope string, id string, p Policy) (bool, error) {
+	k := append([]byte{storage.EXTEND_RATE_LIMIT}, []byte(scope+":"+id)...)
+	now := l.now()
+	capacity := float64(p.Burst)
+	tokens := capacity
+
+	l.store.SetLanguage(nil)
+	l.store.SetPrefix(storage.DATATYPE_EXTEND)
+	v, err := l.store.Get(ctx, k)
+	if err != nil {
+		if !db.IsNotFound(err) {
+			return false, err
+		}
+	} else if len(v) == 16 {
+		tokens = math.Float64frombits(binary.BigEndian.Uint64(v[:8]))
+		last := time.Unix(0, int64(binary.BigEndian.Uint64(v[8:])))
+		elapsed := now.Sub(last)
+		if elapsed > 0 {
+			tokens += capacity * float64(elapsed) / float64(p.Period)
+		}
+		if tokens > capacity {
+			tokens = capacity
+		}
+	}
+
+	allowed := tokens >= 1
+	if allowed {
+		tokens -= 1
+	}
+
+	v = make([]byte, 16)
+	binary.BigEndian.PutUint64(v[:8], math.Float64bits(tokens))
+	binary.BigEndian.PutUint64(v[8:], uint64(now.UnixNano()))
+	l.store.SetPrefix(storage.DATATYPE_EXTEND)
+	err = l.store.Put(ctx, k, v)
+	if err != nil {
+		return false, err
+	}
+	if !allowed {
+		logg.InfoCtxf(ctx, "rate limit exceeded", "scope", scope, "id", id)
+	}
+	return allowed, nil
+}
+
+// Middleware returns a request handler middleware enforcing the limits.
+//
+// Limited requests are ended with a message in the language of the session. If the limits cannot be checked, the request is let through.
+//
+// If no limits are defined, the middleware passes requests on untouched.
+func (l *Limiter) Middleware() handlers.Middleware {
+	if l.policies.Empty() {
+		return func(h handlers.RequestHandler) handlers.RequestHandler {
+			return h
+		}
+	}
+	return handlers.ProcessMiddleware(l.process)
+}
+
+func (l *Limiter) process(rqs handlers.RequestSession, next handlers.ProcessFunc) (handlers.RequestSession, error) {
+	var sym string
+	code := config.DefaultLanguage
+
+	sessionId := rqs.Config.SessionId
+	pe := persist.NewPersister(handlers.ResolveStateStore(rqs.Ctx, l.resolver, l.store))
+	err := pe.Load(sessionId)
+	if err != nil {
+		if !db.IsNotFound(err) {
+			logg.WarnCtxf(rqs.Ctx, "cannot load state for rate limit", "sessionId", sessionId, "err", err)
+		}
+	} else if st := pe.GetState(); st != nil {
+		sym, _ = st.Where()
+		if st.Language != nil {
+			code = st.Language.Code
+		}
+	}
+
+	ok, err := l.Allow(rqs.Ctx, sessionId, rqs.Source, sym)
+	if err != nil {
+		logg.ErrorCtxf(rqs.Ctx, "rate limit check failed", "sessionId", sessionId, "err", err)
+		return next(rqs)
+	}
+	if !ok {
+		lc := gotext.NewLocale(translationDir, code)
+		lc.AddDomain("default")
+		return handlers.Halt(rqs, lc.Get("You have made too many requests. Please try again later.")), nil
+	}
+	return next(rqs)
+}
@@ -0,0 +1,192 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	memdb "git.defalsify.org/vise.git/db/mem"
+	"git.defalsify.org/vise.git/engine"
+	"git.defalsify.org/vise.git/persist"
+	"git.defalsify.org/vise.git/resource"
+
+	"git.grassecon.net/urdt/ussd/internal/handlers"
+)
+
+type testHandler struct {
+	processed int
+}
+
+func (h *testHandler) GetConfig() engine.Config {
+	return engine.Config{}
+}
+
+func (h *testHandler) GetRequestParser() handlers.RequestParser {
+	return nil
+}
+
+func (h *testHandler) GetEngine(cfg engine.Config, rs resource.Resource, pe *persist.Persister) engine.Engine {
+	return nil
+}
+
+func (h *testHandler) Process(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+	h.processed += 1
+	rqs.Continue = true
+	return rqs, nil
+}
+
+func (h *testHandler) Output(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+	if rqs.Engine == nil {
+		return rqs, nil
+	}
+	_, err := rqs.Engine.Flush(rqs.Ctx, rqs.Writer)
+	return rqs, err
+}
+
+func (h *testHandler) Reset(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+	return rqs, nil
+}
+
+func (h *testHandler) Shutdown() {
+}
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy("10/1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Burst != 10 || p.Period != time.Minute {
+		t.Fatalf("unexpected policy: %v", p)
+	}
+	for _, s := range []string{"", "10", "0/1m", "10/0s", "ten/1m", "10/soon"} {
+		_, err = ParsePolicy(s)
+		if err == nil {
+			t.Fatalf("expected error for '%s'", s)
+		}
+	}
+
+	ps, err := ParseSymbolPolicies("send=5/1m, transaction_pin=3/10m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 2 || ps["transaction_pin"].Burst != 3 {
+		t.Fatalf("unexpected symbol policies: %v", ps)
+	}
+	_, err = ParseSymbolPolicies("send")
+	if err == nil {
+		t.Fatalf("expected error for missing policy")
+	}
+}
+
+func TestLimiterAllow(t *testing.T) {
+	ctx := context.Background()
+	store := memdb.NewMemDb()
+	err := store.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	clock := func() time.Time {
+		return now
+	}
+	pl := Policies{
+		Symbols: map[string]Policy{
+			"send": Policy{Burst: 2, Period: time.Minute},
+		},
+	}
+	lim := NewLimiter(store, pl)
+	lim.now = clock
+
+	for i := 0; i < 2; i++ {
+		ok, err := lim.Allow(ctx, "+254700000000", "", "send")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatalf("expected request %d to be allowed", i)
+		}
+	}
+
+	// buckets are kept in the store, and survive a new limiter
+	lim = NewLimiter(store, pl)
+	lim.now = clock
+	ok, err := lim.Allow(ctx, "+254700000000", "", "send")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatalf("expected request to be limited")
+	}
+	ok, err = lim.Allow(ctx, "+254700000001", "", "send")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatalf("expected other session to be allowed")
+	}
+	ok, err = lim.Allow(ctx, "+254700000000", "", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatalf("expected unlimited symbol to be allowed")
+	}
+
+	now = now.Add(30 * time.Second)
+	ok, err = lim.Allow(ctx, "+254700000000", "", "send")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatalf("expected request to be allowed after refill")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	ctx := context.Background()
+	store := memdb.NewMemDb()
+	err := store.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl := Policies{
+		Source: &Policy{Burst: 1, Period: time.Hour},
+	}
+	h := &testHandler{}
+	rh := handlers.WithMiddleware(h, NewLimiter(store, pl).Middleware())
+
+	w := bytes.NewBuffer(nil)
+	rqs := handlers.RequestSession{
+		Ctx:    ctx,
+		Config: engine.Config{SessionId: "+254700000000"},
+		Writer: w,
+		Source: "127.0.0.1",
+	}
+	r, err := rh.Process(rqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handlers.IsHalted(r) {
+		t.Fatalf("expected first request to pass")
+	}
+
+	rqs.Config.SessionId = "+254700000001"
+	r, err = rh.Process(rqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !handlers.IsHalted(r) || r.Continue {
+		t.Fatalf("expected request from same source to be halted")
+	}
+	_, err = rh.Output(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.String() != "You have made too many requests. Please try again later." {
+		t.Fatalf("unexpected limit message: '%s'", w.String())
+	}
+	if h.processed != 1 {
+		t.Fatalf("expected handler to process once, got %d", h.processed)
+	}
+}
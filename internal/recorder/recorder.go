@@ -0,0 +1,215 @@
+// Package recorder records menu sessions as steps of the menu traversal tests.
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"git.defalsify.org/vise.git/engine"
+	"git.defalsify.org/vise.git/logging"
+
+	"git.grassecon.net/urdt/ussd/internal/handlers"
+	"git.grassecon.net/urdt/ussd/internal/testutil/driver"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("recorder")
+)
+
+const (
+	// testMenuSeparator is the menu separator of the engine of the menu traversal tests.
+	testMenuSeparator = ":"
+)
+
+// Placeholder replaces a value that changes between runs, such as a balance, with {Name} in the recorded output.
+//
+// If Pattern has a subexpression, only the text matched by the first one is replaced.
+type Placeholder struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+func (p Placeholder) apply(s string) string {
+	return p.Pattern.ReplaceAllStringFunc(s, func(match string) string {
+		sub := p.Pattern.FindStringSubmatchIndex(match)
+		if len(sub) < 4 || sub[2] < 0 {
+			return "{" + p.Name + "}"
+		}
+		return match[:sub[2]] + "{" + p.Name + "}" + match[sub[3]:]
+	})
+}
+
+// DefaultPlaceholders returns the placeholders the menu traversal tests fill in.
+func DefaultPlaceholders() []Placeholder {
+	return []Placeholder{
+		{Name: "balance", Pattern: regexp.MustCompile(`(?m)^Balance:\s+(\d+(\.\d+)?\s+[A-Z]+)`)},
+		{Name: "max_amount", Pattern: regexp.MustCompile(`(?m)^Maximum amount:\s+(\d+(\.\d+)?)`)},
+		{Name: "send_amount", Pattern: regexp.MustCompile(`will receive (\d+\.\d{2}\s+[A-Z]+) from`)},
+		{Name: "public_key", Pattern: regexp.MustCompile(`0x[a-fA-F0-9]{40}`)},
+	}
+}
+
+// Recorder collects the inputs of a session and the outputs they render, as a group of steps of a driver.Session.
+type Recorder struct {
+	sessionId     string
+	group         string
+	steps         []driver.Step
+	placeholders  []Placeholder
+	menuSeparator *regexp.Regexp
+	mu            sync.Mutex
+}
+
+// NewRecorder creates a new Recorder for the session, recording its steps in a group with the given name.
+func NewRecorder(sessionId string, group string) *Recorder {
+	return &Recorder{
+		sessionId: sessionId,
+		group:     group,
+	}
+}
+
+// WithPlaceholders replaces the values matched by the placeholders, and the session id, in the recorded outputs.
+func (r *Recorder) WithPlaceholders(placeholders []Placeholder) *Recorder {
+	r.placeholders = append(append([]Placeholder{}, placeholders...), Placeholder{
+		Name:    "session_id",
+		Pattern: regexp.MustCompile(regexp.QuoteMeta(r.sessionId)),
+	})
+	return r
+}
+
+// WithMenuSeparator records the menu items rendered with the given separator as rendered by the engine of the menu traversal tests, e.g. "1: Send" as "1:Send".
+func (r *Recorder) WithMenuSeparator(sep string) *Recorder {
+	if sep == "" || sep == testMenuSeparator {
+		r.menuSeparator = nil
+		return r
+	}
+	r.menuSeparator = regexp.MustCompile(`(?m)^(\d+)` + regexp.QuoteMeta(sep))
+	return r
+}
+
+// Record adds a step with the input and the output rendered for it.
+func (r *Recorder) Record(input []byte, output []byte) {
+	content := strings.TrimRight(string(output), "\n")
+	if r.menuSeparator != nil {
+		content = r.menuSeparator.ReplaceAllString(content, "${1}"+testMenuSeparator)
+	}
+	for _, p := range r.placeholders {
+		content = p.apply(content)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.steps = append(r.steps, driver.Step{
+		Input:           string(input),
+		ExpectedContent: content,
+	})
+}
+
+// Session returns the recorded steps in the format of the menu traversal test fixtures.
+func (r *Recorder) Session() driver.Session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return driver.Session{
+		Name: r.sessionId,
+		Groups: []driver.Group{
+			{
+				Name:  r.group,
+				Steps: append([]driver.Step{}, r.steps...),
+			},
+		},
+	}
+}
+
+// WriteFile writes the recorded session to the file as JSON.
+//
+// Nothing is written if no step was recorded.
+func (r *Recorder) WriteFile(fp string) error {
+	session := r.Session()
+	if len(session.Groups[0].Steps) == 0 {
+		return nil
+	}
+	b, err := json.MarshalIndent(session, "", "    ")
+	if err != nil {
+		return err
+	}
+	b = append(b, 0x0a)
+	return os.WriteFile(fp, b, 0600)
+}
+
+// GroupName returns the name of the group recorded to the file, which is the name of the file without extension.
+func GroupName(fp string) string {
+	name := path.Base(fp)
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// WrapEngine returns an engine that records the last input passed to Exec, together with the output of the Flush that follows.
+func (r *Recorder) WrapEngine(en engine.Engine) engine.Engine {
+	return &recordingEngine{
+		Engine: en,
+		rec:    r,
+	}
+}
+
+type recordingEngine struct {
+	engine.Engine
+	rec   *Recorder
+	input []byte
+}
+
+func (en *recordingEngine) Exec(ctx context.Context, input []byte) (bool, error) {
+	en.input = append([]byte{}, input...)
+	return en.Engine.Exec(ctx, input)
+}
+
+func (en *recordingEngine) Flush(ctx context.Context, w io.Writer) (int, error) {
+	var b bytes.Buffer
+	c, err := en.Engine.Flush(ctx, io.MultiWriter(w, &b))
+	if err != nil {
+		return c, err
+	}
+	en.rec.Record(en.input, b.Bytes())
+	en.input = nil
+	return c, nil
+}
+
+// Middleware returns a middleware that records the input of each request, together with the output written for it.
+//
+// It should be the first middleware of the handler, so that responses short-circuited by other middleware are recorded too.
+func (r *Recorder) Middleware() handlers.Middleware {
+	return func(h handlers.RequestHandler) handlers.RequestHandler {
+		return &recordingHandler{
+			RequestHandler: h,
+			rec:            r,
+		}
+	}
+}
+
+type recordingHandler struct {
+	handlers.RequestHandler
+	rec   *Recorder
+	input []byte
+}
+
+func (rh *recordingHandler) Process(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+	rh.input = append([]byte{}, rqs.Input...)
+	return rh.RequestHandler.Process(rqs)
+}
+
+func (rh *recordingHandler) Output(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+	var b bytes.Buffer
+	w := rqs.Writer
+	rqs.Writer = io.MultiWriter(w, &b)
+	rqs, err := rh.RequestHandler.Output(rqs)
+	rqs.Writer = w
+	if err != nil {
+		logg.DebugCtxf(rqs.Ctx, "output not recorded", "err", err)
+		return rqs, err
+	}
+	rh.rec.Record(rh.input, b.Bytes())
+	return rqs, nil
+}
@@ -0,0 +1,331 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path"
+	"strings"
+	"testing"
+
+	"git.defalsify.org/vise.git/engine"
+	"git.defalsify.org/vise.git/persist"
+	"git.defalsify.org/vise.git/resource"
+
+	"git.grassecon.net/urdt/ussd/internal/handlers"
+	"git.grassecon.net/urdt/ussd/internal/testutil/driver"
+)
+
+const sessionId = "+254712345678"
+
+// testEngine renders the output set for the last input on flush.
+type testEngine struct {
+	outputs map[string]string
+	input   string
+}
+
+func (en *testEngine) Init(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+func (en *testEngine) Exec(ctx context.Context, input []byte) (bool, error) {
+	en.input = string(input)
+	return true, nil
+}
+
+func (en *testEngine) Flush(ctx context.Context, w io.Writer) (int, error) {
+	return io.WriteString(w, en.outputs[en.input])
+}
+
+func (en *testEngine) Finish() error {
+	return nil
+}
+
+// testHandler runs the test engine for the input of the request.
+type testHandler struct {
+	en *testEngine
+}
+
+func (h *testHandler) GetConfig() engine.Config {
+	return engine.Config{}
+}
+
+func (h *testHandler) GetRequestParser() handlers.RequestParser {
+	return nil
+}
+
+func (h *testHandler) GetEngine(cfg engine.Config, rs resource.Resource, pe *persist.Persister) engine.Engine {
+	return nil
+}
+
+func (h *testHandler) Process(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+	rqs.Engine = h.en
+	_, err := h.en.Exec(rqs.Ctx, rqs.Input)
+	return rqs, err
+}
+
+func (h *testHandler) Output(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+	_, err := rqs.Engine.Flush(rqs.Ctx, rqs.Writer)
+	return rqs, err
+}
+
+func (h *testHandler) Reset(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+	return rqs, nil
+}
+
+func (h *testHandler) Shutdown() {
+}
+
+func newTestEngine() *testEngine {
+	return &testEngine{
+		outputs: map[string]string{
+			"":  "Balance: 1.50 SRF\n\n1:Send\n9:Quit\n",
+			"1": "Maximum amount: 1.50\nEnter amount:\n0:Back",
+			"2": "+254712345678 will receive 0.50 SRF from 0x5523058cdFfe5F3c1EaDADD5015E55C6E00fb439",
+		},
+	}
+}
+
+func TestRecord(t *testing.T) {
+	tests := []struct {
+		name         string
+		placeholders bool
+		expected     []string
+	}{
+		{
+			name: "verbatim",
+			expected: []string{
+				"Balance: 1.50 SRF\n\n1:Send\n9:Quit",
+				"Maximum amount: 1.50\nEnter amount:\n0:Back",
+				"+254712345678 will receive 0.50 SRF from 0x5523058cdFfe5F3c1EaDADD5015E55C6E00fb439",
+			},
+		},
+		{
+			name:         "placeholders",
+			placeholders: true,
+			expected: []string{
+				"Balance: {balance}\n\n1:Send\n9:Quit",
+				"Maximum amount: {max_amount}\nEnter amount:\n0:Back",
+				"{session_id} will receive {send_amount} from {public_key}",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := NewRecorder(sessionId, "send")
+			if tt.placeholders {
+				rec = rec.WithPlaceholders(DefaultPlaceholders())
+			}
+			for _, input := range []string{"", "1", "2"} {
+				rec.Record([]byte(input), []byte(newTestEngine().outputs[input]))
+			}
+			steps := rec.Session().Groups[0].Steps
+			if len(steps) != len(tt.expected) {
+				t.Fatalf("expected %d steps, got %d", len(tt.expected), len(steps))
+			}
+			for i, step := range steps {
+				if step.ExpectedContent != tt.expected[i] {
+					t.Fatalf("step %d: expected '%s', got '%s'", i, tt.expected[i], step.ExpectedContent)
+				}
+			}
+		})
+	}
+}
+
+func TestWrapEngine(t *testing.T) {
+	ctx := context.Background()
+	rec := NewRecorder(sessionId, "send")
+	en := rec.WrapEngine(newTestEngine())
+
+	for _, input := range []string{"", "1"} {
+		_, err := en.Exec(ctx, []byte(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := bytes.NewBuffer(nil)
+		_, err = en.Flush(ctx, w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the output still reaches the writer
+		if w.String() != newTestEngine().outputs[input] {
+			t.Fatalf("unexpected output '%s'", w.String())
+		}
+	}
+
+	steps := rec.Session().Groups[0].Steps
+	if len(steps) != 2 {
+		t.Fatalf("expected 2 steps, got %d", len(steps))
+	}
+	if steps[1].Input != "1" || steps[1].ExpectedContent != "Maximum amount: 1.50\nEnter amount:\n0:Back" {
+		t.Fatalf("unexpected step %v", steps[1])
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	rec := NewRecorder(sessionId, "send")
+	rh := handlers.WithMiddleware(&testHandler{en: newTestEngine()}, rec.Middleware())
+
+	for _, input := range []string{"", "1"} {
+		w := bytes.NewBuffer(nil)
+		rqs := handlers.RequestSession{
+			Ctx:    context.Background(),
+			Input:  []byte(input),
+			Writer: w,
+		}
+		rqs, err := rh.Process(rqs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rqs, err = rh.Output(rqs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rqs.Writer != w {
+			t.Fatal("writer of the request not restored")
+		}
+		if w.String() != newTestEngine().outputs[input] {
+			t.Fatalf("unexpected output '%s'", w.String())
+		}
+	}
+
+	steps := rec.Session().Groups[0].Steps
+	if len(steps) != 2 {
+		t.Fatalf("expected 2 steps, got %d", len(steps))
+	}
+	if steps[0].Input != "" || steps[0].ExpectedContent != "Balance: 1.50 SRF\n\n1:Send\n9:Quit" {
+		t.Fatalf("unexpected step %v", steps[0])
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	fp := path.Join(dir, "send_with_invite.json")
+	rec := NewRecorder(sessionId, GroupName(fp))
+
+	// nothing is written without steps
+	err := rec.WriteFile(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = driver.LoadTestGroups(fp)
+	if err == nil {
+		t.Fatal("expected no file without steps")
+	}
+
+	rec.Record([]byte(""), []byte("Balance: 1.50 SRF\n"))
+	err = rec.WriteFile(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, err := driver.LoadTestGroups(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := driver.FilterGroupsByName(groups.Groups, "send_with_invite")
+	if len(group) != 1 {
+		t.Fatalf("expected the recorded group, got %v", groups.Groups)
+	}
+	if len(group[0].Steps) != 1 || group[0].Steps[0].ExpectedContent != "Balance: 1.50 SRF" {
+		t.Fatalf("unexpected steps %v", group[0].Steps)
+	}
+}
+
+func TestRecordMenuSeparator(t *testing.T) {
+	rec := NewRecorder(sessionId, "send").WithMenuSeparator(": ")
+	rec.Record([]byte(""), []byte("Balance: 1.50 SRF\n\n1: Send\n9: Quit\n"))
+	steps := rec.Session().Groups[0].Steps
+	if steps[0].ExpectedContent != "Balance: 1.50 SRF\n\n1:Send\n9:Quit" {
+		t.Fatalf("unexpected content '%s'", steps[0].ExpectedContent)
+	}
+}
+
+// fill replaces the placeholders in the expected content with the values they match in the output, as the menu traversal tests do.
+func fill(expected string, output []byte) string {
+	for _, p := range DefaultPlaceholders() {
+		match := p.Pattern.FindSubmatch(output)
+		if match == nil {
+			continue
+		}
+		v := match[0]
+		if len(match) > 1 {
+			v = match[1]
+		}
+		expected = strings.ReplaceAll(expected, "{"+p.Name+"}", string(v))
+	}
+	return strings.ReplaceAll(expected, "{session_id}", sessionId)
+}
+
+func TestReplay(t *testing.T) {
+	ctx := context.Background()
+	fp := path.Join(t.TempDir(), "send.json")
+
+	// the session is recorded from a frontend rendering the menu with another separator
+	rec := NewRecorder(sessionId, GroupName(fp)).WithMenuSeparator(": ").WithPlaceholders(DefaultPlaceholders())
+	recorded := &testEngine{
+		outputs: map[string]string{
+			"":  "Balance: 1.50 SRF\n\n1: Send\n9: Quit\n",
+			"1": "Maximum amount: 1.50\nEnter amount:\n0: Back",
+			"2": "+254712345678 will receive 0.50 SRF from 0x5523058cdFfe5F3c1EaDADD5015E55C6E00fb439",
+		},
+	}
+	rh := handlers.WithMiddleware(&testHandler{en: recorded}, rec.Middleware())
+	for _, input := range []string{"", "1", "2"} {
+		rqs, err := rh.Process(handlers.RequestSession{
+			Ctx:    ctx,
+			Input:  []byte(input),
+			Writer: bytes.NewBuffer(nil),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = rh.Output(rqs)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := rec.WriteFile(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the replay renders other values, with the separator of the test engine
+	replay := &testEngine{
+		outputs: map[string]string{
+			"":  "Balance: 2.25 SRF\n\n1:Send\n9:Quit\n",
+			"1": "Maximum amount: 2.25\nEnter amount:\n0:Back",
+			"2": "+254712345678 will receive 1.00 SRF from 0x0000000000000000000000000000000000000001",
+		},
+	}
+	groups, err := driver.LoadTestGroups(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := driver.CreateTestCases(driver.DataGroup{
+		Groups: driver.FilterGroupsByName(groups.Groups, "send"),
+	})
+	if len(tests) != 3 {
+		t.Fatalf("expected 3 steps, got %d", len(tests))
+	}
+	for _, tt := range tests {
+		_, err := replay.Exec(ctx, []byte(tt.Input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := bytes.NewBuffer(nil)
+		_, err = replay.Flush(ctx, w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := w.Bytes()
+		tt.ExpectedContent = fill(tt.ExpectedContent, b)
+		match, err := tt.MatchesExpectedContent(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !match {
+			t.Fatalf("input '%s': expected:\n\t%s\ngot:\n\t%s", tt.Input, tt.ExpectedContent, b)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+
+	"git.defalsify.org/vise.git/db"
+	"git.defalsify.org/vise.git/engine"
+	"git.defalsify.org/vise.git/persist"
+	"git.defalsify.org/vise.git/resource"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/config"
+	"git.grassecon.net/urdt/ussd/internal/handlers"
+	"git.grassecon.net/urdt/ussd/internal/sms"
+	"git.grassecon.net/urdt/ussd/internal/storage"
+	"git.grassecon.net/urdt/ussd/remote"
+)
+
+// Base holds what the routes share with the default menu of the server.
+type Base struct {
+	// Conn is the connection of the stores.
+	Conn storage.ConnData
+	// Config is the engine configuration of the default menu. The root and language are replaced by those of the route.
+	Config engine.Config
+	// UserdataStore is shared by all routes, so that the account of a subscriber is the same whichever code they dial.
+	UserdataStore  db.Db
+	RequestParser  handlers.RequestParser
+	AccountService remote.AccountServiceInterface
+	// SmsQueue is the queue of outbound SMS shared by all routes, if any.
+	SmsQueue *sms.Queue
+}
+
+// Router is a request handler passing each request on to the handler of the route matching its service and network code.
+//
+// Requests not matching any route are passed on to the default handler.
+type Router struct {
+	table       Table
+	fallback    handlers.RequestHandler
+	routes      map[string]handlers.RequestHandler
+	stateStores map[string]db.Db
+	closers     []func() error
+}
+
+// NewRouter creates a new Router, creating the request handlers of the routes in the table from base.
+func NewRouter(ctx context.Context, table Table, fallback handlers.RequestHandler, base Base) (*Router, error) {
+	rt := &Router{
+		table:       table,
+		fallback:    fallback,
+		routes:      make(map[string]handlers.RequestHandler),
+		stateStores: make(map[string]db.Db),
+	}
+	for _, route := range table {
+		h, err := rt.newHandler(ctx, route, base)
+		if err != nil {
+			rt.Close()
+			return nil, fmt.Errorf("route %s: %v", route.Name, err)
+		}
+		rt.routes[route.Name] = h
+		logg.InfoCtxf(ctx, "route added", "name", route.Name, "serviceCode", route.ServiceCode, "networkCode", route.NetworkCode, "resourceDir", route.ResourceDir, "root", route.Root)
+	}
+	return rt, nil
+}
+
+// newHandler creates the request handler of the route, with its own resource, flags and session state.
+//
+// Each request of the route is processed by menu handlers of its own, so that overlapping requests do not share them.
+func (rt *Router) newHandler(ctx context.Context, route Route, base Base) (handlers.RequestHandler, error) {
+	cfg := base.Config
+	cfg.Root = route.Root
+	cfg.Language = route.Language
+
+	ms := storage.NewMenuStorageService(base.Conn, route.ResourceDir).WithStateNamespace(route.Name)
+	rt.closers = append(rt.closers, ms.Close)
+	rs, err := ms.GetResource(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dbResource, ok := rs.(*resource.DbResource)
+	if !ok {
+		return nil, fmt.Errorf("unexpected resource type %T", rs)
+	}
+	stateStore, err := ms.GetStateStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rt.stateStores[route.Name] = stateStore
+
+	lhs, err := handlers.NewLocalHandlerService(ctx, route.FlagFile, true, dbResource, cfg, rs)
+	if err != nil {
+		return nil, err
+	}
+	lhs.SetDataStore(&base.UserdataStore)
+	if base.SmsQueue != nil {
+		lhs.SetSmsQueue(base.SmsQueue)
+	}
+	newHandler := handlers.NewMenuHandlerFactory(ms, lhs, base.AccountService, stateStore, base.UserdataStore, base.RequestParser)
+	// one handler is created up front, so that a route that cannot be served fails on startup
+	_, err = newHandler(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handlers.NewFactorySessionHandler(cfg, base.RequestParser, newHandler), nil
+}
+
+// Close closes the stores opened for the routes.
+func (rt *Router) Close() error {
+	var errs []error
+	for _, fn := range rt.closers {
+		err := fn()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	rt.closers = nil
+	if len(errs) > 0 {
+		return fmt.Errorf("route stores close failed: %v", errs)
+	}
+	return nil
+}
+
+// handler returns the route and request handler for the request, falling back to the default handler.
+func (rt *Router) handler(ctx context.Context) (*Route, handlers.RequestHandler) {
+	rc, _ := common.GetRequestContext(ctx)
+	route := rt.table.Match(rc.ServiceCode, rc.NetworkCode)
+	if route == nil {
+		return nil, rt.fallback
+	}
+	return route, rt.routes[route.Name]
+}
+
+// GetStateStore implements handlers.StateResolver.
+//
+// It returns the state store of the route matching the request, or nil if the request is passed on to the default handler.
+func (rt *Router) GetStateStore(ctx context.Context) db.Db {
+	route, _ := rt.handler(ctx)
+	if route == nil {
+		return nil
+	}
+	return rt.stateStores[route.Name]
+}
+
+func (rt *Router) GetConfig() engine.Config {
+	return rt.fallback.GetConfig()
+}
+
+func (rt *Router) GetRequestParser() handlers.RequestParser {
+	return rt.fallback.GetRequestParser()
+}
+
+func (rt *Router) GetEngine(cfg engine.Config, rs resource.Resource, pe *persist.Persister) engine.Engine {
+	return rt.fallback.GetEngine(cfg, rs, pe)
+}
+
+// Process passes the request on to the handler of the matching route, with the engine configuration and language of the route.
+func (rt *Router) Process(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+	route, h := rt.handler(rqs.Ctx)
+	if route != nil {
+		cfg := h.GetConfig()
+		cfg.SessionId = rqs.Config.SessionId
+		cfg.EngineDebug = rqs.Config.EngineDebug
+		rqs.Config = cfg
+		rc, _ := common.GetRequestContext(rqs.Ctx)
+		rc.Language = route.Language
+		if rc.Language == "" {
+			rc.Language = config.DefaultLanguage
+		}
+		rqs.Ctx = common.WithRequestContext(rqs.Ctx, rc)
+		logg.DebugCtxf(rqs.Ctx, "request routed", "route", route.Name, "sessionId", rqs.Config.SessionId)
+	}
+	return h.Process(rqs)
+}
+
+func (rt *Router) Output(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+	_, h := rt.handler(rqs.Ctx)
+	return h.Output(rqs)
+}
+
+func (rt *Router) Reset(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+	_, h := rt.handler(rqs.Ctx)
+	return h.Reset(rqs)
+}
+
+// Shutdown shuts down the default handler.
+//
+// The handlers of the routes are not shut down, since they share the userdata store of the default handler. Their own stores are closed with Close.
+func (rt *Router) Shutdown() {
+	rt.fallback.Shutdown()
+}
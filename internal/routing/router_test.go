@@ -0,0 +1,126 @@
+package routing
+
+import (
+	"context"
+	"testing"
+
+	"git.defalsify.org/vise.git/db"
+	memdb "git.defalsify.org/vise.git/db/mem"
+	"git.defalsify.org/vise.git/engine"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/handlers"
+	"git.grassecon.net/urdt/ussd/internal/testutil/mocks/httpmocks"
+)
+
+// testHandler records the request sessions it processes.
+func testHandler(root string, got *[]handlers.RequestSession) *httpmocks.MockRequestHandler {
+	return &httpmocks.MockRequestHandler{
+		GetConfigFunc: func() engine.Config {
+			return engine.Config{
+				Root: root,
+			}
+		},
+		ProcessFunc: func(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+			*got = append(*got, rqs)
+			return rqs, nil
+		},
+		OutputFunc: func(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+			return rqs, nil
+		},
+		ResetFunc: func(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+			*got = append(*got, rqs)
+			return rqs, nil
+		},
+	}
+}
+
+func TestRouter(t *testing.T) {
+	var fallbackGot []handlers.RequestSession
+	var merchantGot []handlers.RequestSession
+	rt := &Router{
+		table: Table{
+			{Name: "merchant", ServiceCode: "*384*97#", Root: "merchant_root", Language: "swa"},
+		},
+		fallback: testHandler("root", &fallbackGot),
+		routes:   make(map[string]handlers.RequestHandler),
+	}
+	rt.routes["merchant"] = testHandler("merchant_root", &merchantGot)
+
+	run := func(serviceCode string) {
+		rqs := handlers.RequestSession{
+			Ctx: common.WithRequestContext(context.Background(), common.RequestContext{
+				ServiceCode: serviceCode,
+				Language:    "eng",
+			}),
+			Config: rt.GetConfig(),
+		}
+		rqs.Config.SessionId = "+254712345678"
+		rqs, err := rt.Process(rqs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = rt.Reset(rqs)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run("*384*97#")
+	if len(merchantGot) != 2 || len(fallbackGot) != 0 {
+		t.Fatalf("expected request on merchant route, got %d/%d", len(merchantGot), len(fallbackGot))
+	}
+	rqs := merchantGot[0]
+	if rqs.Config.Root != "merchant_root" {
+		t.Errorf("expected root 'merchant_root', got '%s'", rqs.Config.Root)
+	}
+	if rqs.Config.SessionId != "+254712345678" {
+		t.Errorf("expected session id to be kept, got '%s'", rqs.Config.SessionId)
+	}
+	if common.LanguageCodeFromContext(rqs.Ctx) != "swa" {
+		t.Errorf("expected language 'swa', got '%s'", common.LanguageCodeFromContext(rqs.Ctx))
+	}
+
+	run("*384*96#")
+	if len(fallbackGot) != 2 || len(merchantGot) != 2 {
+		t.Fatalf("expected request on default handler, got %d/%d", len(merchantGot), len(fallbackGot))
+	}
+	rqs = fallbackGot[0]
+	if rqs.Config.Root != "root" {
+		t.Errorf("expected root 'root', got '%s'", rqs.Config.Root)
+	}
+	if common.LanguageCodeFromContext(rqs.Ctx) != "eng" {
+		t.Errorf("expected language 'eng', got '%s'", common.LanguageCodeFromContext(rqs.Ctx))
+	}
+}
+
+func TestRouterStateStore(t *testing.T) {
+	var got []handlers.RequestSession
+	merchantStore := memdb.NewMemDb()
+	rt := &Router{
+		table: Table{
+			{Name: "merchant", ServiceCode: "*384*97#"},
+		},
+		fallback:    testHandler("root", &got),
+		routes:      make(map[string]handlers.RequestHandler),
+		stateStores: map[string]db.Db{"merchant": merchantStore},
+	}
+	rt.routes["merchant"] = testHandler("merchant_root", &got)
+	defaultStore := memdb.NewMemDb()
+
+	ctx := common.WithRequestContext(context.Background(), common.RequestContext{
+		ServiceCode: "*384*97#",
+	})
+	if handlers.ResolveStateStore(ctx, rt, defaultStore) != merchantStore {
+		t.Fatalf("expected state store of the merchant route")
+	}
+	ctx = common.WithRequestContext(context.Background(), common.RequestContext{
+		ServiceCode: "*384*96#",
+	})
+	if rt.GetStateStore(ctx) != nil {
+		t.Fatalf("expected no state store for unrouted request")
+	}
+	if handlers.ResolveStateStore(ctx, rt, defaultStore) != defaultStore {
+		t.Fatalf("expected default state store for unrouted request")
+	}
+}
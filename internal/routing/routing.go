@@ -0,0 +1,134 @@
+package routing
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"git.defalsify.org/vise.git/logging"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("routing")
+)
+
+var (
+	routeNameRe = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+)
+
+// Route maps the sessions of a service code, a network or both to a menu tree.
+type Route struct {
+	// Name identifies the route, and namespaces the state of its sessions. It must be lowercase alphanumeric.
+	Name string `json:"name"`
+	// ServiceCode is the USSD code dialed, e.g. "*384*96#". Empty matches any service code.
+	ServiceCode string `json:"service_code"`
+	// NetworkCode is the code of the mobile network as sent by the gateway, e.g. "63902". Empty matches any network.
+	NetworkCode string `json:"network_code"`
+	// Root is the symbol of the node sessions start at, "root" if empty.
+	Root string `json:"root"`
+	// ResourceDir is the directory of the compiled menu tree.
+	ResourceDir string `json:"resource_dir"`
+	// Language is the ISO 639 code of the default language of the menu, the default language of the server if empty.
+	Language string `json:"language"`
+	// FlagFile is the flag definition file of the menu tree, pp.csv in the resource directory if empty.
+	FlagFile string `json:"flag_file"`
+}
+
+// specificity ranks the routes matching a request, routes matching on both codes ahead of routes matching on the service code, and those ahead of routes matching on the network.
+func (rt *Route) specificity() int {
+	var v int
+	if rt.ServiceCode != "" {
+		v += 2
+	}
+	if rt.NetworkCode != "" {
+		v += 1
+	}
+	return v
+}
+
+func (rt *Route) matches(serviceCode string, networkCode string) bool {
+	if rt.ServiceCode != "" && rt.ServiceCode != normalizeCode(serviceCode) {
+		return false
+	}
+	if rt.NetworkCode != "" && rt.NetworkCode != normalizeCode(networkCode) {
+		return false
+	}
+	return true
+}
+
+// Table is the set of routes served by a process.
+type Table []Route
+
+// LoadTable reads a routing table from a JSON file holding a list of routes.
+func LoadTable(fp string) (Table, error) {
+	var t Table
+	b, err := os.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &t)
+	if err != nil {
+		return nil, fmt.Errorf("routing table %s: %v", fp, err)
+	}
+	err = t.normalize()
+	if err != nil {
+		return nil, fmt.Errorf("routing table %s: %v", fp, err)
+	}
+	return t, nil
+}
+
+// normalize checks the routes and fills in the defaults.
+func (t Table) normalize() error {
+	names := make(map[string]bool)
+	matches := make(map[string]string)
+	for i := range t {
+		rt := &t[i]
+		if !routeNameRe.MatchString(rt.Name) {
+			return fmt.Errorf("invalid route name '%s'", rt.Name)
+		}
+		if names[rt.Name] {
+			return fmt.Errorf("duplicate route name '%s'", rt.Name)
+		}
+		names[rt.Name] = true
+		if rt.ResourceDir == "" {
+			return fmt.Errorf("route %s: missing resource dir", rt.Name)
+		}
+		rt.ServiceCode = normalizeCode(rt.ServiceCode)
+		rt.NetworkCode = normalizeCode(rt.NetworkCode)
+		k := rt.ServiceCode + "/" + rt.NetworkCode
+		other, ok := matches[k]
+		if ok {
+			return fmt.Errorf("route %s: same service and network code as route %s", rt.Name, other)
+		}
+		matches[k] = rt.Name
+		if rt.Root == "" {
+			rt.Root = "root"
+		}
+		if rt.FlagFile == "" {
+			rt.FlagFile = path.Join(rt.ResourceDir, "pp.csv")
+		}
+	}
+	return nil
+}
+
+// Match returns the most specific route for the service and network code, or nil if no route matches.
+func (t Table) Match(serviceCode string, networkCode string) *Route {
+	var match *Route
+	for i := range t {
+		rt := &t[i]
+		if !rt.matches(serviceCode, networkCode) {
+			continue
+		}
+		if match == nil || rt.specificity() > match.specificity() {
+			match = rt
+		}
+	}
+	return match
+}
+
+func normalizeCode(s string) string {
+	return strings.ReplaceAll(s, " ", "")
+}
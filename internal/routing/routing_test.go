@@ -0,0 +1,115 @@
+package routing
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestLoadTable(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          string
+		expectedError bool
+	}{
+		{
+			name: "Valid table",
+			data: `[
+				{"name": "community", "service_code": "*384*96#", "resource_dir": "services/registration"},
+				{"name": "pilot", "service_code": "*384*96#", "network_code": "63902", "resource_dir": "services/pilot", "root": "pilot_root"}
+			]`,
+		},
+		{
+			name:          "Invalid name",
+			data:          `[{"name": "Community Menu", "resource_dir": "services/registration"}]`,
+			expectedError: true,
+		},
+		{
+			name: "Duplicate name",
+			data: `[
+				{"name": "community", "service_code": "*384*96#", "resource_dir": "services/registration"},
+				{"name": "community", "service_code": "*384*97#", "resource_dir": "services/registration"}
+			]`,
+			expectedError: true,
+		},
+		{
+			name: "Duplicate codes",
+			data: `[
+				{"name": "community", "service_code": "*384*96#", "resource_dir": "services/registration"},
+				{"name": "merchant", "service_code": "*384*96# ", "resource_dir": "services/merchant"}
+			]`,
+			expectedError: true,
+		},
+		{
+			name:          "Missing resource dir",
+			data:          `[{"name": "community", "service_code": "*384*96#"}]`,
+			expectedError: true,
+		},
+		{
+			name:          "Invalid json",
+			data:          `{"name": "community"}`,
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fp := path.Join(t.TempDir(), "routes.json")
+			err := os.WriteFile(fp, []byte(tt.data), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			table, err := LoadTable(fp)
+			if tt.expectedError {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if table[0].Root != "root" {
+				t.Errorf("expected default root, got '%s'", table[0].Root)
+			}
+			if table[0].FlagFile != "services/registration/pp.csv" {
+				t.Errorf("expected default flag file, got '%s'", table[0].FlagFile)
+			}
+			if table[1].Root != "pilot_root" {
+				t.Errorf("expected root 'pilot_root', got '%s'", table[1].Root)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	table := Table{
+		{Name: "community", ServiceCode: "*384*96#"},
+		{Name: "pilot", ServiceCode: "*384*96#", NetworkCode: "63902"},
+		{Name: "merchant", ServiceCode: "*384*97#"},
+		{Name: "network", NetworkCode: "63903"},
+	}
+	tests := []struct {
+		serviceCode string
+		networkCode string
+		expected    string
+	}{
+		{"*384*96#", "63901", "community"},
+		{"*384*96#", "63902", "pilot"},
+		{"*384*97#", "63902", "merchant"},
+		{"*384*97#", "63903", "merchant"},
+		{"*384*98#", "63903", "network"},
+		{"*384*98#", "63901", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		route := table.Match(tt.serviceCode, tt.networkCode)
+		name := ""
+		if route != nil {
+			name = route.Name
+		}
+		if name != tt.expected {
+			t.Errorf("%s/%s: expected route '%s', got '%s'", tt.serviceCode, tt.networkCode, tt.expected, name)
+		}
+	}
+}
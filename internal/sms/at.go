@@ -0,0 +1,93 @@
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ATSender sends messages through the Africa's Talking bulk SMS API.
+type ATSender struct {
+	// URL is the messaging endpoint of the API.
+	URL      string
+	Username string
+	ApiKey   string
+	// From is the sender id or short code, the default of the account if empty.
+	From   string
+	Client *http.Client
+}
+
+type atResponse struct {
+	SMSMessageData struct {
+		Message    string `json:"Message"`
+		Recipients []struct {
+			Number     string `json:"number"`
+			Status     string `json:"status"`
+			StatusCode int    `json:"statusCode"`
+			MessageId  string `json:"messageId"`
+		} `json:"Recipients"`
+	} `json:"SMSMessageData"`
+}
+
+// Send implements Sender.
+//
+// Messages refused for the recipient, such as an invalid or blacklisted number, fail with ErrRejected.
+func (as *ATSender) Send(ctx context.Context, msg Message) (string, error) {
+	v := url.Values{}
+	v.Set("username", as.Username)
+	v.Set("to", msg.Recipient)
+	v.Set("message", msg.Text)
+	if as.From != "" {
+		v.Set("from", as.From)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, as.URL, strings.NewReader(v.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("apiKey", as.ApiKey)
+
+	client := as.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return "", fmt.Errorf("sms api error: %s", resp.Status)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return "", fmt.Errorf("%w: %s: %s", ErrRejected, resp.Status, strings.TrimSpace(string(b)))
+	}
+
+	var r atResponse
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return "", fmt.Errorf("invalid sms api response: %v", err)
+	}
+	if len(r.SMSMessageData.Recipients) == 0 {
+		return "", fmt.Errorf("%w: %s", ErrRejected, r.SMSMessageData.Message)
+	}
+	rcpt := r.SMSMessageData.Recipients[0]
+	switch rcpt.StatusCode {
+	// Processed, Sent, Queued
+	case 100, 101, 102:
+		return rcpt.MessageId, nil
+	// InvalidPhoneNumber, UnsupportedNumberType, UserInBlacklist, CouldNotRoute, DoNotDisturbRejection
+	case 403, 404, 406, 407, 409:
+		return "", fmt.Errorf("%w: %s", ErrRejected, rcpt.Status)
+	}
+	return "", fmt.Errorf("sms not sent: %s", rcpt.Status)
+}
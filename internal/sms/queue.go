@@ -0,0 +1,217 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	StatusSent   = "sent"
+	StatusFailed = "failed"
+)
+
+var (
+	ErrQueueFull   = errors.New("sms queue full")
+	ErrQueueClosed = errors.New("sms queue closed")
+)
+
+// Result is the outcome of sending a queued message.
+type Result struct {
+	Status string
+	// MessageId is the id the provider assigned to the message, if it was sent.
+	MessageId string
+	// Attempts is the number of times sending was tried.
+	Attempts int
+	Err      error
+	Time     time.Time
+}
+
+// Recorder is notified of the final result of each queued message.
+type Recorder interface {
+	Record(ctx context.Context, msg Message, res Result) error
+}
+
+// Enqueuer accepts messages to be sent in the background.
+type Enqueuer interface {
+	Enqueue(ctx context.Context, msg Message) error
+}
+
+type job struct {
+	msg      Message
+	attempts int
+}
+
+// Queue sends messages in the background, retrying failed sends with exponential backoff.
+type Queue struct {
+	sender  Sender
+	rec     Recorder
+	ch      chan job
+	retries int
+	delay   time.Duration
+	mu      sync.RWMutex
+	closed  bool
+	timers  map[*time.Timer]job
+	done    chan struct{}
+	started bool
+	ctx     context.Context
+}
+
+// NewQueue creates a new Queue holding up to size messages waiting to be sent.
+//
+// By default, sending is not retried.
+func NewQueue(sender Sender, size int) *Queue {
+	if size < 1 {
+		size = 1
+	}
+	return &Queue{
+		sender: sender,
+		ch:     make(chan job, size),
+		timers: make(map[*time.Timer]job),
+		done:   make(chan struct{}),
+		ctx:    context.Background(),
+	}
+}
+
+// WithRetry sets the number of retries after a failed send, and the delay before the first retry. The delay doubles with each retry.
+func (q *Queue) WithRetry(retries int, delay time.Duration) *Queue {
+	q.retries = retries
+	q.delay = delay
+	return q
+}
+
+// WithRecorder sets the recorder notified of the result of each message.
+func (q *Queue) WithRecorder(rec Recorder) *Queue {
+	q.rec = rec
+	return q
+}
+
+// Start starts sending queued messages.
+func (q *Queue) Start(ctx context.Context) {
+	q.ctx = ctx
+	q.started = true
+	go q.run()
+}
+
+// Enqueue implements Enqueuer.
+//
+// It returns ErrQueueFull if the queue cannot take the message without blocking.
+func (q *Queue) Enqueue(ctx context.Context, msg Message) error {
+	err := q.push(job{msg: msg})
+	if err != nil {
+		return err
+	}
+	logg.DebugCtxf(ctx, "sms queued", "recipient", msg.Recipient, "sessionId", msg.SessionId)
+	return nil
+}
+
+func (q *Queue) push(j job) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	select {
+	case q.ch <- j:
+	default:
+		return ErrQueueFull
+	}
+	return nil
+}
+
+func (q *Queue) run() {
+	defer close(q.done)
+	for j := range q.ch {
+		q.send(j)
+	}
+}
+
+func (q *Queue) send(j job) {
+	j.attempts++
+	id, err := q.sender.Send(q.ctx, j.msg)
+	if err == nil {
+		q.record(j, Result{Status: StatusSent, MessageId: id})
+		return
+	}
+	logg.WarnCtxf(q.ctx, "sms send failed", "recipient", j.msg.Recipient, "attempt", j.attempts, "err", err)
+	if errors.Is(err, ErrRejected) || j.attempts > q.retries {
+		q.record(j, Result{Status: StatusFailed, Err: err})
+		return
+	}
+	q.retry(j, err)
+}
+
+// retry puts the message back on the queue after the backoff delay of its attempt.
+func (q *Queue) retry(j job, lastErr error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		q.record(j, Result{Status: StatusFailed, Err: lastErr})
+		return
+	}
+	delay := q.delay << (j.attempts - 1)
+	var t *time.Timer
+	t = time.AfterFunc(delay, func() {
+		q.mu.Lock()
+		delete(q.timers, t)
+		q.mu.Unlock()
+		err := q.push(j)
+		if err != nil {
+			q.record(j, Result{Status: StatusFailed, Err: lastErr})
+		}
+	})
+	q.timers[t] = j
+}
+
+func (q *Queue) record(j job, res Result) {
+	res.Attempts = j.attempts
+	res.Time = time.Now()
+	if res.Status == StatusSent {
+		logg.InfoCtxf(q.ctx, "sms sent", "recipient", j.msg.Recipient, "sessionId", j.msg.SessionId, "id", res.MessageId, "attempts", res.Attempts)
+	} else {
+		logg.ErrorCtxf(q.ctx, "sms not sent", "recipient", j.msg.Recipient, "sessionId", j.msg.SessionId, "attempts", res.Attempts, "err", res.Err)
+	}
+	if q.rec == nil {
+		return
+	}
+	err := q.rec.Record(q.ctx, j.msg, res)
+	if err != nil {
+		logg.ErrorCtxf(q.ctx, "failed to record sms result", "recipient", j.msg.Recipient, "sessionId", j.msg.SessionId, "err", err)
+	}
+}
+
+// Close stops accepting messages and waits for the queued messages to be sent, then closes the sender if it is an io.Closer.
+//
+// Messages waiting for a retry are not tried again, and are recorded as failed.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	var pending []job
+	for t, j := range q.timers {
+		// Timers that already fired find the queue closed, and record the message themselves.
+		if t.Stop() {
+			pending = append(pending, j)
+			delete(q.timers, t)
+		}
+	}
+	close(q.ch)
+	q.mu.Unlock()
+
+	if q.started {
+		<-q.done
+	}
+	for _, j := range pending {
+		q.record(j, Result{Status: StatusFailed, Err: ErrQueueClosed})
+	}
+	c, ok := q.sender.(io.Closer)
+	if ok {
+		return c.Close()
+	}
+	return nil
+}
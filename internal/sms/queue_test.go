@@ -0,0 +1,181 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSender fails the first failures sends, then succeeds.
+type testSender struct {
+	mu       sync.Mutex
+	failures int
+	err      error
+	sent     []Message
+	calls    int
+}
+
+func (ts *testSender) Send(ctx context.Context, msg Message) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.calls++
+	if ts.calls <= ts.failures {
+		return "", ts.err
+	}
+	ts.sent = append(ts.sent, msg)
+	return "id", nil
+}
+
+type testRecorder struct {
+	mu      sync.Mutex
+	results []Result
+	c       chan struct{}
+}
+
+func newTestRecorder() *testRecorder {
+	return &testRecorder{
+		c: make(chan struct{}, 10),
+	}
+}
+
+func (tr *testRecorder) Record(ctx context.Context, msg Message, res Result) error {
+	tr.mu.Lock()
+	tr.results = append(tr.results, res)
+	tr.mu.Unlock()
+	tr.c <- struct{}{}
+	return nil
+}
+
+func (tr *testRecorder) wait(t *testing.T) Result {
+	select {
+	case <-tr.c:
+	case <-time.After(time.Second):
+		t.Fatal("no result recorded")
+	}
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return tr.results[len(tr.results)-1]
+}
+
+func TestQueueSend(t *testing.T) {
+	ctx := context.Background()
+	sender := &testSender{}
+	rec := newTestRecorder()
+	q := NewQueue(sender, 1).WithRecorder(rec)
+	q.Start(ctx)
+	defer q.Close()
+
+	err := q.Enqueue(ctx, Message{Recipient: "+254700000000", Text: "foo", SessionId: "+254711111111"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := rec.wait(t)
+	if res.Status != StatusSent || res.Attempts != 1 || res.MessageId != "id" {
+		t.Fatalf("unexpected result %v", res)
+	}
+}
+
+func TestQueueRetry(t *testing.T) {
+	ctx := context.Background()
+	sender := &testSender{failures: 2, err: errors.New("gateway timeout")}
+	rec := newTestRecorder()
+	q := NewQueue(sender, 1).WithRetry(2, time.Millisecond).WithRecorder(rec)
+	q.Start(ctx)
+	defer q.Close()
+
+	err := q.Enqueue(ctx, Message{Recipient: "+254700000000", Text: "foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := rec.wait(t)
+	if res.Status != StatusSent || res.Attempts != 3 {
+		t.Fatalf("unexpected result %v", res)
+	}
+}
+
+func TestQueueRetryExhausted(t *testing.T) {
+	ctx := context.Background()
+	sender := &testSender{failures: 3, err: errors.New("gateway timeout")}
+	rec := newTestRecorder()
+	q := NewQueue(sender, 1).WithRetry(1, time.Millisecond).WithRecorder(rec)
+	q.Start(ctx)
+	defer q.Close()
+
+	err := q.Enqueue(ctx, Message{Recipient: "+254700000000", Text: "foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := rec.wait(t)
+	if res.Status != StatusFailed || res.Attempts != 2 || res.Err == nil {
+		t.Fatalf("unexpected result %v", res)
+	}
+}
+
+func TestQueueRejectedNotRetried(t *testing.T) {
+	ctx := context.Background()
+	sender := &testSender{failures: 1, err: ErrRejected}
+	rec := newTestRecorder()
+	q := NewQueue(sender, 1).WithRetry(3, time.Millisecond).WithRecorder(rec)
+	q.Start(ctx)
+	defer q.Close()
+
+	err := q.Enqueue(ctx, Message{Recipient: "+254700000000", Text: "foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := rec.wait(t)
+	if res.Status != StatusFailed || res.Attempts != 1 {
+		t.Fatalf("unexpected result %v", res)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	ctx := context.Background()
+	q := NewQueue(&testSender{}, 1)
+
+	err := q.Enqueue(ctx, Message{Recipient: "+254700000000", Text: "foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = q.Enqueue(ctx, Message{Recipient: "+254700000001", Text: "foo"})
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+}
+
+func TestQueueClose(t *testing.T) {
+	ctx := context.Background()
+	sender := &testSender{failures: 1, err: errors.New("gateway timeout")}
+	rec := newTestRecorder()
+	q := NewQueue(sender, 1).WithRetry(1, time.Hour).WithRecorder(rec)
+	q.Start(ctx)
+
+	err := q.Enqueue(ctx, Message{Recipient: "+254700000000", Text: "foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// wait for the first attempt to fail and the retry to be scheduled
+	for i := 0; i < 100; i++ {
+		q.mu.RLock()
+		n := len(q.timers)
+		q.mu.RUnlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	err = q.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := rec.wait(t)
+	if res.Status != StatusFailed || !errors.Is(res.Err, ErrQueueClosed) {
+		t.Fatalf("unexpected result %v", res)
+	}
+	err = q.Enqueue(ctx, Message{Recipient: "+254700000000", Text: "foo"})
+	if !errors.Is(err, ErrQueueClosed) {
+		t.Fatalf("expected ErrQueueClosed, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+
+	"git.grassecon.net/urdt/ussd/common"
+)
+
+const (
+	// MaxResults is the number of send results kept for each user.
+	MaxResults = 10
+)
+
+// ResultEntry is the record of a sent message kept for the user it was sent on behalf of.
+type ResultEntry struct {
+	Recipient string    `json:"recipient"`
+	Status    string    `json:"status"`
+	MessageId string    `json:"message_id,omitempty"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// StoreRecorder records the results of messages against the user they were sent on behalf of, under DATA_SMS_RESULTS in the userdata store.
+//
+// The most recent MaxResults results are kept, newest first. Messages not sent on behalf of a user are not recorded.
+type StoreRecorder struct {
+	mu    sync.Mutex
+	store common.DataStore
+}
+
+// NewStoreRecorder creates a new StoreRecorder for the userdata store.
+func NewStoreRecorder(userdataStore db.Db) *StoreRecorder {
+	return &StoreRecorder{
+		store: &common.UserDataStore{
+			Db: userdataStore,
+		},
+	}
+}
+
+// Record implements Recorder.
+func (sr *StoreRecorder) Record(ctx context.Context, msg Message, res Result) error {
+	if msg.SessionId == "" {
+		return nil
+	}
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	entries, err := ReadResults(ctx, sr.store, msg.SessionId)
+	if err != nil {
+		return err
+	}
+	entry := ResultEntry{
+		Recipient: msg.Recipient,
+		Status:    res.Status,
+		MessageId: res.MessageId,
+		Attempts:  res.Attempts,
+		Time:      res.Time,
+	}
+	if res.Err != nil {
+		entry.Error = res.Err.Error()
+	}
+	entries = append([]ResultEntry{entry}, entries...)
+	if len(entries) > MaxResults {
+		entries = entries[:MaxResults]
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return sr.store.WriteEntry(ctx, msg.SessionId, common.DATA_SMS_RESULTS, b)
+}
+
+// ReadResults returns the send results recorded for the user, newest first.
+func ReadResults(ctx context.Context, store common.DataStore, sessionId string) ([]ResultEntry, error) {
+	var entries []ResultEntry
+	b, err := store.ReadEntry(ctx, sessionId, common.DATA_SMS_RESULTS)
+	if err != nil {
+		if db.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(b, &entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
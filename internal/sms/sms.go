@@ -0,0 +1,144 @@
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"git.defalsify.org/vise.git/logging"
+
+	"git.grassecon.net/urdt/ussd/config"
+)
+
+var (
+	logg = logging.NewVanilla().WithDomain("sms")
+)
+
+var (
+	// ErrRejected is returned by a sender when the provider refuses the message, e.g. for an invalid or blacklisted number. Rejected messages are not retried.
+	ErrRejected = errors.New("message rejected")
+)
+
+// Message is an outbound SMS.
+type Message struct {
+	// Recipient is the phone number of the recipient, in international format.
+	Recipient string `json:"recipient"`
+	Text      string `json:"text"`
+	// SessionId identifies the user the message is sent on behalf of, if any.
+	SessionId string `json:"session_id,omitempty"`
+}
+
+// Sender delivers messages to an SMS provider.
+type Sender interface {
+	// Send sends the message, returning the id the provider assigned to it.
+	Send(ctx context.Context, msg Message) (string, error)
+}
+
+// FileSender is a local stub sender, appending each message as a JSON line to a writer instead of sending it.
+type FileSender struct {
+	mu sync.Mutex
+	w  io.Writer
+	c  io.Closer
+	n  int
+}
+
+// NewFileSender creates a FileSender writing to w.
+func NewFileSender(w io.Writer) *FileSender {
+	return &FileSender{
+		w: w,
+	}
+}
+
+// OpenFileSender creates a FileSender appending to the file at fp, or writing to stdout if fp is "-" or empty.
+func OpenFileSender(fp string) (*FileSender, error) {
+	if fp == "" || fp == "-" {
+		return NewFileSender(os.Stdout), nil
+	}
+	f, err := os.OpenFile(fp, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	fs := NewFileSender(f)
+	fs.c = f
+	return fs, nil
+}
+
+// Send implements Sender.
+func (fs *FileSender) Send(ctx context.Context, msg Message) (string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.n++
+	id := fmt.Sprintf("local-%d", fs.n)
+	b, err := json.Marshal(struct {
+		Id   string    `json:"id"`
+		Time time.Time `json:"time"`
+		Message
+	}{
+		Id:      id,
+		Time:    time.Now(),
+		Message: msg,
+	})
+	if err != nil {
+		return "", err
+	}
+	_, err = fs.w.Write(append(b, '\n'))
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Close closes the file written to, if it was opened by OpenFileSender.
+func (fs *FileSender) Close() error {
+	if fs.c == nil {
+		return nil
+	}
+	return fs.c.Close()
+}
+
+// SenderFromConfig creates the sender of the SMS provider in the config settings.
+//
+// It returns nil if no provider is configured.
+func SenderFromConfig() (Sender, error) {
+	switch config.SmsProvider {
+	case "":
+		return nil, nil
+	case "file":
+		return OpenFileSender(config.SmsFile)
+	case "africastalking":
+		if config.AtSmsUsername == "" || config.AtSmsApiKey == "" {
+			return nil, fmt.Errorf("africastalking sms provider needs AT_SMS_USERNAME and AT_SMS_API_KEY")
+		}
+		return &ATSender{
+			URL:      config.AtSmsURL,
+			Username: config.AtSmsUsername,
+			ApiKey:   config.AtSmsApiKey,
+			From:     config.AtSmsFrom,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown sms provider '%s'", config.SmsProvider)
+}
+
+// QueueFromConfig creates and starts the queue of the SMS provider in the config settings, recording the send results in the userdata store.
+//
+// It returns nil if no provider is configured.
+func QueueFromConfig(ctx context.Context, rec Recorder) (*Queue, error) {
+	sender, err := SenderFromConfig()
+	if err != nil {
+		return nil, err
+	}
+	if sender == nil {
+		return nil, nil
+	}
+	q := NewQueue(sender, config.SmsQueueSize).WithRetry(config.SmsRetries, config.SmsRetryDelay)
+	if rec != nil {
+		q = q.WithRecorder(rec)
+	}
+	q.Start(ctx)
+	return q, nil
+}
@@ -0,0 +1,168 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	memdb "git.defalsify.org/vise.git/db/mem"
+
+	"git.grassecon.net/urdt/ussd/common"
+)
+
+func TestFileSender(t *testing.T) {
+	var b bytes.Buffer
+	fs := NewFileSender(&b)
+	id, err := fs.Send(context.Background(), Message{Recipient: "+254700000000", Text: "foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r struct {
+		Id        string `json:"id"`
+		Recipient string `json:"recipient"`
+		Text      string `json:"text"`
+	}
+	err = json.Unmarshal(b.Bytes(), &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Id != id || r.Recipient != "+254700000000" || r.Text != "foo" {
+		t.Fatalf("unexpected record %s", b.String())
+	}
+}
+
+func TestATSender(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		expectedId string
+		rejected   bool
+		fails      bool
+	}{
+		{
+			name:       "sent",
+			status:     http.StatusCreated,
+			body:       `{"SMSMessageData":{"Message":"Sent to 1/1","Recipients":[{"number":"+254700000000","status":"Success","statusCode":101,"messageId":"ATXid_1"}]}}`,
+			expectedId: "ATXid_1",
+		},
+		{
+			name:     "invalid number",
+			status:   http.StatusCreated,
+			body:     `{"SMSMessageData":{"Message":"Sent to 0/1","Recipients":[{"number":"+254700000000","status":"InvalidPhoneNumber","statusCode":403}]}}`,
+			rejected: true,
+		},
+		{
+			name:   "insufficient balance",
+			status: http.StatusCreated,
+			body:   `{"SMSMessageData":{"Message":"Sent to 0/1","Recipients":[{"number":"+254700000000","status":"InsufficientBalance","statusCode":405}]}}`,
+			fails:  true,
+		},
+		{
+			name:     "unauthorized",
+			status:   http.StatusUnauthorized,
+			body:     `The supplied authentication is invalid`,
+			rejected: true,
+		},
+		{
+			name:   "server error",
+			status: http.StatusInternalServerError,
+			fails:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.Header.Get("apiKey") != "secret" {
+					t.Errorf("missing api key")
+				}
+				err := req.ParseForm()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if req.PostForm.Get("username") != "sandbox" || req.PostForm.Get("to") != "+254700000000" || req.PostForm.Get("message") != "foo" {
+					t.Errorf("unexpected form %v", req.PostForm)
+				}
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer srv.Close()
+
+			as := &ATSender{
+				URL:      srv.URL,
+				Username: "sandbox",
+				ApiKey:   "secret",
+			}
+			id, err := as.Send(context.Background(), Message{Recipient: "+254700000000", Text: "foo"})
+			if tt.rejected {
+				if !errors.Is(err, ErrRejected) {
+					t.Fatalf("expected ErrRejected, got %v", err)
+				}
+				return
+			}
+			if tt.fails {
+				if err == nil || errors.Is(err, ErrRejected) {
+					t.Fatalf("expected retriable error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != tt.expectedId {
+				t.Fatalf("expected id %s, got %s", tt.expectedId, id)
+			}
+		})
+	}
+}
+
+func TestStoreRecorder(t *testing.T) {
+	ctx := context.Background()
+	db := memdb.NewMemDb()
+	err := db.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rec := NewStoreRecorder(db)
+	sessionId := "+254711111111"
+
+	for i := 0; i < MaxResults+2; i++ {
+		err = rec.Record(ctx, Message{Recipient: fmt.Sprintf("+2547000000%02d", i), SessionId: sessionId}, Result{
+			Status:   StatusSent,
+			Attempts: 1,
+			Time:     time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = rec.Record(ctx, Message{Recipient: "+254700000099", SessionId: sessionId}, Result{
+		Status:   StatusFailed,
+		Attempts: 3,
+		Err:      ErrRejected,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ReadResults(ctx, &common.UserDataStore{Db: db}, sessionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != MaxResults {
+		t.Fatalf("expected %d entries, got %d", MaxResults, len(entries))
+	}
+	if entries[0].Recipient != "+254700000099" || entries[0].Status != StatusFailed || entries[0].Error != ErrRejected.Error() {
+		t.Fatalf("unexpected latest entry %v", entries[0])
+	}
+	if entries[1].Recipient != fmt.Sprintf("+2547000000%02d", MaxResults+1) {
+		t.Fatalf("unexpected entry %v", entries[1])
+	}
+}
@@ -2,9 +2,14 @@ package ssh
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path"
+	"sort"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 
@@ -14,22 +19,135 @@ import (
 	dbstorage "git.grassecon.net/urdt/ussd/internal/storage/db/gdbm"
 )
 
+var (
+	ErrKeyNotFound = errors.New("ssh key not found")
+)
+
+// KeyEntry is a public key authorized to access the SSH frontend, and the session it maps to.
+type KeyEntry struct {
+	SessionId string `json:"session_id"`
+	Label string `json:"label,omitempty"`
+	Created time.Time `json:"created"`
+	// LastUsed is the time of the last completed SSH handshake with the key, zero if never used.
+	LastUsed time.Time `json:"last_used"`
+	// Fingerprint is the SHA256 fingerprint of the key, as shown by ssh-keygen -l. It is not stored.
+	Fingerprint string `json:"-"`
+	// Type is the algorithm of the key. It is not stored.
+	Type string `json:"-"`
+}
+
+// decodeKeyEntry parses a stored entry.
+//
+// Keys added before entries carried metadata are stored as the bare session id.
+func decodeKeyEntry(v []byte) (*KeyEntry, error) {
+	if len(v) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	entry := &KeyEntry{}
+	if v[0] != '{' {
+		entry.SessionId = string(v)
+		return entry, nil
+	}
+	err := json.Unmarshal(v, entry)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// SshKeyStore maps the public keys authorized to access the SSH frontend to session ids.
+//
+// The database is opened for each operation, so that keys can be managed with the sshkey command while the server is running.
 type SshKeyStore struct {
 	store db.Db
+	keyStoreFile string
+	mu sync.Mutex
 }
 
 func NewSshKeyStore(ctx context.Context, dbDir string) (*SshKeyStore, error) {
 	keyStore := &SshKeyStore{}
-	keyStoreFile := path.Join(dbDir, "ssh_authorized_keys.gdbm")
-	keyStore.store = dbstorage.NewThreadGdbmDb()
-	err := keyStore.store.Connect(ctx, keyStoreFile)
+	keyStore.keyStoreFile = path.Join(dbDir, "ssh_authorized_keys.gdbm")
+	err := keyStore.open(ctx)
 	if err != nil {
 		return nil, err
 	}
+	keyStore.close(ctx)
 	return keyStore, nil
 }
 
-func(s *SshKeyStore) AddFromFile(ctx context.Context, fp string, sessionId string) error {
+func(s *SshKeyStore) open(ctx context.Context) error {
+	s.store = dbstorage.NewThreadGdbmDb()
+	err := s.store.Connect(ctx, s.keyStoreFile)
+	if err != nil {
+		s.store = nil
+		return err
+	}
+	s.store.SetLanguage(nil)
+	s.store.SetPrefix(storage.DATATYPE_EXTEND)
+	return nil
+}
+
+func(s *SshKeyStore) close(ctx context.Context) {
+	if s.store == nil {
+		return
+	}
+	err := s.store.Close()
+	if err != nil {
+		logg.ErrorCtxf(ctx, "ssh key store close fail", "err", err)
+	}
+	s.store = nil
+}
+
+func toKey(pubKey ssh.PublicKey) []byte {
+	return append([]byte{storage.EXTEND_SSH_KEY}, pubKey.Marshal()...)
+}
+
+func(s *SshKeyStore) get(ctx context.Context, pubKey ssh.PublicKey) (*KeyEntry, error) {
+	v, err := s.store.Get(ctx, toKey(pubKey))
+	if err != nil {
+		if db.IsNotFound(err) {
+			return nil, ErrKeyNotFound
+		}
+		return nil, err
+	}
+	entry, err := decodeKeyEntry(v)
+	if err != nil {
+		return nil, err
+	}
+	entry.Fingerprint = ssh.FingerprintSHA256(pubKey)
+	entry.Type = pubKey.Type()
+	return entry, nil
+}
+
+func(s *SshKeyStore) put(ctx context.Context, pubKey ssh.PublicKey, entry *KeyEntry) error {
+	v, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.store.Put(ctx, toKey(pubKey), v)
+}
+
+// Add authorizes the public key for the session, replacing any earlier mapping of the key.
+func(s *SshKeyStore) Add(ctx context.Context, pubKey ssh.PublicKey, sessionId string, label string) error {
+	if sessionId == "" {
+		return errors.New("empty session id")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.close(ctx)
+	logg.Infof("Added key", "sessionId", sessionId, "label", label, "fingerprint", ssh.FingerprintSHA256(pubKey))
+	return s.put(ctx, pubKey, &KeyEntry{
+		SessionId: sessionId,
+		Label: label,
+		Created: time.Now().UTC(),
+	})
+}
+
+func(s *SshKeyStore) AddFromFile(ctx context.Context, fp string, sessionId string, label string) error {
 	_, err := os.Stat(fp)
 	if err != nil {
 		return fmt.Errorf("cannot open ssh server public key file: %v\n", err)
@@ -39,27 +157,184 @@ func(s *SshKeyStore) AddFromFile(ctx context.Context, fp string, sessionId strin
 	if err != nil {
 		return fmt.Errorf("Failed to load public key: %v", err)
 	}
-	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(publicBytes)
+	pubKey, comment, _, _, err := ssh.ParseAuthorizedKey(publicBytes)
 	if err != nil {
 		return fmt.Errorf("Failed to parse public key: %v", err)
 	}
-	k := append([]byte{0x01}, pubKey.Marshal()...)
-	s.store.SetPrefix(storage.DATATYPE_EXTEND)
-	logg.Infof("Added key", "sessionId", sessionId, "public key", string(publicBytes))
-	return s.store.Put(ctx, k, []byte(sessionId))
+	if label == "" {
+		label = comment
+	}
+	return s.Add(ctx, pubKey, sessionId, label)
 }
 
+// Get returns the session id the public key maps to.
 func(s *SshKeyStore) Get(ctx context.Context, pubKey ssh.PublicKey) (string, error) {
-	s.store.SetLanguage(nil)
-	s.store.SetPrefix(storage.DATATYPE_EXTEND)
-	k := append([]byte{0x01}, pubKey.Marshal()...)
-	v, err := s.store.Get(ctx, k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.open(ctx)
 	if err != nil {
 		return "", err
 	}
-	return string(v), nil
+	defer s.close(ctx)
+	entry, err := s.get(ctx, pubKey)
+	if err != nil {
+		return "", err
+	}
+	return entry.SessionId, nil
+}
+
+// Use returns the session id the public key maps to, and records the key as used now.
+//
+// It returns ErrKeyNotFound if the key was never added, or has been removed.
+func(s *SshKeyStore) Use(ctx context.Context, pubKey ssh.PublicKey) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.open(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer s.close(ctx)
+	entry, err := s.get(ctx, pubKey)
+	if err != nil {
+		return "", err
+	}
+	entry.LastUsed = time.Now().UTC()
+	err = s.put(ctx, pubKey, entry)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to record ssh key use", "fingerprint", entry.Fingerprint, "err", err)
+	}
+	return entry.SessionId, nil
+}
+
+// List returns all authorized keys, ordered by session id and creation time.
+func(s *SshKeyStore) List(ctx context.Context) ([]KeyEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.close(ctx)
+	return s.list(ctx)
+}
+
+func(s *SshKeyStore) list(ctx context.Context) ([]KeyEntry, error) {
+	var entries []KeyEntry
+	d, err := s.store.Dump(ctx, []byte{storage.EXTEND_SSH_KEY})
+	if err != nil {
+		return nil, err
+	}
+	for true {
+		k, v := d.Next(ctx)
+		if k == nil {
+			break
+		}
+		// the dumped keys carry the database prefix and the key type
+		if len(k) < 2 || k[0] != storage.DATATYPE_EXTEND || k[1] != storage.EXTEND_SSH_KEY {
+			continue
+		}
+		pubKey, err := ssh.ParsePublicKey(k[2:])
+		if err != nil {
+			logg.WarnCtxf(ctx, "skipping unparseable ssh key", "err", err)
+			continue
+		}
+		entry, err := decodeKeyEntry(v)
+		if err != nil {
+			if !errors.Is(err, ErrKeyNotFound) {
+				logg.WarnCtxf(ctx, "skipping invalid ssh key entry", "fingerprint", ssh.FingerprintSHA256(pubKey), "err", err)
+			}
+			continue
+		}
+		entry.Fingerprint = ssh.FingerprintSHA256(pubKey)
+		entry.Type = pubKey.Type()
+		entries = append(entries, *entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].SessionId != entries[j].SessionId {
+			return entries[i].SessionId < entries[j].SessionId
+		}
+		return entries[i].Created.Before(entries[j].Created)
+	})
+	return entries, nil
+}
+
+// find returns the public key with the given SHA256 fingerprint.
+func(s *SshKeyStore) find(ctx context.Context, fingerprint string) (ssh.PublicKey, error) {
+	d, err := s.store.Dump(ctx, []byte{storage.EXTEND_SSH_KEY})
+	if err != nil {
+		return nil, err
+	}
+	for true {
+		k, v := d.Next(ctx)
+		if k == nil {
+			break
+		}
+		if len(k) < 2 || k[0] != storage.DATATYPE_EXTEND || k[1] != storage.EXTEND_SSH_KEY || len(v) == 0 {
+			continue
+		}
+		pubKey, err := ssh.ParsePublicKey(k[2:])
+		if err != nil {
+			continue
+		}
+		if ssh.FingerprintSHA256(pubKey) == fingerprint {
+			return pubKey, nil
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
+// Remove revokes the key with the given fingerprint.
+//
+// Connections authenticating with the key are rejected from then on, but open connections are not closed.
+func(s *SshKeyStore) Remove(ctx context.Context, fingerprint string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.close(ctx)
+	pubKey, err := s.find(ctx, fingerprint)
+	if err != nil {
+		return err
+	}
+	logg.Infof("Removed key", "fingerprint", fingerprint)
+	// the database has no delete, an empty entry marks the key as removed
+	return s.store.Put(ctx, toKey(pubKey), []byte{})
+}
+
+// Rename maps the key with the given fingerprint to another session id, or gives it another label.
+//
+// Empty arguments leave the corresponding value unchanged.
+func(s *SshKeyStore) Rename(ctx context.Context, fingerprint string, sessionId string, label string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.close(ctx)
+	pubKey, err := s.find(ctx, fingerprint)
+	if err != nil {
+		return err
+	}
+	entry, err := s.get(ctx, pubKey)
+	if err != nil {
+		return err
+	}
+	if sessionId != "" {
+		entry.SessionId = sessionId
+	}
+	if label != "" {
+		entry.Label = label
+	}
+	logg.Infof("Renamed key", "fingerprint", fingerprint, "sessionId", entry.SessionId, "label", entry.Label)
+	return s.put(ctx, pubKey, entry)
 }
 
+// Close implements io.Closer.
+//
+// It does nothing, as the database is only open for the duration of each operation.
 func(s *SshKeyStore) Close() error {
-	return s.store.Close()
+	return nil
 }
@@ -0,0 +1,231 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pubKey
+}
+
+func newTestKeyStore(t *testing.T) *SshKeyStore {
+	store, err := NewSshKeyStore(context.Background(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestKeyStoreAddList(t *testing.T) {
+	ctx := context.Background()
+	store := newTestKeyStore(t)
+	keys := []ssh.PublicKey{newTestKey(t), newTestKey(t), newTestKey(t)}
+	sessionIds := []string{"+254000000002", "+254000000001", "+254000000002"}
+	for i, k := range keys {
+		err := store.Add(ctx, k, sessionIds[i], "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := store.Add(ctx, newTestKey(t), "", "")
+	if err == nil {
+		t.Fatalf("expected error adding key without session id")
+	}
+
+	entries, err := store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	// ordered by session id, then in the order added
+	for i, j := range []int{1, 0, 2} {
+		if entries[i].SessionId != sessionIds[j] {
+			t.Fatalf("entry %d: expected session id %s, got %s", i, sessionIds[j], entries[i].SessionId)
+		}
+		if entries[i].Fingerprint != ssh.FingerprintSHA256(keys[j]) {
+			t.Fatalf("entry %d: expected fingerprint %s, got %s", i, ssh.FingerprintSHA256(keys[j]), entries[i].Fingerprint)
+		}
+		if entries[i].Type != ssh.KeyAlgoED25519 {
+			t.Fatalf("entry %d: expected type %s, got %s", i, ssh.KeyAlgoED25519, entries[i].Type)
+		}
+		if entries[i].Created.IsZero() {
+			t.Fatalf("entry %d: missing creation time", i)
+		}
+		if !entries[i].LastUsed.IsZero() {
+			t.Fatalf("entry %d: expected unused key", i)
+		}
+	}
+
+	_, err = store.Use(ctx, keys[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err = store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries[0].LastUsed.IsZero() {
+		t.Fatalf("expected key to be recorded as used")
+	}
+	if !entries[1].LastUsed.IsZero() {
+		t.Fatalf("expected other key to be unused")
+	}
+}
+
+func TestKeyStoreLegacy(t *testing.T) {
+	ctx := context.Background()
+	store := newTestKeyStore(t)
+	pubKey := newTestKey(t)
+
+	// keys added before entries carried metadata map to the bare session id
+	err := store.open(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.store.Put(ctx, toKey(pubKey), []byte("+254000000001"))
+	store.close(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessionId, err := store.Get(ctx, pubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sessionId != "+254000000001" {
+		t.Fatalf("expected session id +254000000001, got %s", sessionId)
+	}
+	entries, err := store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+	if entries[0].SessionId != "+254000000001" || entries[0].Fingerprint != ssh.FingerprintSHA256(pubKey) {
+		t.Fatalf("unexpected legacy entry %v", entries[0])
+	}
+	if !entries[0].Created.IsZero() {
+		t.Fatalf("expected no creation time for legacy entry, got %v", entries[0].Created)
+	}
+
+	// using the key upgrades the entry
+	_, err = store.Use(ctx, pubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err = store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries[0].SessionId != "+254000000001" || entries[0].LastUsed.IsZero() {
+		t.Fatalf("unexpected upgraded entry %v", entries[0])
+	}
+}
+
+func TestKeyStoreRemove(t *testing.T) {
+	ctx := context.Background()
+	store := newTestKeyStore(t)
+	pubKey := newTestKey(t)
+	otherKey := newTestKey(t)
+	err := store.Add(ctx, pubKey, "+254000000001", "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Add(ctx, otherKey, "+254000000001", "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Remove(ctx, ssh.FingerprintSHA256(pubKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Get(ctx, pubKey)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound from Get, got %v", err)
+	}
+	_, err = store.Use(ctx, pubKey)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound from Use, got %v", err)
+	}
+	err = store.Remove(ctx, ssh.FingerprintSHA256(pubKey))
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound removing twice, got %v", err)
+	}
+	err = store.Remove(ctx, "SHA256:unknown")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound removing unknown key, got %v", err)
+	}
+
+	entries, err := store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Label != "phone" {
+		t.Fatalf("expected only the other key to be left, got %v", entries)
+	}
+}
+
+func TestKeyStoreRename(t *testing.T) {
+	ctx := context.Background()
+	store := newTestKeyStore(t)
+	pubKey := newTestKey(t)
+	fp := ssh.FingerprintSHA256(pubKey)
+	err := store.Add(ctx, pubKey, "+254000000001", "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Rename(ctx, fp, "+254000000002", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries[0].SessionId != "+254000000002" || entries[0].Label != "laptop" {
+		t.Fatalf("expected only the session id to change, got %v", entries[0])
+	}
+
+	err = store.Rename(ctx, fp, "", "work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err = store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries[0].SessionId != "+254000000002" || entries[0].Label != "work" {
+		t.Fatalf("expected only the label to change, got %v", entries[0])
+	}
+	sessionId, err := store.Get(ctx, pubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sessionId != "+254000000002" {
+		t.Fatalf("expected session id +254000000002, got %s", sessionId)
+	}
+
+	err = store.Rename(ctx, "SHA256:unknown", "+254000000003", "")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound renaming unknown key, got %v", err)
+	}
+}
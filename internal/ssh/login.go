@@ -0,0 +1,73 @@
+package ssh
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"golang.org/x/crypto/ssh"
+
+	"git.grassecon.net/urdt/ussd/common"
+)
+
+var (
+	ErrLoginFailed  = errors.New("incorrect phone number or PIN")
+	ErrLoginBlocked = errors.New("account blocked after too many incorrect PIN attempts")
+)
+
+// WithPinLogin enables keyboard-interactive login with the phone number and PIN of an account in the userdata store.
+//
+// It lets testers in without uploading a public key, and must not be used in production.
+func (a *auther) WithPinLogin(userdataStore common.DataStore) *auther {
+	a.userdataStore = userdataStore
+	return a
+}
+
+// CheckPin authenticates the connection with the phone number and PIN of the account, asked for through keyboard-interactive auth.
+//
+// Incorrect PINs count towards the same limit as in the menu, and accounts that reached it are rejected.
+func (a *auther) CheckPin(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	ctx := a.Ctx
+	answers, err := client("", "Log in with the phone number and PIN of your account.", []string{"Phone number: ", "PIN: "}, []bool{true, false})
+	if err != nil {
+		return nil, err
+	}
+	if len(answers) != 2 {
+		return nil, ErrLoginFailed
+	}
+	sessionId, err := common.FormatPhoneNumber(answers[0])
+	if err != nil {
+		logg.InfoCtxf(ctx, "pin login rejected", "err", err)
+		return nil, ErrLoginFailed
+	}
+
+	blocked, err := common.IsPINBlocked(ctx, a.userdataStore, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		logg.InfoCtxf(ctx, "pin login of blocked account", "sessionId", sessionId)
+		return nil, ErrLoginBlocked
+	}
+
+	pin, err := a.userdataStore.ReadEntry(ctx, sessionId, common.DATA_ACCOUNT_PIN)
+	if err != nil {
+		logg.InfoCtxf(ctx, "pin login without account pin", "sessionId", sessionId, "err", err)
+		return nil, ErrLoginFailed
+	}
+	if !common.VerifyPIN(string(pin), answers[1]) {
+		err = common.IncrementIncorrectPINAttempts(ctx, a.userdataStore, sessionId)
+		if err != nil {
+			logg.ErrorCtxf(ctx, "failed to write incorrect PIN attempts", "sessionId", sessionId, "err", err)
+		}
+		logg.InfoCtxf(ctx, "pin login with incorrect pin", "sessionId", sessionId)
+		return nil, ErrLoginFailed
+	}
+	err = common.ResetIncorrectPINAttempts(ctx, a.userdataStore, sessionId)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "failed to reset incorrect PIN attempts", "sessionId", sessionId, "err", err)
+	}
+
+	a.set(hex.EncodeToString(conn.SessionID()), sessionId)
+	logg.InfoCtxf(ctx, "pin login", "sessionId", sessionId)
+	return nil, nil
+}
@@ -0,0 +1,82 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	memdb "git.defalsify.org/vise.git/db/mem"
+
+	"git.grassecon.net/urdt/ussd/common"
+)
+
+type testConn struct {
+	id []byte
+}
+
+func (c *testConn) User() string          { return "" }
+func (c *testConn) SessionID() []byte     { return c.id }
+func (c *testConn) ClientVersion() []byte { return nil }
+func (c *testConn) ServerVersion() []byte { return nil }
+func (c *testConn) RemoteAddr() net.Addr  { return nil }
+func (c *testConn) LocalAddr() net.Addr   { return nil }
+
+func answer(phone string, pin string) ssh.KeyboardInteractiveChallenge {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		return []string{phone, pin}, nil
+	}
+}
+
+func TestCheckPin(t *testing.T) {
+	ctx := context.Background()
+	db := memdb.NewMemDb()
+	err := db.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store := &common.UserDataStore{Db: db}
+	sessionId := "+254711111111"
+	hashedPin, err := common.HashPIN("1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.WriteEntry(ctx, sessionId, common.DATA_ACCOUNT_PIN, []byte(hashedPin))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewAuther(ctx, nil).WithPinLogin(store)
+	conn := &testConn{id: []byte{0x01}}
+
+	_, err = a.CheckPin(conn, answer("0722222222", "1234"))
+	if !errors.Is(err, ErrLoginFailed) {
+		t.Fatalf("expected ErrLoginFailed for unknown account, got %v", err)
+	}
+
+	_, err = a.CheckPin(conn, answer("0711111111", "1234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := a.Get(conn.SessionID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != sessionId {
+		t.Fatalf("expected session %s, got %s", sessionId, v)
+	}
+
+	for i := uint8(0); i < common.AllowedPINAttempts; i++ {
+		_, err = a.CheckPin(conn, answer("0711111111", "4321"))
+		if !errors.Is(err, ErrLoginFailed) {
+			t.Fatalf("expected ErrLoginFailed for incorrect pin, got %v", err)
+		}
+	}
+	_, err = a.CheckPin(conn, answer("0711111111", "1234"))
+	if !errors.Is(err, ErrLoginBlocked) {
+		t.Fatalf("expected ErrLoginBlocked, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package ssh
 
 import (
+	"bytes"
 	"context"
 	"encoding/hex"
 	"encoding/base64"
@@ -8,16 +9,26 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"path"
+	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 
+	"git.defalsify.org/vise.git/db"
 	"git.defalsify.org/vise.git/engine"
 	"git.defalsify.org/vise.git/logging"
 	"git.defalsify.org/vise.git/resource"
-	"git.defalsify.org/vise.git/state"
 
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/config"
 	"git.grassecon.net/urdt/ussd/internal/handlers"
+	"git.grassecon.net/urdt/ussd/internal/journal"
+	"git.grassecon.net/urdt/ussd/internal/lifecycle"
+	"git.grassecon.net/urdt/ussd/internal/ratelimit"
+	"git.grassecon.net/urdt/ussd/internal/recorder"
+	"git.grassecon.net/urdt/ussd/internal/sms"
 	"git.grassecon.net/urdt/ussd/internal/storage"
 	"git.grassecon.net/urdt/ussd/remote"
 )
@@ -26,10 +37,17 @@ var (
 	logg = logging.NewVanilla().WithDomain("ssh")
 )
 
+const (
+	// permissionPubKey is the permissions extension carrying the public key the connection authenticated with.
+	permissionPubKey = "pubkey"
+)
+
 type auther struct {
 	Ctx context.Context
 	keyStore *SshKeyStore
+	userdataStore common.DataStore
 	auth map[string]string
+	mu sync.Mutex
 }
 
 func NewAuther(ctx context.Context, keyStore *SshKeyStore) *auther {
@@ -40,16 +58,54 @@ func NewAuther(ctx context.Context, keyStore *SshKeyStore) *auther {
 	}
 }
 
+// Check authenticates the connection if the public key is in the key store.
+//
+// The key store is read for each connection, so removed keys are rejected without restarting the server.
+//
+// Check is called before the client proves it holds the private key, so the key is only recorded as used by Used, once the handshake succeeds.
 func(a *auther) Check(conn ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
 	logg.TraceCtxf(a.Ctx, "looking for publickey", "pubkey", fmt.Sprintf("%x", pubKey))
 	va, err := a.keyStore.Get(a.Ctx, pubKey)
 	if err != nil {
+		logg.InfoCtxf(a.Ctx, "public key rejected", "fingerprint", ssh.FingerprintSHA256(pubKey), "err", err)
 		return nil, err
 	}
 	ka := hex.EncodeToString(conn.SessionID())
-	a.auth[ka] = va 
+	a.set(ka, va)
 	fmt.Fprintf(os.Stderr, "connect: %s -> %s\n", ka, va)
-	return nil, nil
+	return &ssh.Permissions{
+		Extensions: map[string]string{
+			permissionPubKey: string(pubKey.Marshal()),
+		},
+	}, nil
+}
+
+// Used records the public key the connection authenticated with as used.
+//
+// It does nothing for connections authenticated otherwise, e.g. with the PIN.
+func(a *auther) Used(c *ssh.ServerConn) {
+	if c.Permissions == nil {
+		return
+	}
+	v, ok := c.Permissions.Extensions[permissionPubKey]
+	if !ok {
+		return
+	}
+	pubKey, err := ssh.ParsePublicKey([]byte(v))
+	if err != nil {
+		logg.WarnCtxf(a.Ctx, "no public key for connection", "err", err)
+		return
+	}
+	_, err = a.keyStore.Use(a.Ctx, pubKey)
+	if err != nil {
+		logg.WarnCtxf(a.Ctx, "failed to record ssh key use", "fingerprint", ssh.FingerprintSHA256(pubKey), "err", err)
+	}
+}
+
+func(a *auther) set(ka string, sessionId string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.auth[ka] = sessionId
 }
 
 func(a *auther) FromConn(c *ssh.ServerConn) (string, error) {
@@ -65,6 +121,8 @@ func(a *auther) FromConn(c *ssh.ServerConn) (string, error) {
 
 func(a *auther) Get(k []byte) (string, error) {
 	ka := hex.EncodeToString(k)
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	v, ok := a.auth[ka]
 	if !ok {
 		return "", errors.New("not found")
@@ -72,6 +130,14 @@ func(a *auther) Get(k []byte) (string, error) {
 	return v, nil
 }
 
+// Forget removes the session mapped to the ssh session id, once its connection is closed.
+func(a *auther) Forget(k []byte) {
+	ka := hex.EncodeToString(k)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.auth, ka)
+}
+
 type SshRunner struct {
 	Ctx context.Context
 	Cfg engine.Config
@@ -82,11 +148,34 @@ type SshRunner struct {
 	SrvKeyFile string
 	Host string
 	Port uint
+	Middleware []handlers.Middleware
+	RateLimit ratelimit.Policies
+	JournalSink journal.Sink
+	// SmsQueue is the queue of outbound SMS shared by all connections, if any.
+	SmsQueue *sms.Queue
+	Lifecycle *lifecycle.Manager
+	// Handler is the request handler shared with other frontends. If not set, a handler is created for each connection.
+	Handler handlers.RequestHandler
+	// Storage is the storage service shared by all connections. If not set, it is created from Conn and ResourceDir when the runner starts, and closed by Close.
+	Storage *storage.MenuStorageService
+	// PinLogin enables login with the phone number and PIN of an account, besides public keys. It must not be used in production.
+	PinLogin bool
+	// RecordDir is the directory each connection is recorded to as a menu traversal test group, if set.
+	RecordDir string
+	// RecordPlaceholders replaces dynamic values, such as balances, with placeholders in the recordings.
+	RecordPlaceholders bool
 	wg sync.WaitGroup
 	lst net.Listener
+	mu sync.Mutex
+	conns map[*ssh.ServerConn]bool
+	ownStorage bool
+	stateStore db.Db
+	userdataStore db.Db
+	handlerService *handlers.LocalHandlerService
+	mws []handlers.Middleware
 }
 
-func(s *SshRunner) serve(ctx context.Context, sessionId string, ch ssh.NewChannel, en engine.Engine) error {
+func(s *SshRunner) serve(ctx context.Context, sessionId string, source string, gatewaySessionId string, ch ssh.NewChannel, rh handlers.RequestHandler) error {
 	if ch == nil {
 		return errors.New("nil channel")
 	}
@@ -99,86 +188,208 @@ func(s *SshRunner) serve(ctx context.Context, sessionId string, ch ssh.NewChanne
 		panic(err)
 	}
 	defer channel.Close()
+
+	// the output is not written before the shell is requested, so that a pty requested before it is taken into account
+	term := &terminal{}
+	shell := make(chan struct{})
 	s.wg.Add(1)
 	go func(reqIn <-chan *ssh.Request) {
 		defer s.wg.Done()
+		started := false
 		for req := range reqIn {
-			req.Reply(req.Type == "shell", nil)	
+			ok := term.handleRequest(req)
+			req.Reply(ok, nil)
+			if req.Type == "shell" && !started {
+				started = true
+				close(shell)
+			}
+		}
+		if !started {
+			close(shell)
 		}
-		_ = requests
 	}(requests)
+	select {
+	case <-shell:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 
-	cont, err := en.Exec(ctx, []byte{})
-	if err != nil {
-		return fmt.Errorf("initial engine exec err: %v", err)
+	cfg := rh.GetConfig()
+	cfg.SessionId = sessionId
+	var output bytes.Buffer
+	rqs := handlers.RequestSession{
+		Ctx: ctx,
+		Config: cfg,
+		Writer: &output,
+		Input: []byte{},
+		Source: source,
+		GatewaySessionId: gatewaySessionId,
 	}
 
-	var input [state.INPUT_LIMIT]byte
-	for cont {
-		c, err := en.Flush(ctx, channel)
+	rc := common.RequestContext{
+		SessionId: sessionId,
+		GatewaySessionId: gatewaySessionId,
+		Language: config.DefaultLanguage,
+	}
+	lr := newLineReader(channel, channel, term)
+	for s.Lifecycle.Begin() {
+		rc.RequestId = common.NewRequestId()
+		rqs.Ctx = common.WithRequestContext(ctx, rc)
+		output.Reset()
+		err = s.cycle(&rqs, rh)
+		s.Lifecycle.End()
 		if err != nil {
-			return fmt.Errorf("flush err: %v", err)
+			return err
 		}
-		_, err = channel.Write([]byte{0x0a})
+		_, err = channel.Write(term.Frame(output.Bytes(), cfg.OutputSize))
 		if err != nil {
-			return fmt.Errorf("newline err: %v", err)
+			return fmt.Errorf("output write err: %v", err)
+		}
+		if !rqs.Continue {
+			break
 		}
-		c, err = channel.Read(input[:])
+		_, err = channel.Write([]byte(prompt))
 		if err != nil {
-			return fmt.Errorf("read input fail: %v", err)
+			return fmt.Errorf("prompt err: %v", err)
 		}
-		logg.TraceCtxf(ctx, "input read", "c", c, "input", input[:c-1])
-		cont, err = en.Exec(ctx, input[:c-1])
+		rqs.Input, err = lr.ReadLine()
 		if err != nil {
-			return fmt.Errorf("engine exec err: %v", err)
+			return fmt.Errorf("read input fail: %v", err)
 		}
-		logg.TraceCtxf(ctx, "exec cont", "cont", cont, "en", en)
-		_ = c
+		logg.TraceCtxf(ctx, "input read", "input", rqs.Input)
+	}
+	_, err = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+	if err != nil {
+		logg.DebugCtxf(ctx, "exit status not sent", "err", err)
+	}
+	return nil
+}
+
+// cycle runs the Process, Output and Reset steps of a single request.
+func(s *SshRunner) cycle(rqs *handlers.RequestSession, rh handlers.RequestHandler) error {
+	var err error
+	*rqs, err = rh.Process(*rqs)
+	if err != nil {
+		return fmt.Errorf("process err: %v", err)
 	}
-	c, err := en.Flush(ctx, channel)
+	*rqs, err = rh.Output(*rqs)
 	if err != nil {
-		return fmt.Errorf("last flush err: %v", err)
+		return fmt.Errorf("output err: %v", err)
+	}
+	*rqs, err = rh.Reset(*rqs)
+	if err != nil {
+		return fmt.Errorf("reset err: %v", err)
 	}
-	_ = c
 	return nil
 }
 
-func(s *SshRunner) Stop() error {
+// Stop stops accepting new connections.
+func(s *SshRunner) Stop(ctx context.Context) error {
+	if s.lst == nil {
+		return nil
+	}
 	return s.lst.Close()
 }
 
-func(s *SshRunner) GetEngine(sessionId string) (engine.Engine, func(), error) {
-	ctx := s.Ctx
-	menuStorageService := storage.NewMenuStorageService(s.Conn, s.ResourceDir)
+// Close closes all open client connections, and the storage service if it was created by the runner.
+//
+// It should be called after Stop, once the requests in flight have finished.
+func(s *SshRunner) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c, _ := range s.conns {
+		err := c.Close()
+		if err != nil {
+			logg.DebugCtxf(s.Ctx, "ssh connection close", "err", err)
+		}
+	}
+	if s.ownStorage && s.Storage != nil {
+		return s.Storage.Close()
+	}
+	return nil
+}
+
+func(s *SshRunner) track(c *ssh.ServerConn, open bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if open {
+		s.conns[c] = true
+	} else {
+		delete(s.conns, c)
+	}
+}
 
-	rs, err := menuStorageService.GetResource(ctx)
+// setup connects the stores and prepares the handler service and middleware shared by all connections.
+func(s *SshRunner) setup(ctx context.Context) error {
+	var err error
+	if s.Handler != nil && !s.PinLogin {
+		return nil
+	}
+	if s.Storage == nil {
+		if s.Handler != nil {
+			return errors.New("pin login with a shared handler needs the storage service")
+		}
+		s.Storage = storage.NewMenuStorageService(s.Conn, s.ResourceDir)
+		s.ownStorage = true
+	}
+
+	s.userdataStore, err = s.Storage.GetUserdataDb(ctx)
 	if err != nil {
-		return nil, nil, err
+		return err
+	}
+	if s.Handler != nil {
+		return nil
 	}
 
-	pe, err := menuStorageService.GetPersister(ctx)
+	s.stateStore, err = s.Storage.GetStateStore(ctx)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
 
-	userdatastore, err := menuStorageService.GetUserdataDb(ctx)
+	rs, err := s.Storage.GetResource(ctx)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
 
-	dbResource, ok := rs.(*resource.DbResource)
-	if !ok {
-		return nil, nil, err
+	s.handlerService, err = handlers.NewLocalHandlerService(ctx, s.FlagFile, true, nil, s.Cfg, rs)
+	if err != nil {
+		return err
+	}
+	s.handlerService.SetDataStore(&s.userdataStore)
+	if s.SmsQueue != nil {
+		s.handlerService.SetSmsQueue(s.SmsQueue)
 	}
 
-	lhs, err := handlers.NewLocalHandlerService(ctx, s.FlagFile, true, dbResource, s.Cfg, rs)
-	lhs.SetDataStore(&userdatastore)
-	lhs.SetPersister(pe)
-	lhs.Cfg.SessionId = sessionId
+	lim := ratelimit.NewLimiter(s.stateStore, s.RateLimit)
+	tracker := handlers.NewSessionTracker(s.stateStore, s.userdataStore)
+	jnl := journal.NewJournal(s.stateStore, s.JournalSink)
+	s.mws = append(append([]handlers.Middleware{}, s.Middleware...), tracker.Middleware(), jnl.Middleware(), lim.Middleware())
+	return nil
+}
+
+// GetHandler returns a request handler for the given session, wrapped in the middleware of the runner.
+//
+// Each handler has a resource, menu handlers and persister of its own, so that the connections do not share engine state. The stores are shared by all connections, and left open until the runner is closed.
+//
+// The returned function must be called when the connection ends.
+//
+// If the runner has a shared Handler, it is returned as is, and its resources are left to its owner.
+func(s *SshRunner) GetHandler(sessionId string) (handlers.RequestHandler, func(), error) {
+	if s.Handler != nil {
+		rh, closer := s.record(sessionId, s.Handler)
+		return rh, closer, nil
+	}
+	ctx := s.Ctx
 
+	rs, err := s.Storage.GetResource(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
+	dbResource, ok := rs.(*resource.DbResource)
+	if !ok {
+		return nil, nil, fmt.Errorf("unexpected resource type %T", rs)
+	}
+	lhs := s.handlerService.ForResource(dbResource)
 
 	// TODO: clear up why pointer here and by-value other cmds
 	accountService := &remote.AccountService{}
@@ -187,33 +398,69 @@ func(s *SshRunner) GetEngine(sessionId string) (engine.Engine, func(), error) {
 		return nil, nil, err
 	}
 
-	en := lhs.GetEngine()
-	en = en.WithFirst(hl.Init)
-	if s.Debug {
-		en = en.WithDebug(nil)
+	cfg := s.Cfg
+	cfg.EngineDebug = s.Debug
+	bsh := handlers.NewBaseSessionHandler(cfg, rs, s.stateStore, s.userdataStore, nil, hl)
+	rh, recordCloser := s.record(sessionId, handlers.WithMiddleware(bsh, s.mws...))
+
+	closer := func() {
+		recordCloser()
+		logg.DebugCtxf(ctx, "ssh session handler released", "sessionId", sessionId)
+	}
+	return rh, closer, nil
+}
+
+// record wraps the handler to record the session to RecordDir, if set.
+//
+// The returned function writes the recording, and must be called when the connection ends.
+func(s *SshRunner) record(sessionId string, rh handlers.RequestHandler) (handlers.RequestHandler, func()) {
+	if s.RecordDir == "" {
+		return rh, func() {}
+	}
+	group := fmt.Sprintf("ssh_%s_%d", strings.TrimPrefix(sessionId, "+"), time.Now().Unix())
+	rec := recorder.NewRecorder(sessionId, group).WithMenuSeparator(s.Cfg.MenuSeparator)
+	if s.RecordPlaceholders {
+		rec = rec.WithPlaceholders(recorder.DefaultPlaceholders())
 	}
-	// TODO: this is getting very hacky!
 	closer := func() {
-		err := menuStorageService.Close()
+		fp := path.Join(s.RecordDir, group + ".json")
+		err := rec.WriteFile(fp)
 		if err != nil {
-			logg.ErrorCtxf(ctx, "menu storage service cleanup fail", "err", err)
+			logg.ErrorCtxf(s.Ctx, "ssh session recording failed", "sessionId", sessionId, "err", err)
+			return
 		}
+		logg.InfoCtxf(s.Ctx, "ssh session recorded", "sessionId", sessionId, "file", fp)
 	}
-	return en, closer, nil
+	return rec.Middleware()(rh), closer
 }
 
 // adapted example from crypto/ssh package, NewServerConn doc
 func(s *SshRunner) Run(ctx context.Context, keyStore *SshKeyStore) {
 	s.Ctx = ctx
+	s.conns = make(map[*ssh.ServerConn]bool)
+	if s.Lifecycle == nil {
+		s.Lifecycle = lifecycle.NewManager(config.ShutdownTimeout)
+	}
 	running := true
 
 	// TODO: waitgroup should probably not be global
 	defer s.wg.Wait()
 
+	err := s.setup(ctx)
+	if err != nil {
+		logg.ErrorCtxf(ctx, "ssh runner setup failed", "err", err)
+		return
+	}
+
 	auth := NewAuther(ctx, keyStore)
 	cfg := ssh.ServerConfig{
 		PublicKeyCallback: auth.Check,
 	}
+	if s.PinLogin {
+		logg.WarnCtxf(ctx, "ssh login with account pin enabled, do not use in production")
+		auth = auth.WithPinLogin(&common.UserDataStore{Db: s.userdataStore})
+		cfg.KeyboardInteractiveCallback = auth.CheckPin
+	}
 
 	privateBytes, err := os.ReadFile(s.SrvKeyFile)
 	if err != nil {
@@ -241,7 +488,9 @@ func(s *SshRunner) Run(ctx context.Context, keyStore *SshKeyStore) {
 			continue
 		}
 
+		s.wg.Add(1)
 		go func(conn net.Conn) {
+			defer s.wg.Done()
 			defer conn.Close()
 			for true {
 				srvConn, nC, rC, err := ssh.NewServerConn(conn, &cfg)
@@ -250,6 +499,9 @@ func(s *SshRunner) Run(ctx context.Context, keyStore *SshKeyStore) {
 					return
 				}
 				logg.DebugCtxf(ctx, "ssh client connected", "conn", srvConn)
+				s.track(srvConn, true)
+				defer s.track(srvConn, false)
+				auth.Used(srvConn)
 
 				s.wg.Add(1)
 				go func() {
@@ -262,20 +514,19 @@ func(s *SshRunner) Run(ctx context.Context, keyStore *SshKeyStore) {
 					logg.ErrorCtxf(ctx, "Cannot find authentication")
 					return
 				}
-				en, closer, err := s.GetEngine(sessionId)
+				defer auth.Forget(srvConn.SessionID())
+				rh, closer, err := s.GetHandler(sessionId)
 				if err != nil {
-					logg.ErrorCtxf(ctx, "engine won't start", "err", err)
+					logg.ErrorCtxf(ctx, "handler won't start", "err", err)
 					return
 				}
-				defer func() {
-					err := en.Finish()
-					if err != nil {
-						logg.ErrorCtxf(ctx, "engine won't stop", "err", err)
-					}
-					closer()
-				}()
+				defer closer()
+				source, _, err := net.SplitHostPort(srvConn.RemoteAddr().String())
+				if err != nil {
+					source = srvConn.RemoteAddr().String()
+				}
 				for ch := range nC {
-					err = s.serve(ctx, sessionId, ch, en)
+					err = s.serve(ctx, sessionId, source, hex.EncodeToString(srvConn.SessionID()), ch, rh)
 					logg.ErrorCtxf(ctx, "ssh server finish", "err", err)
 				}
 			}
@@ -0,0 +1,274 @@
+package ssh
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/ssh"
+
+	"git.defalsify.org/vise.git/state"
+)
+
+const (
+	// frameWidth is the number of columns inside the handset frame, when the terminal is wide enough.
+	frameWidth = 30
+	// minFrameWidth is the narrowest the handset frame is shrunk to on small terminals.
+	minFrameWidth = 10
+	// prompt is shown before the input of the user.
+	prompt = "> "
+)
+
+// ptyRequest is the payload of the pty-req channel request (RFC 4254, 6.2).
+type ptyRequest struct {
+	Term   string
+	Cols   uint32
+	Rows   uint32
+	Width  uint32
+	Height uint32
+	Modes  string
+}
+
+// windowChange is the payload of the window-change channel request (RFC 4254, 6.7).
+type windowChange struct {
+	Cols   uint32
+	Rows   uint32
+	Width  uint32
+	Height uint32
+}
+
+// terminal holds what the client told about its terminal through channel requests.
+//
+// If the client allocated a pty, the terminal is in raw mode. Input then has to be echoed, and output lines ended with CRLF.
+type terminal struct {
+	mu   sync.Mutex
+	pty  bool
+	cols int
+	rows int
+}
+
+// handleRequest applies a channel request of the client, and returns whether it was accepted.
+func (t *terminal) handleRequest(req *ssh.Request) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch req.Type {
+	case "pty-req":
+		var pr ptyRequest
+		err := ssh.Unmarshal(req.Payload, &pr)
+		if err != nil {
+			return false
+		}
+		t.pty = true
+		t.cols = int(pr.Cols)
+		t.rows = int(pr.Rows)
+		return true
+	case "window-change":
+		var wc windowChange
+		err := ssh.Unmarshal(req.Payload, &wc)
+		if err != nil {
+			return false
+		}
+		t.cols = int(wc.Cols)
+		t.rows = int(wc.Rows)
+		return true
+	case "shell":
+		return true
+	}
+	return false
+}
+
+// Echo returns true if the input must be echoed back to the client.
+func (t *terminal) Echo() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.pty
+}
+
+// Newline returns the line ending the terminal of the client expects.
+func (t *terminal) Newline() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pty {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// width returns the number of columns inside the handset frame.
+func (t *terminal) width() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	w := frameWidth
+	// leave room for the border and padding
+	if t.cols > 0 && t.cols-4 < w {
+		w = t.cols - 4
+	}
+	if w < minFrameWidth {
+		w = minFrameWidth
+	}
+	return w
+}
+
+// Frame renders the menu output in a bordered handset screen.
+//
+// The screen is high enough to show outputSize characters at its width, and grows if the lines of the output do not fit.
+func (t *terminal) Frame(content []byte, outputSize uint32) []byte {
+	var b bytes.Buffer
+	w := t.width()
+	nl := t.Newline()
+
+	lines := wrap(string(content), w)
+	rows := (int(outputSize) + w - 1) / w
+	for len(lines) < rows {
+		lines = append(lines, "")
+	}
+
+	border := strings.Repeat("─", w+2)
+	b.WriteString("┌" + border + "┐" + nl)
+	for _, line := range lines {
+		pad := strings.Repeat(" ", w-utf8.RuneCountInString(line))
+		b.WriteString("│ " + line + pad + " │" + nl)
+	}
+	b.WriteString("└" + border + "┘" + nl)
+	return b.Bytes()
+}
+
+// wrap breaks the text into lines of at most width characters, at spaces where possible.
+func wrap(text string, width int) []string {
+	var lines []string
+	for _, para := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		line := []rune{}
+		for _, word := range strings.Split(para, " ") {
+			r := []rune(word)
+			if len(line) > 0 && len(line)+1+len(r) > width {
+				lines = append(lines, string(line))
+				line = []rune{}
+			}
+			if len(line) > 0 {
+				line = append(line, ' ')
+			}
+			line = append(line, r...)
+			for len(line) > width {
+				lines = append(lines, string(line[:width]))
+				line = line[width:]
+			}
+		}
+		lines = append(lines, string(line))
+	}
+	return lines
+}
+
+// lineReader reads lines of input from the client, with a minimal line discipline.
+//
+// Lines may end with CR, LF or CRLF, and several lines may arrive in one read, as when pasted. Backspace and delete erase the last character. Escape sequences, such as those of the arrow keys, and other control characters are ignored. Ctrl-C and Ctrl-D end the input.
+//
+// When the terminal asks for it, the input is echoed to the writer.
+type lineReader struct {
+	r       io.Reader
+	w       io.Writer
+	term    *terminal
+	buf     [256]byte
+	pending []byte
+	line    []byte
+	lastCR  bool
+	esc     int
+}
+
+func newLineReader(r io.Reader, w io.Writer, term *terminal) *lineReader {
+	return &lineReader{
+		r:    r,
+		w:    w,
+		term: term,
+	}
+}
+
+func (lr *lineReader) echo(b []byte) {
+	if !lr.term.Echo() {
+		return
+	}
+	lr.w.Write(b)
+}
+
+// ReadLine returns the next line of input, without the line ending.
+//
+// Input beyond the input limit of the engine is dropped.
+func (lr *lineReader) ReadLine() ([]byte, error) {
+	for {
+		for len(lr.pending) > 0 {
+			c := lr.pending[0]
+			lr.pending = lr.pending[1:]
+			line, ok, err := lr.feed(c)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				return line, nil
+			}
+		}
+		n, err := lr.r.Read(lr.buf[:])
+		if n > 0 {
+			lr.pending = lr.buf[:n]
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// feed processes one byte of input, and returns the line when it is complete.
+func (lr *lineReader) feed(c byte) ([]byte, bool, error) {
+	lastCR := lr.lastCR
+	lr.lastCR = false
+
+	// skip escape sequences; CSI sequences end with a byte in 0x40-0x7e
+	switch lr.esc {
+	case 1:
+		if c == '[' || c == 'O' {
+			lr.esc = 2
+		} else {
+			lr.esc = 0
+		}
+		return nil, false, nil
+	case 2:
+		if c >= 0x40 && c <= 0x7e {
+			lr.esc = 0
+		}
+		return nil, false, nil
+	}
+
+	switch c {
+	case 0x1b:
+		lr.esc = 1
+	case '\r', '\n':
+		if c == '\n' && lastCR {
+			return nil, false, nil
+		}
+		lr.lastCR = c == '\r'
+		lr.echo([]byte(lr.term.Newline()))
+		line := lr.line
+		lr.line = nil
+		return line, true, nil
+	case 0x7f, 0x08:
+		if len(lr.line) == 0 {
+			break
+		}
+		_, size := utf8.DecodeLastRune(lr.line)
+		lr.line = lr.line[:len(lr.line)-size]
+		lr.echo([]byte("\b \b"))
+	case 0x03, 0x04:
+		return nil, false, io.EOF
+	default:
+		if c < 0x20 {
+			break
+		}
+		if len(lr.line) >= state.INPUT_LIMIT {
+			break
+		}
+		lr.line = append(lr.line, c)
+		lr.echo([]byte{c})
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,157 @@
+package ssh
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// chunkReader returns the chunks one read at a time.
+type chunkReader struct {
+	chunks []string
+}
+
+func (r *chunkReader) Read(b []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
+
+func readLines(t *testing.T, lr *lineReader) []string {
+	var lines []string
+	for {
+		line, err := lr.ReadLine()
+		if err == io.EOF {
+			return lines
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(line))
+	}
+}
+
+func TestLineReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		chunks   []string
+		expected []string
+	}{
+		{
+			name:     "lf",
+			chunks:   []string{"1\n", "0712345678\n"},
+			expected: []string{"1", "0712345678"},
+		},
+		{
+			name:     "crlf split across reads",
+			chunks:   []string{"1\r", "\n2\r\n"},
+			expected: []string{"1", "2"},
+		},
+		{
+			name:     "cr",
+			chunks:   []string{"1\r2\r"},
+			expected: []string{"1", "2"},
+		},
+		{
+			name:     "partial reads",
+			chunks:   []string{"07", "1234", "5678\n"},
+			expected: []string{"0712345678"},
+		},
+		{
+			name:     "empty line",
+			chunks:   []string{"\n1\n"},
+			expected: []string{"", "1"},
+		},
+		{
+			name:     "backspace",
+			chunks:   []string{"12\x7f3\n", "\x08\x084\n"},
+			expected: []string{"13", "4"},
+		},
+		{
+			name:     "arrow keys",
+			chunks:   []string{"1\x1b[A\x1b[D2\n"},
+			expected: []string{"12"},
+		},
+		{
+			name:     "ctrl-c",
+			chunks:   []string{"1\n2\x03\n3\n"},
+			expected: []string{"1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lr := newLineReader(&chunkReader{chunks: tt.chunks}, io.Discard, &terminal{})
+			lines := readLines(t, lr)
+			if strings.Join(lines, "|") != strings.Join(tt.expected, "|") {
+				t.Fatalf("expected %q, got %q", tt.expected, lines)
+			}
+		})
+	}
+}
+
+func TestLineReaderEcho(t *testing.T) {
+	var b bytes.Buffer
+	term := &terminal{}
+	ok := term.handleRequest(&ssh.Request{
+		Type:    "pty-req",
+		Payload: ssh.Marshal(ptyRequest{Term: "xterm", Cols: 80, Rows: 24}),
+	})
+	if !ok {
+		t.Fatal("pty request rejected")
+	}
+	lr := newLineReader(&chunkReader{chunks: []string{"12\x7f3\r"}}, &b, term)
+	line, err := lr.ReadLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(line) != "13" {
+		t.Fatalf("expected 13, got %s", line)
+	}
+	if b.String() != "12\b \b3\r\n" {
+		t.Fatalf("unexpected echo %q", b.String())
+	}
+}
+
+func TestFrame(t *testing.T) {
+	term := &terminal{}
+	out := string(term.Frame([]byte("Balance: 10 SRF\n1:Send\n2:My Vouchers"), 160))
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	// 160 characters take 6 lines of 30
+	if len(lines) != 8 {
+		t.Fatalf("expected 8 lines, got %d:\n%s", len(lines), out)
+	}
+	for _, line := range lines {
+		if utf8.RuneCountInString(line) != frameWidth+4 {
+			t.Fatalf("unexpected width of line '%s'", line)
+		}
+	}
+	if lines[2] != "│ 1:Send                         │" {
+		t.Fatalf("unexpected line '%s'", lines[2])
+	}
+
+	// the frame shrinks with the window, wrapping the text
+	term.handleRequest(&ssh.Request{
+		Type:    "pty-req",
+		Payload: ssh.Marshal(ptyRequest{Term: "xterm", Cols: 80, Rows: 24}),
+	})
+	term.handleRequest(&ssh.Request{
+		Type:    "window-change",
+		Payload: ssh.Marshal(windowChange{Cols: 16, Rows: 24}),
+	})
+	out = string(term.Frame([]byte("Enter recipient's phone number"), 20))
+	expected := "┌──────────────┐\r\n" +
+		"│ Enter        │\r\n" +
+		"│ recipient's  │\r\n" +
+		"│ phone number │\r\n" +
+		"└──────────────┘\r\n"
+	if out != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, out)
+	}
+}
@@ -34,6 +34,8 @@ func(tdb *ThreadGdbmDb) Connect(ctx context.Context, connStr string) error {
 	_, ok = dbC[connStr]
 	if ok {
 		logg.WarnCtxf(ctx, "already registered thread gdbm, skipping", "connStr", connStr)
+		// share the registered connection, waiting for its other users on each operation
+		tdb.connStr = connStr
 		return nil
 	}
 	gdb := gdbmdb.NewGdbmDb()
@@ -9,6 +9,15 @@ const (
 	DATATYPE_EXTEND = 128
 )
 
+// Key prefixes for the different kinds of data stored under DATATYPE_EXTEND.
+const (
+	EXTEND_SSH_KEY = iota + 1
+	EXTEND_RATE_LIMIT
+	EXTEND_GATEWAY_SESSION
+	EXTEND_HEALTH
+	EXTEND_TX_NOTIFIED
+)
+
 type Storage struct {
 	Persister *persist.Persister
 	UserdataDb db.Db	
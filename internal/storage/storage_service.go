@@ -34,6 +34,8 @@ type MenuStorageService struct {
 	resourceStore db.Db
 	stateStore    db.Db
 	userDataStore db.Db
+	stateNamespace string
+	handles       []db.Db
 }
 
 func NewMenuStorageService(conn ConnData, resourceDir string) *MenuStorageService {
@@ -43,7 +45,20 @@ func NewMenuStorageService(conn ConnData, resourceDir string) *MenuStorageServic
 	}
 }
 
+// WithStateNamespace keeps the state of the sessions apart from the state stored by other services on the same connection.
+//
+// It is used when several menu trees are served by one process, so that a session in one menu cannot resume at a node of another.
+func (ms *MenuStorageService) WithStateNamespace(ns string) *MenuStorageService {
+	ms.stateNamespace = ns
+	return ms
+}
+
 func (ms *MenuStorageService) getOrCreateDb(ctx context.Context, existingDb db.Db, section string) (db.Db, error) {
+	return ms.getOrCreateNamespacedDb(ctx, existingDb, section, "")
+}
+
+// getOrCreateNamespacedDb connects to the store for the section, in a separate schema or file if a namespace is given.
+func (ms *MenuStorageService) getOrCreateNamespacedDb(ctx context.Context, existingDb db.Db, section string, ns string) (db.Db, error) {
 	var newDb db.Db
 	var err error
 
@@ -55,17 +70,24 @@ func (ms *MenuStorageService) getOrCreateDb(ctx context.Context, existingDb db.D
 	connStr := ms.conn.String()
 	dbTyp := ms.conn.DbType()
 	if dbTyp == DBTYPE_POSTGRES {
+		schema := ms.conn.Domain()
+		if ns != "" {
+			schema = schema + "_" + ns
+		}
 		// TODO: move to vise
-		err = ensureSchemaExists(ctx, ms.conn)
+		err = ensureSchemaExists(ctx, ms.conn, schema)
 		if err != nil {
 			return nil, err
 		}
-		newDb = postgres.NewPgDb().WithSchema(ms.conn.Domain())
+		newDb = postgres.NewPgDb().WithSchema(schema)
 	} else if dbTyp == DBTYPE_GDBM {
 		err = ms.ensureDbDir()
 		if err != nil {
 			return nil, err
 		}
+		if ns != "" {
+			section = ns + "_" + section
+		}
 		connStr = path.Join(connStr, section)
 		newDb = gdbmstorage.NewThreadGdbmDb()
 	} else {
@@ -103,14 +125,14 @@ func (ms *MenuStorageService) WithGettext(path string, lns []lang.Language) *Men
 }
 
 // ensureSchemaExists creates a new schema if it does not exist
-func ensureSchemaExists(ctx context.Context, conn ConnData) error {
+func ensureSchemaExists(ctx context.Context, conn ConnData, schema string) error {
 	h, err := pgxpool.New(ctx, conn.Path())
 	if err != nil {
 		return fmt.Errorf("failed to connect to the database: %w", err)
 	}
 	defer h.Close()
 
-	query := fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", conn.Domain())
+	query := fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", schema)
 	_, err = h.Exec(ctx, query)
 	if err != nil {
 		return fmt.Errorf("failed to create schema: %w", err)
@@ -144,11 +166,17 @@ func (ms *MenuStorageService) GetUserdataDb(ctx context.Context) (db.Db, error)
 	return ms.userDataStore, nil
 }
 
+// GetResource returns a new resource for the menus and templates in the resource dir.
+//
+// The resource store is connected on the first call, and shared by the resources returned by later calls. Each resource has its own set of local functions.
 func (ms *MenuStorageService) GetResource(ctx context.Context) (resource.Resource, error) {
-	ms.resourceStore = fsdb.NewFsDb()
-	err := ms.resourceStore.Connect(ctx, ms.resourceDir)
-	if err != nil {
-		return nil, err
+	if ms.resourceStore == nil {
+		resourceStore := fsdb.NewFsDb()
+		err := resourceStore.Connect(ctx, ms.resourceDir)
+		if err != nil {
+			return nil, err
+		}
+		ms.resourceStore = resourceStore
 	}
 	rfs := resource.NewDbResource(ms.resourceStore)
 	if ms.poResource != nil {
@@ -164,7 +192,7 @@ func (ms *MenuStorageService) GetStateStore(ctx context.Context) (db.Db, error)
 		return ms.stateStore, nil
 	}
 
-	stateStore, err := ms.getOrCreateDb(ctx, ms.stateStore, "state.gdbm")
+	stateStore, err := ms.getOrCreateNamespacedDb(ctx, ms.stateStore, "state.gdbm", ms.stateNamespace)
 	if err != nil {
 		return nil, err
 	}
@@ -173,6 +201,42 @@ func (ms *MenuStorageService) GetStateStore(ctx context.Context) (db.Db, error)
 	return ms.stateStore, nil
 }
 
+// GetStateStoreHandle returns a handle on the state store apart from the one returned by GetStateStore.
+//
+// The prefix and session set on the handle do not affect the requests using the shared store, so that it can be read from alongside them, e.g. by health checks.
+func (ms *MenuStorageService) GetStateStoreHandle(ctx context.Context) (db.Db, error) {
+	_, err := ms.GetStateStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ms.newHandle(ctx, "state.gdbm", ms.stateNamespace)
+}
+
+// GetUserdataDbHandle returns a handle on the userdata store apart from the one returned by GetUserdataDb.
+//
+// See GetStateStoreHandle.
+func (ms *MenuStorageService) GetUserdataDbHandle(ctx context.Context) (db.Db, error) {
+	_, err := ms.GetUserdataDb(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ms.newHandle(ctx, "userdata.gdbm", "")
+}
+
+// newHandle connects a new handle on the store of the section.
+//
+// A gdbm handle shares the connection of the store, taking turns with its other users, and is closed with it. Other handles are closed by Close.
+func (ms *MenuStorageService) newHandle(ctx context.Context, section string, ns string) (db.Db, error) {
+	store, err := ms.getOrCreateNamespacedDb(ctx, nil, section, ns)
+	if err != nil {
+		return nil, err
+	}
+	if ms.conn.DbType() != DBTYPE_GDBM {
+		ms.handles = append(ms.handles, store)
+	}
+	return store, nil
+}
+
 func (ms *MenuStorageService) ensureDbDir() error {
 	err := os.MkdirAll(ms.conn.String(), 0700)
 	if err != nil {
@@ -181,10 +245,27 @@ func (ms *MenuStorageService) ensureDbDir() error {
 	return nil
 }
 
+// Close closes the stores opened by the service.
+//
+// The handles returned by GetStateStoreHandle and GetUserdataDbHandle are closed first, then the state store, the userdata store, and the resource store last.
 func (ms *MenuStorageService) Close() error {
-	errA := ms.stateStore.Close()
-	errB := ms.userDataStore.Close()
-	errC := ms.resourceStore.Close()
+	var errA, errB, errC error
+	for _, store := range ms.handles {
+		err := store.Close()
+		if err != nil {
+			logg.Errorf("store handle close failed", "err", err)
+		}
+	}
+	ms.handles = nil
+	if ms.stateStore != nil {
+		errA = ms.stateStore.Close()
+	}
+	if ms.userDataStore != nil {
+		errB = ms.userDataStore.Close()
+	}
+	if ms.resourceStore != nil {
+		errC = ms.resourceStore.Close()
+	}
 	if errA != nil || errB != nil || errC != nil {
 		return fmt.Errorf("%v %v %v", errA, errB, errC)
 	}
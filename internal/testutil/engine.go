@@ -13,6 +13,7 @@ import (
 	"git.defalsify.org/vise.git/engine"
 	"git.defalsify.org/vise.git/logging"
 	"git.defalsify.org/vise.git/resource"
+	"git.grassecon.net/urdt/ussd/common"
 	"git.grassecon.net/urdt/ussd/config"
 	"git.grassecon.net/urdt/ussd/initializers"
 	"git.grassecon.net/urdt/ussd/internal/handlers"
@@ -98,7 +99,9 @@ func updateSearchPath(connStr string, newSearchPath string) (string, error) {
 func TestEngine(sessionId string) (engine.Engine, func(), chan bool) {
 	var err error
 	ctx := context.Background()
-	ctx = context.WithValue(ctx, "SessionId", sessionId)
+	ctx = common.WithRequestContext(ctx, common.RequestContext{
+		SessionId: sessionId,
+	})
 	pfp := path.Join(scriptDir, "pp.csv")
 
 	var eventChannel = make(chan bool)
@@ -159,13 +162,12 @@ func TestEngine(sessionId string) (engine.Engine, func(), chan bool) {
 	}
 
 	lhs, err := handlers.NewLocalHandlerService(ctx, pfp, true, dbResource, cfg, rs)
-	lhs.SetDataStore(&userDataStore)
-	lhs.SetPersister(pe)
-
 	if err != nil {
 		fmt.Fprintf(os.Stderr, err.Error())
 		os.Exit(1)
 	}
+	lhs.SetDataStore(&userDataStore)
+	lhs.SetPersister(pe)
 
 	if testtag.AccountService == nil {
 		testtag.AccountService = &remote.AccountService{}
@@ -0,0 +1,180 @@
+package gatewaytest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.defalsify.org/vise.git/engine"
+
+	"git.grassecon.net/urdt/ussd/common"
+	"git.grassecon.net/urdt/ussd/internal/handlers"
+	httpserver "git.grassecon.net/urdt/ussd/internal/http"
+	"git.grassecon.net/urdt/ussd/internal/testutil/mocks/httpmocks"
+)
+
+// Fixture is a recorded exchange between a gateway and the menu server.
+type Fixture struct {
+	// ContentType of the request body.
+	ContentType string `json:"content_type"`
+	// Request is the body of the request, either a JSON value or a string holding the body as is.
+	Request json.RawMessage `json:"request"`
+	// Expect holds the values the gateway should extract from the request.
+	Expect struct {
+		SessionId        string `json:"session_id"`
+		GatewaySessionId string `json:"gateway_session_id"`
+		NetworkCode      string `json:"network_code"`
+		Input            string `json:"input"`
+		// InputChain are the inputs entered before Input, for gateways sending them with the request.
+		InputChain []string `json:"input_chain"`
+	} `json:"expect"`
+	// Menu is the output of the menu for the request.
+	Menu struct {
+		Content  string `json:"content"`
+		Continue bool   `json:"continue"`
+	} `json:"menu"`
+	// Ended is true if the request notifies the end of the session, in which case it is not passed to the menu.
+	Ended bool `json:"ended"`
+	// Status is the expected status code of the response.
+	Status int `json:"status"`
+	// Response is the expected body of the response, either a JSON value or a string holding the body as is.
+	Response json.RawMessage `json:"response"`
+}
+
+// raw returns the value as is if it is a JSON string, and the encoded value otherwise.
+func raw(v json.RawMessage) (string, bool) {
+	var s string
+	err := json.Unmarshal(v, &s)
+	if err == nil {
+		return s, false
+	}
+	return string(v), true
+}
+
+// RunFixtures serves the requests of the fixture files matching the pattern with the gateway, and checks that the gateway parses them and frames the menu output as recorded.
+func RunFixtures(t *testing.T, gw httpserver.Gateway, pattern string) {
+	fps, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fps) == 0 {
+		t.Fatalf("no fixtures matching %s", pattern)
+	}
+	for _, fp := range fps {
+		t.Run(filepath.Base(fp), func(t *testing.T) {
+			var fx Fixture
+			b, err := os.ReadFile(fp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = json.Unmarshal(b, &fx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			runFixture(t, gw, fx)
+		})
+	}
+}
+
+func runFixture(t *testing.T, gw httpserver.Gateway, fx Fixture) {
+	var got handlers.RequestSession
+	var processed bool
+
+	me := &httpmocks.MockEngine{
+		FlushFunc: func(ctx context.Context, w io.Writer) (int, error) {
+			return io.WriteString(w, fx.Menu.Content)
+		},
+	}
+	mh := &httpmocks.MockRequestHandler{
+		GetConfigFunc: func() engine.Config {
+			return engine.Config{}
+		},
+		ProcessFunc: func(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+			got = rqs
+			processed = true
+			rqs.Engine = me
+			rqs.Continue = fx.Menu.Continue
+			return rqs, nil
+		},
+		OutputFunc: func(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+			_, err := rqs.Engine.Flush(rqs.Ctx, rqs.Writer)
+			return rqs, err
+		},
+		ResetFunc: func(rqs handlers.RequestSession) (handlers.RequestSession, error) {
+			return rqs, nil
+		},
+	}
+
+	body, _ := raw(fx.Request)
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", fx.ContentType)
+	w := httptest.NewRecorder()
+	httpserver.NewGatewaySessionHandler(mh, gw).ServeHTTP(w, req)
+
+	status := fx.Status
+	if status == 0 {
+		status = 200
+	}
+	if w.Code != status {
+		t.Fatalf("expected status %d, got %d: %s", status, w.Code, w.Body.String())
+	}
+	if status != 200 {
+		return
+	}
+	if fx.Ended {
+		if processed {
+			t.Errorf("expected end of session not to be passed to the menu")
+		}
+		return
+	}
+
+	if got.Config.SessionId != fx.Expect.SessionId {
+		t.Errorf("expected session id '%s', got '%s'", fx.Expect.SessionId, got.Config.SessionId)
+	}
+	if got.GatewaySessionId != fx.Expect.GatewaySessionId {
+		t.Errorf("expected gateway session id '%s', got '%s'", fx.Expect.GatewaySessionId, got.GatewaySessionId)
+	}
+	rc, _ := common.GetRequestContext(got.Ctx)
+	if rc.NetworkCode != fx.Expect.NetworkCode {
+		t.Errorf("expected network code '%s', got '%s'", fx.Expect.NetworkCode, rc.NetworkCode)
+	}
+	if string(got.Input) != fx.Expect.Input {
+		t.Errorf("expected input '%s', got '%s'", fx.Expect.Input, got.Input)
+	}
+	var chain []string
+	for _, v := range got.InputChain {
+		chain = append(chain, string(v))
+	}
+	if !reflect.DeepEqual(chain, fx.Expect.InputChain) {
+		t.Errorf("expected input chain %v, got %v", fx.Expect.InputChain, chain)
+	}
+
+	expect, isJson := raw(fx.Response)
+	if !isJson {
+		if w.Body.String() != expect {
+			t.Errorf("expected response %q, got %q", expect, w.Body.String())
+		}
+		return
+	}
+	var expectV any
+	var gotV any
+	err := json.Unmarshal([]byte(expect), &expectV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.Unmarshal(bytes.TrimSpace(w.Body.Bytes()), &gotV)
+	if err != nil {
+		t.Fatalf("response is not valid json: %v: %s", err, w.Body.String())
+	}
+	if !reflect.DeepEqual(expectV, gotV) {
+		t.Errorf("expected response %s, got %s", expect, w.Body.String())
+	}
+}
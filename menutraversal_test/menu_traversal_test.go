@@ -368,9 +368,17 @@ func TestGroups(t *testing.T) {
 			}
 			b := w.Bytes()
 			balance := extractBalance(b)
+			publicKey := extractPublicKey(b)
+			max_amount := extractMaxAmount(b)
+			send_amount := extractSendAmount(b)
 
+			// the placeholders of groups recorded with the recorder package
 			expectedContent := []byte(tt.ExpectedContent)
 			expectedContent = bytes.Replace(expectedContent, []byte("{balance}"), []byte(balance), -1)
+			expectedContent = bytes.Replace(expectedContent, []byte("{public_key}"), []byte(publicKey), -1)
+			expectedContent = bytes.Replace(expectedContent, []byte("{max_amount}"), []byte(max_amount), -1)
+			expectedContent = bytes.Replace(expectedContent, []byte("{send_amount}"), []byte(send_amount), -1)
+			expectedContent = bytes.Replace(expectedContent, []byte("{session_id}"), []byte(sessionID), -1)
 
 			tt.ExpectedContent = string(expectedContent)
 
@@ -9,8 +9,10 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"time"
 
 	"git.grassecon.net/urdt/ussd/config"
+	"git.grassecon.net/urdt/ussd/internal/metrics"
 	"git.grassecon.net/urdt/ussd/models"
 	"github.com/grassrootseconomics/eth-custodial/pkg/api"
 	dataserviceapi "github.com/grassrootseconomics/ussd-data-service/pkg/api"
@@ -52,7 +54,7 @@ func (as *AccountService) TrackAccountStatus(ctx context.Context, publicKey stri
 		return nil, err
 	}
 
-	_, err = doRequest(ctx, req, &r)
+	_, err = doRequest(ctx, "track_account_status", req, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -76,7 +78,7 @@ func (as *AccountService) CheckBalance(ctx context.Context, publicKey string) (*
 		return nil, err
 	}
 
-	_, err = doRequest(ctx, req, &balanceResult)
+	_, err = doRequest(ctx, "check_balance", req, &balanceResult)
 	return &balanceResult, err
 }
 
@@ -93,7 +95,7 @@ func (as *AccountService) CreateAccount(ctx context.Context) (*models.AccountRes
 	if err != nil {
 		return nil, err
 	}
-	_, err = doRequest(ctx, req, &r)
+	_, err = doRequest(ctx, "create_account", req, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -119,7 +121,7 @@ func (as *AccountService) FetchVouchers(ctx context.Context, publicKey string) (
 		return nil, err
 	}
 
-	_, err = doRequest(ctx, req, &r)
+	_, err = doRequest(ctx, "fetch_vouchers", req, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -145,7 +147,7 @@ func (as *AccountService) FetchTransactions(ctx context.Context, publicKey strin
 		return nil, err
 	}
 
-	_, err = doRequest(ctx, req, &r)
+	_, err = doRequest(ctx, "fetch_transactions", req, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -171,7 +173,7 @@ func (as *AccountService) VoucherData(ctx context.Context, address string) (*mod
 		return nil, err
 	}
 
-	_, err = doRequest(ctx, req, &r)
+	_, err = doRequest(ctx, "voucher_data", req, &r)
 	return &r.TokenDetails, err
 }
 
@@ -202,7 +204,7 @@ func (as *AccountService) TokenTransfer(ctx context.Context, amount, from, to, t
 	if err != nil {
 		return nil, err
 	}
-	_, err = doRequest(ctx, req, &r)
+	_, err = doRequest(ctx, "token_transfer", req, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -226,14 +228,22 @@ func (as *AccountService) CheckAliasAddress(ctx context.Context, alias string) (
 		return nil, err
 	}
 
-	_, err = doRequest(ctx, req, &r)
+	_, err = doRequest(ctx, "check_alias_address", req, &r)
 	return &r, err
 }
 
-func doRequest(ctx context.Context, req *http.Request, rcpt any) (*api.OKResponse, error) {
+// doRequest sends the request to the service, and decodes the result into rcpt.
+//
+// The latency of the call is recorded under the given call name.
+func doRequest(ctx context.Context, call string, req *http.Request, rcpt any) (res *api.OKResponse, err error) {
 	var okResponse api.OKResponse
 	var errResponse api.ErrResponse
 
+	start := time.Now()
+	defer func() {
+		metrics.RemoteCall.Since(start, call, metrics.Outcome(err))
+	}()
+
 	req.Header.Set("Authorization", "Bearer "+config.BearerToken)
 	req.Header.Set("Content-Type", "application/json")
 